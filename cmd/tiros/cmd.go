@@ -18,6 +18,7 @@ func main() {
 		Name: "tiros",
 		Commands: []*cli.Command{
 			probeCmd,
+			controlledCmd,
 			plcli.NewHealthCommand(),
 		},
 	})
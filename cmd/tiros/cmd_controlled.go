@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/kubo"
+	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+var controlledConfig = struct {
+	KuboHost    string
+	KuboAPIPort int
+}{
+	KuboHost:    "127.0.0.1",
+	KuboAPIPort: 5001,
+}
+
+var controlledCmd = &cli.Command{
+	Name:  "controlled",
+	Usage: "Generate, verify and publish the set of controlled CIDs",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "kubo.host",
+			Usage:       "Host at which to reach Kubo",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_KUBO_HOST"),
+			Value:       controlledConfig.KuboHost,
+			Destination: &controlledConfig.KuboHost,
+		},
+		&cli.IntFlag{
+			Name:        "kubo.api.port",
+			Usage:       "port to reach a Kubo-compatible RPC API",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_KUBO_API_PORT"),
+			Value:       controlledConfig.KuboAPIPort,
+			Destination: &controlledConfig.KuboAPIPort,
+		},
+	},
+	Commands: []*cli.Command{
+		controlledGenerateCmd,
+		controlledVerifyCmd,
+	},
+}
+
+var controlledGenerateConfig = struct {
+	Classes     []string
+	Seed        uint64
+	Count       int
+	OutDir      string
+	Concurrency int
+}{
+	Classes:     []string{},
+	Seed:        0,
+	Count:       0,
+	OutDir:      "pkg/controlled",
+	Concurrency: 8,
+}
+
+var controlledGenerateCmd = &cli.Command{
+	Name:  "generate",
+	Usage: "Deterministically generate the content of controlled classes from a seed, add it to Kubo and write the CID files",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "class",
+			Usage:       "The controlled classes to generate (default: all classes)",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_GENERATE_CLASS"),
+			Value:       controlledGenerateConfig.Classes,
+			Destination: &controlledGenerateConfig.Classes,
+		},
+		&cli.Uint64Flag{
+			Name:        "seed",
+			Usage:       "The seed from which the content is derived",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_GENERATE_SEED"),
+			Value:       controlledGenerateConfig.Seed,
+			Destination: &controlledGenerateConfig.Seed,
+		},
+		&cli.IntFlag{
+			Name:        "count",
+			Usage:       "The number of CIDs to generate per class (0 uses the default count of the class)",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_GENERATE_COUNT"),
+			Value:       controlledGenerateConfig.Count,
+			Destination: &controlledGenerateConfig.Count,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Usage:       "The directory to write the <class>.txt CID files to",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_GENERATE_OUT"),
+			Value:       controlledGenerateConfig.OutDir,
+			Destination: &controlledGenerateConfig.OutDir,
+		},
+		&cli.IntFlag{
+			Name:        "concurrency",
+			Usage:       "Number of blobs to add to Kubo concurrently",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_GENERATE_CONCURRENCY"),
+			Value:       controlledGenerateConfig.Concurrency,
+			Destination: &controlledGenerateConfig.Concurrency,
+		},
+	},
+	Action: controlledGenerateAction,
+}
+
+var controlledVerifyConfig = struct {
+	In          string
+	Hosts       []string
+	Sample      int
+	Timeout     time.Duration
+	Concurrency int
+}{
+	In:          "",
+	Hosts:       []string{},
+	Sample:      0,
+	Timeout:     time.Minute,
+	Concurrency: 16,
+}
+
+var controlledVerifyCmd = &cli.Command{
+	Name:  "verify",
+	Usage: "Check through Kubo that every controlled CID is still provided and retrievable",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "in",
+			Usage:       "A CID file to verify (defaults to the embedded controlled CIDs)",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_VERIFY_IN"),
+			Value:       controlledVerifyConfig.In,
+			Destination: &controlledVerifyConfig.In,
+		},
+		&cli.StringSliceFlag{
+			Name:        "hosts",
+			Usage:       "Peer IDs of the nodes that are supposed to host the controlled CIDs",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_VERIFY_HOSTS"),
+			Value:       controlledVerifyConfig.Hosts,
+			Destination: &controlledVerifyConfig.Hosts,
+		},
+		&cli.IntFlag{
+			Name:        "sample",
+			Usage:       "Only verify a random sample of this many CIDs (0 verifies all)",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_VERIFY_SAMPLE"),
+			Value:       controlledVerifyConfig.Sample,
+			Destination: &controlledVerifyConfig.Sample,
+		},
+		&cli.DurationFlag{
+			Name:        "timeout",
+			Usage:       "Timeout for the provider lookup and retrieval of each CID",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_VERIFY_TIMEOUT"),
+			Value:       controlledVerifyConfig.Timeout,
+			Destination: &controlledVerifyConfig.Timeout,
+		},
+		&cli.IntFlag{
+			Name:        "concurrency",
+			Usage:       "Number of CIDs to verify concurrently",
+			Sources:     cli.EnvVars("TIROS_CONTROLLED_VERIFY_CONCURRENCY"),
+			Value:       controlledVerifyConfig.Concurrency,
+			Destination: &controlledVerifyConfig.Concurrency,
+		},
+	},
+	Action: controlledVerifyAction,
+}
+
+func newControlledKubo(ctx context.Context) (*kubo.Kubo, error) {
+	k, err := kubo.NewKubo(&kubo.KuboConfig{
+		Host:    controlledConfig.KuboHost,
+		APIPort: controlledConfig.KuboAPIPort,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating kubo client: %w", err)
+	}
+
+	if err := k.WaitAvailable(ctx, time.Minute); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func controlledGenerateAction(ctx context.Context, cmd *cli.Command) error {
+	cfg := controlledGenerateConfig
+
+	classes := pkg.ControlledClasses
+	if len(cfg.Classes) > 0 {
+		classes = make([]pkg.ControlledClass, 0, len(cfg.Classes))
+		for _, name := range cfg.Classes {
+			class, found := pkg.ControlledClassByName(name)
+			if !found {
+				return fmt.Errorf("unknown controlled class %q", name)
+			}
+			classes = append(classes, class)
+		}
+	}
+
+	k, err := newControlledKubo(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, class := range classes {
+		if err := generateControlledClass(ctx, k, class); err != nil {
+			return fmt.Errorf("generating class %s: %w", class.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func generateControlledClass(ctx context.Context, k *kubo.Kubo, class pkg.ControlledClass) error {
+	cfg := controlledGenerateConfig
+
+	count := class.Count
+	if cfg.Count > 0 {
+		count = cfg.Count
+	}
+
+	slog.Info(fmt.Sprintf("Generating %d CIDs of controlled class %s with seed %d", count, class.Name, cfg.Seed))
+
+	opts := []options.UnixfsAddOption{
+		options.Unixfs.Pin(true, ""),
+		options.Unixfs.CidVersion(class.CIDVersion),
+		options.Unixfs.RawLeaves(class.CIDVersion > 0),
+		options.Unixfs.Chunker(class.Chunker()),
+		options.Unixfs.Layout(options.BalancedLayout),
+	}
+
+	cids := make([]cid.Cid, count)
+
+	errg, errCtx := errgroup.WithContext(ctx)
+	errg.SetLimit(max(1, cfg.Concurrency))
+	for i := range count {
+		errg.Go(func() error {
+			expected, err := class.Root(errCtx, cfg.Seed, i)
+			if err != nil {
+				return fmt.Errorf("deriving root of blob %d: %w", i, err)
+			}
+
+			p, err := k.Unixfs().Add(errCtx, class.Node(cfg.Seed, i), opts...)
+			if err != nil {
+				return fmt.Errorf("adding blob %d: %w", i, err)
+			}
+
+			// the CID files are derived without Kubo, so make sure Kubo
+			// imported the content the same way.
+			if !p.RootCid().Equals(expected) {
+				return fmt.Errorf("kubo imported blob %d as %s but expected %s, check the kubo import settings", i, p.RootCid(), expected)
+			}
+
+			if err := k.Routing().Provide(errCtx, p); err != nil {
+				slog.Warn("Failed providing controlled CID", "cid", expected.String(), "err", err)
+			}
+
+			cids[i] = expected
+
+			if (i+1)%256 == 0 {
+				slog.Info(fmt.Sprintf("Added %d/%d blobs", i+1, count))
+			}
+
+			return nil
+		})
+	}
+
+	if err := errg.Wait(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	comment := fmt.Sprintf("Each CID corresponds to a %s of %d bytes of deterministic random data.\n"+
+		"Generated with: tiros controlled generate --class %s --seed %d --count %d",
+		controlledShapeDescriptions[class.Shape], class.Size, class.Name, cfg.Seed, count)
+	if err := pkg.WriteControlledCIDs(&buf, comment, cids); err != nil {
+		return fmt.Errorf("formatting cid file: %w", err)
+	}
+
+	out := filepath.Join(cfg.OutDir, class.Name+".txt")
+	if err := os.WriteFile(out, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("writing cid file: %w", err)
+	}
+
+	slog.Info(fmt.Sprintf("Wrote %d controlled CIDs to %s", len(cids), out))
+
+	return nil
+}
+
+var controlledShapeDescriptions = map[pkg.ControlledShape]string{
+	pkg.ControlledShapeRaw:  "single raw block",
+	pkg.ControlledShapeFile: "chunked UnixFS file",
+	pkg.ControlledShapeDir:  fmt.Sprintf("UnixFS directory of %d files", pkg.ControlledDirEntries),
+}
+
+type controlledVerifyResult struct {
+	cid         cid.Cid
+	providers   map[peer.ID]struct{}
+	findErr     error
+	retrieveErr error
+}
+
+func controlledVerifyAction(ctx context.Context, cmd *cli.Command) error {
+	cfg := controlledVerifyConfig
+
+	hosts := make([]peer.ID, 0, len(cfg.Hosts))
+	for _, h := range cfg.Hosts {
+		pid, err := peer.Decode(strings.TrimSpace(h))
+		if err != nil {
+			return fmt.Errorf("invalid host peer id %q: %w", h, err)
+		}
+		hosts = append(hosts, pid)
+	}
+
+	cids := pkg.ControlledCIDs()
+	if cfg.In != "" {
+		data, err := os.ReadFile(cfg.In)
+		if err != nil {
+			return fmt.Errorf("reading cid file: %w", err)
+		}
+
+		entries, err := pkg.ParseCIDEntries(cfg.In, data)
+		if err != nil {
+			return fmt.Errorf("parsing cid file: %w", err)
+		}
+
+		cids = make([]cid.Cid, 0, len(entries))
+		for _, e := range entries {
+			cids = append(cids, e.CID)
+		}
+	}
+
+	if cfg.Sample > 0 && cfg.Sample < len(cids) {
+		rand.Shuffle(len(cids), func(i, j int) { cids[i], cids[j] = cids[j], cids[i] })
+		cids = cids[:cfg.Sample]
+	}
+
+	k, err := newControlledKubo(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Verifying %d controlled CIDs against %d hosts", len(cids), len(hosts)))
+
+	var (
+		resultsMu sync.Mutex
+		results   = make([]*controlledVerifyResult, 0, len(cids))
+	)
+
+	errg, errCtx := errgroup.WithContext(ctx)
+	errg.SetLimit(max(1, cfg.Concurrency))
+	for _, c := range cids {
+		errg.Go(func() error {
+			res := &controlledVerifyResult{cid: c, providers: map[peer.ID]struct{}{}}
+
+			findCtx, findCancel := context.WithTimeout(errCtx, cfg.Timeout)
+			provs, err := k.FindProvs(findCtx, c, 20)
+			findCancel()
+			res.findErr = err
+			for _, p := range provs {
+				res.providers[p] = struct{}{}
+			}
+
+			statCtx, statCancel := context.WithTimeout(errCtx, cfg.Timeout)
+			_, res.retrieveErr = k.DAGStat(statCtx, c)
+			statCancel()
+
+			resultsMu.Lock()
+			results = append(results, res)
+			done := len(results)
+			resultsMu.Unlock()
+
+			if done%256 == 0 {
+				slog.Info(fmt.Sprintf("Verified %d/%d CIDs", done, len(cids)))
+			}
+
+			return errCtx.Err()
+		})
+	}
+
+	if err := errg.Wait(); err != nil {
+		return err
+	}
+
+	return printControlledVerifyReport(results, hosts)
+}
+
+func printControlledVerifyReport(results []*controlledVerifyResult, hosts []peer.ID) error {
+	var (
+		unretrievable []*controlledVerifyResult
+		lookupFailed  []*controlledVerifyResult
+		unprovided    []cid.Cid
+		missingByHost = map[peer.ID][]cid.Cid{}
+	)
+
+	for _, res := range results {
+		if res.retrieveErr != nil {
+			unretrievable = append(unretrievable, res)
+		}
+
+		// a failed lookup may have found some of the providers, but it
+		// doesn't tell whether there are none
+		if res.findErr != nil {
+			lookupFailed = append(lookupFailed, res)
+		} else if len(res.providers) == 0 {
+			unprovided = append(unprovided, res.cid)
+		}
+
+		for _, h := range hosts {
+			if _, found := res.providers[h]; !found {
+				missingByHost[h] = append(missingByHost[h], res.cid)
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "CIDs verified\t%d\n", len(results))
+	fmt.Fprintf(tw, "Not retrievable\t%d\n", len(unretrievable))
+	fmt.Fprintf(tw, "Provider lookup failed\t%d\n", len(lookupFailed))
+	fmt.Fprintf(tw, "Without any provider record\t%d\n", len(unprovided))
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "HOST\tMISSING RECORDS\tEXAMPLES")
+	for _, h := range hosts {
+		missing := missingByHost[h]
+		examples := make([]string, 0, 3)
+		for _, c := range missing[:min(3, len(missing))] {
+			examples = append(examples, c.String())
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", h, len(missing), strings.Join(examples, ","))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, res := range unretrievable[:min(10, len(unretrievable))] {
+		slog.With("cid", res.cid.String(), "err", res.retrieveErr).Warn("Controlled CID not retrievable")
+	}
+
+	for _, res := range lookupFailed[:min(10, len(lookupFailed))] {
+		slog.With("cid", res.cid.String(), "err", res.findErr).Warn("Failed looking up providers of controlled CID")
+	}
+
+	missingHosts := slices.ContainsFunc(hosts, func(h peer.ID) bool { return len(missingByHost[h]) > 0 })
+	if len(unretrievable) > 0 || len(lookupFailed) > 0 || len(unprovided) > 0 || missingHosts {
+		return fmt.Errorf("controlled CID set verification failed")
+	}
+
+	return nil
+}
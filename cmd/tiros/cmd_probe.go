@@ -5,24 +5,44 @@ import (
 	"fmt"
 	"log/slog"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ipfs/go-cid"
 	plcli "github.com/probe-lab/go-commons/cli"
 	pldb "github.com/probe-lab/go-commons/db"
+	"github.com/probe-lab/tiros/pkg"
 	"github.com/probe-lab/tiros/pkg/db"
 	"github.com/urfave/cli/v3"
 )
 
 var probeConfig = struct {
-	DryRun     bool
-	JSONOut    string
-	Timeout    time.Duration
-	Clickhouse *pldb.ClickHouseConfig
-	Migrations *pldb.ClickHouseMigrationsConfig
+	DryRun      bool
+	JSONOut     string
+	Timeout     time.Duration
+	CIDSources  []string
+	CIDClasses  []string
+	CIDFile     string
+	CIDReload   time.Duration
+	CIDPool     *pkg.PooledBitswapSnifferConfig
+	CIDSchedule *cidScheduleConfig
+	Clickhouse  *pldb.ClickHouseConfig
+	Migrations  *pldb.ClickHouseMigrationsConfig
 }{
 	DryRun:     false,
 	JSONOut:    "",
 	Timeout:    0,
+	CIDSources: []string{},
+	CIDClasses: []string{},
+	CIDFile:    "",
+	CIDReload:  5 * time.Minute,
+	CIDPool:    pkg.DefaultPooledBitswapSnifferConfig(),
+	CIDSchedule: &cidScheduleConfig{
+		Set:  "controlled",
+		Seed: 0,
+		Slot: time.Minute,
+	},
 	Clickhouse: pldb.DefaultClickHouseConfig("tiros_local"),
 	Migrations: pldb.DefaultClickHouseMigrationsConfig(),
 }
@@ -67,6 +87,69 @@ var probeFlags = []cli.Flag{
 		Value:       probeConfig.Timeout,
 		Destination: &probeConfig.Timeout,
 	},
+	&cli.StringSliceFlag{
+		Name:        "cid.sources",
+		Usage:       "Weighted CID sources as 'source=weight' entries (e.g. 'controlled=0.2,bitsniffer=0.8'). Supported sources: static, file, bitsniffer, controlled, scheduled. Takes precedence over the --controlled.* flags of the probe commands.",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_SOURCES"),
+		Value:       probeConfig.CIDSources,
+		Destination: &probeConfig.CIDSources,
+	},
+	&cli.StringSliceFlag{
+		Name:        "cid.controlled.classes",
+		Usage:       "Shares of the controlled classes as 'class=share' entries (e.g. '1KiB-file=0.5,4MiB-file=0.5'). Classes that aren't listed aren't probed. Defaults to the built-in shares of all classes.",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_CONTROLLED_CLASSES"),
+		Value:       probeConfig.CIDClasses,
+		Destination: &probeConfig.CIDClasses,
+	},
+	&cli.StringFlag{
+		Name:        "cid.file",
+		Usage:       "A local file or HTTP(S) URL with a CSV or NDJSON list of CIDs for the 'file' CID source",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_FILE"),
+		Value:       probeConfig.CIDFile,
+		Destination: &probeConfig.CIDFile,
+	},
+	&cli.DurationFlag{
+		Name:        "cid.file.reload",
+		Usage:       "How frequently to check the CID file for changes (0 disables reloading)",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_FILE_RELOAD"),
+		Value:       probeConfig.CIDReload,
+		Destination: &probeConfig.CIDReload,
+	},
+	&cli.DurationFlag{
+		Name:        "cid.bitsniffer.refresh",
+		Usage:       "How frequently to refresh the in-memory pool of bitswap sniffer CIDs (0 queries the database on every selection)",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_BITSNIFFER_REFRESH"),
+		Value:       probeConfig.CIDPool.RefreshInterval,
+		Destination: &probeConfig.CIDPool.RefreshInterval,
+	},
+	&cli.IntFlag{
+		Name:        "cid.bitsniffer.pool",
+		Usage:       "The number of recent bitswap sniffer CIDs to keep in memory per origin",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_BITSNIFFER_POOL"),
+		Value:       probeConfig.CIDPool.Size,
+		Destination: &probeConfig.CIDPool.Size,
+	},
+	&cli.StringFlag{
+		Name:        "cid.schedule.set",
+		Usage:       "The CID set the 'scheduled' CID source derives its schedule from: controlled, static",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_SCHEDULE_SET"),
+		Value:       probeConfig.CIDSchedule.Set,
+		Destination: &probeConfig.CIDSchedule.Set,
+	},
+	&cli.Uint64Flag{
+		Name:        "cid.schedule.seed",
+		Usage:       "The seed of the 'scheduled' CID source. Must be the same across all regions.",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_SCHEDULE_SEED"),
+		Value:       probeConfig.CIDSchedule.Seed,
+		Destination: &probeConfig.CIDSchedule.Seed,
+	},
+	&cli.DurationFlag{
+		Name:        "cid.schedule.slot",
+		Usage:       "The duration of a slot of the 'scheduled' CID source. Must be the same across all regions.",
+		Sources:     cli.EnvVars("TIROS_PROBE_CID_SCHEDULE_SLOT"),
+		Value:       probeConfig.CIDSchedule.Slot,
+		Destination: &probeConfig.CIDSchedule.Slot,
+	},
 }
 
 func probeBefore(ctx context.Context, c *cli.Command) (context.Context, error) {
@@ -104,6 +187,162 @@ func newDBClient(ctx context.Context) (db.Client, error) {
 	return dbClient, nil
 }
 
+// cidProviderConfig holds the command-specific CID settings that are used to
+// derive the CID sources if --cid.sources wasn't given explicitly.
+type cidProviderConfig struct {
+	StaticCIDs      []string
+	ControlledCIDs  bool
+	ControlledShare float32
+
+	// OncePerSlot makes the 'scheduled' source hand out the CID of every
+	// slot only once, for commands that select more often than once per
+	// slot.
+	OncePerSlot bool
+}
+
+// cidScheduleConfig configures the 'scheduled' CID source.
+type cidScheduleConfig struct {
+	Set  string
+	Seed uint64
+	Slot time.Duration
+}
+
+type cidSourceWeight struct {
+	name   string
+	weight float64
+}
+
+// cidSourceWeights returns the configured CID sources. If --cid.sources is
+// empty, the sources are derived from the legacy --cids and --controlled.*
+// flags: static CIDs take precedence over a CID file which takes precedence
+// over the bitswap sniffer, and controlled CIDs are mixed in with the
+// configured share.
+func cidSourceWeights(cfg cidProviderConfig) ([]cidSourceWeight, error) {
+	if len(probeConfig.CIDSources) > 0 {
+		return parseWeights("cid.sources", probeConfig.CIDSources)
+	}
+
+	primary := "bitsniffer"
+	if len(cfg.StaticCIDs) > 0 {
+		primary = "static"
+	} else if probeConfig.CIDFile != "" {
+		primary = "file"
+	}
+
+	if !cfg.ControlledCIDs || cfg.ControlledShare <= 0 {
+		return []cidSourceWeight{{name: primary, weight: 1}}, nil
+	}
+
+	share := min(float64(cfg.ControlledShare), 1)
+	return []cidSourceWeight{
+		{name: "controlled", weight: share},
+		{name: primary, weight: 1 - share},
+	}, nil
+}
+
+// parseWeights parses 'name=weight' entries of the given flag.
+func parseWeights(flag string, entries []string) ([]cidSourceWeight, error) {
+	weights := make([]cidSourceWeight, 0, len(entries))
+	for _, entry := range entries {
+		name, weightStr, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		weightStr = strings.TrimSpace(weightStr)
+		if !ok || name == "" || weightStr == "" {
+			return nil, fmt.Errorf("invalid %s entry %q: expected 'name=weight' with non-empty values", flag, entry)
+		}
+
+		weight, err := strconv.ParseFloat(weightStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight in %s entry %q: %w", flag, entry, err)
+		}
+
+		weights = append(weights, cidSourceWeight{name: name, weight: weight})
+	}
+	return weights, nil
+}
+
+// newCIDProvider initializes a weighted CID provider from the configured CID
+// sources. Only the sources with a positive weight are initialized.
+func newCIDProvider(ctx context.Context, dbClient db.Client, cfg cidProviderConfig) (*pkg.WeightedCIDProvider, error) {
+	weights, err := cidSourceWeights(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]*pkg.WeightedCIDSource, 0, len(weights))
+	for _, w := range weights {
+		if w.weight == 0 {
+			continue
+		}
+
+		src := &pkg.WeightedCIDSource{
+			Name:   w.name,
+			Weight: w.weight,
+		}
+
+		switch w.name {
+		case "static":
+			if len(cfg.StaticCIDs) == 0 {
+				return nil, fmt.Errorf("static cid source configured but no --cids given")
+			}
+			src.Provider, err = pkg.NewStaticCIDProvider(cfg.StaticCIDs)
+		case "file":
+			if probeConfig.CIDFile == "" {
+				return nil, fmt.Errorf("file cid source configured but no --cid.file given")
+			}
+			src.Provider, err = pkg.NewFileCIDProvider(ctx, probeConfig.CIDFile, probeConfig.CIDReload)
+		case "bitsniffer":
+			src.PerOrigin = true
+			if probeConfig.CIDPool.RefreshInterval > 0 {
+				src.Provider, err = pkg.NewPooledBitswapSnifferCIDProvider(ctx, dbClient, probeConfig.CIDPool)
+			} else {
+				src.Provider, err = pkg.NewBitswapSnifferClickhouseCIDProvider(dbClient)
+			}
+		case "controlled":
+			var classes []cidSourceWeight
+			classes, err = parseWeights("cid.controlled.classes", probeConfig.CIDClasses)
+			if err != nil {
+				return nil, err
+			}
+
+			shares := make(map[string]float64, len(classes))
+			for _, c := range classes {
+				shares[c.name] = c.weight
+			}
+			src.Provider, err = pkg.NewControlledCIDProvider(shares)
+		case "scheduled":
+			var cids []cid.Cid
+			switch probeConfig.CIDSchedule.Set {
+			case "controlled":
+				cids = pkg.ControlledCIDs()
+			case "static":
+				cids, err = pkg.ParseCIDs(cfg.StaticCIDs)
+			default:
+				return nil, fmt.Errorf("unknown cid schedule set %q", probeConfig.CIDSchedule.Set)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s cids for schedule: %w", probeConfig.CIDSchedule.Set, err)
+			}
+			src.Name = "scheduled_" + probeConfig.CIDSchedule.Set
+			var scheduled *pkg.ScheduledCIDProvider
+			scheduled, err = pkg.NewScheduledCIDProvider(cids, probeConfig.CIDSchedule.Seed, probeConfig.CIDSchedule.Slot)
+			if err == nil && cfg.OncePerSlot {
+				scheduled.ServeOncePerSlot()
+			}
+			src.Provider = scheduled
+		default:
+			return nil, fmt.Errorf("unknown cid source %q", w.name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("creating %s cid provider: %w", w.name, err)
+		}
+
+		sources = append(sources, src)
+	}
+
+	return pkg.NewWeightedCIDProvider(sources...)
+}
+
 func probeAfter(ctx context.Context, c *cli.Command) error {
 	slog.Info("Stopped probing Kubo.")
 	return nil
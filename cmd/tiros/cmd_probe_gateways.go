@@ -1,29 +1,24 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
 	"database/sql"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"math"
 	"math/rand/v2"
-	"net"
-	"net/http"
-	"net/http/httptrace"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
-	carv2 "github.com/ipld/go-car/v2"
 	pllog "github.com/probe-lab/go-commons/log"
 	"github.com/probe-lab/tiros/pkg"
 	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/probe-lab/tiros/pkg/gw"
 	"github.com/urfave/cli/v3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -36,38 +31,70 @@ var probeGatewaysConfig = struct {
 	MaxIterations   int
 	DownloadCIDs    []string
 	Gateways        []string
+	Registry        string
 	MaxDownloadMB   int
 	Timeout         time.Duration
 	RefreshInterval time.Duration
-	Concurrency     int
 	ControlledCIDs  bool
 	ControlledShare float32
 	AuthKeys        []string
+	CIDReuseWindow  time.Duration
+	Protocols       []string
+	CARVariants     []string
+	Formats         []string
+	URLStyles       []string
+	CachePrecheck   bool
+	ColdTTL         time.Duration
+	SizePrecheck    string
+	NegativeChecks  bool
+	IPFamily        string
+	DNSResolver     string
+	DNSCache        bool
+	StallThreshold  time.Duration
+	BreakerConfig   *gw.BreakerConfig
+	SchedulerConfig *gw.SchedulerConfig
+	TLSMonitor      *gw.TLSMonitorConfig
 }{
 	Interval:        10 * time.Second,
 	MaxIterations:   0,
 	DownloadCIDs:    []string{},
 	Gateways:        []string{},
+	Registry:        "",
 	MaxDownloadMB:   10,
 	Timeout:         30 * time.Second,
 	RefreshInterval: 5 * time.Minute,
-	Concurrency:     10,
 	ControlledCIDs:  true,
 	ControlledShare: 0.2,
 	AuthKeys:        []string{},
+	CIDReuseWindow:  0,
+	Protocols:       []string{string(gw.ProtocolHTTP1)},
+	CARVariants:     []string{},
+	Formats:         []string{string(db.GatewayProbeFormatNone), string(db.GatewayProbeFormatCAR)},
+	URLStyles:       []string{string(gw.URLStylePath)},
+	CachePrecheck:   false,
+	ColdTTL:         24 * time.Hour,
+	SizePrecheck:    "none",
+	NegativeChecks:  false,
+	IPFamily:        "",
+	DNSResolver:     "system",
+	DNSCache:        false,
+	StallThreshold:  time.Second,
+	BreakerConfig:   gw.DefaultBreakerConfig(),
+	SchedulerConfig: gw.DefaultSchedulerConfig(),
+	TLSMonitor:      gw.DefaultTLSMonitorConfig(),
 }
 
 var probeGatewaysFlags = []cli.Flag{
 	&cli.DurationFlag{
 		Name:        "interval",
-		Usage:       "How long to wait between each download iteration",
+		Usage:       "How often --concurrency CIDs, capped at the number of --cids, are leased and their probes scheduled.",
 		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_INTERVAL"),
 		Value:       probeGatewaysConfig.Interval,
 		Destination: &probeGatewaysConfig.Interval,
 	},
 	&cli.IntFlag{
 		Name:        "iterations.max",
-		Usage:       "The number of iterations per concurrent worker to run. 0 means infinite.",
+		Usage:       "The number of intervals to run. 0 means infinite.",
 		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_ITERATIONS_MAX"),
 		Value:       probeGatewaysConfig.MaxIterations,
 		Destination: &probeGatewaysConfig.MaxIterations,
@@ -86,6 +113,13 @@ var probeGatewaysFlags = []cli.Flag{
 		Value:       probeGatewaysConfig.Gateways,
 		Destination: &probeGatewaysConfig.Gateways,
 	},
+	&cli.StringFlag{
+		Name:        "gateways.registry",
+		Usage:       "A YAML or JSON file of gateways with per-gateway url style, formats, auth scheme, headers, weight, tags, and capabilities (takes precedence over --gateways and database)",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_REGISTRY"),
+		Value:       probeGatewaysConfig.Registry,
+		Destination: &probeGatewaysConfig.Registry,
+	},
 	&cli.IntFlag{
 		Name:        "download.max.mb",
 		Usage:       "Maximum download size in MiB before cancelling",
@@ -109,10 +143,31 @@ var probeGatewaysFlags = []cli.Flag{
 	},
 	&cli.IntFlag{
 		Name:        "concurrency",
-		Usage:       "Number of gateways to probe concurrently",
+		Usage:       "Maximum number of probes that run concurrently",
 		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CONCURRENCY"),
-		Value:       probeGatewaysConfig.Concurrency,
-		Destination: &probeGatewaysConfig.Concurrency,
+		Value:       probeGatewaysConfig.SchedulerConfig.Concurrency,
+		Destination: &probeGatewaysConfig.SchedulerConfig.Concurrency,
+	},
+	&cli.IntFlag{
+		Name:        "concurrency.gateway",
+		Usage:       "Maximum number of probes per gateway that run concurrently. 0 means no limit besides --concurrency.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CONCURRENCY_GATEWAY"),
+		Value:       probeGatewaysConfig.SchedulerConfig.GatewayConcurrency,
+		Destination: &probeGatewaysConfig.SchedulerConfig.GatewayConcurrency,
+	},
+	&cli.IntFlag{
+		Name:        "queue.gateway.max",
+		Usage:       "Number of queued probes of a gateway at which it's skipped for new CIDs because it can't keep up. 0 means no limit.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_QUEUE_GATEWAY_MAX"),
+		Value:       probeGatewaysConfig.SchedulerConfig.GatewayQueueSize,
+		Destination: &probeGatewaysConfig.SchedulerConfig.GatewayQueueSize,
+	},
+	&cli.FloatFlag{
+		Name:        "rate",
+		Usage:       "Target number of probes started per second across all gateways. 0 means no limit.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_RATE"),
+		Value:       probeGatewaysConfig.SchedulerConfig.Rate,
+		Destination: &probeGatewaysConfig.SchedulerConfig.Rate,
 	},
 	&cli.BoolFlag{
 		Name:        "controlled.cids",
@@ -130,11 +185,130 @@ var probeGatewaysFlags = []cli.Flag{
 	},
 	&cli.StringSliceFlag{
 		Name:        "auth.keys",
-		Usage:       "Per-gateway auth keys as 'gateway=key' entries (e.g. 'ipfs.io=abc,dweb.link=xyz'). Sends 'Tiros-Auth: <key>' for matching gateways.",
+		Usage:       "Per-gateway auth keys as 'gateway=key' entries (e.g. 'ipfs.io=abc,dweb.link=xyz'). Sent as 'Tiros-Auth: <key>' to matching gateways unless their registry entry sets another auth scheme.",
 		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_AUTH_KEYS"),
 		Value:       probeGatewaysConfig.AuthKeys,
 		Destination: &probeGatewaysConfig.AuthKeys,
 	},
+	&cli.DurationFlag{
+		Name:        "cid.reuse.window",
+		Usage:       "How long a CID is not probed again after a worker has finished probing it (0 only prevents concurrent probes)",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CID_REUSE_WINDOW"),
+		Value:       probeGatewaysConfig.CIDReuseWindow,
+		Destination: &probeGatewaysConfig.CIDReuseWindow,
+	},
+	&cli.StringSliceFlag{
+		Name:        "protocols",
+		Usage:       "The HTTP versions to probe every gateway with (http1, http2, http3)",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_PROTOCOLS"),
+		Value:       probeGatewaysConfig.Protocols,
+		Destination: &probeGatewaysConfig.Protocols,
+	},
+	&cli.StringSliceFlag{
+		Name:        "car.variants",
+		Usage:       "The trustless CAR requests to probe every gateway with as query strings of dag-scope, entity-bytes, version, order, and dups (e.g. 'dag-scope=entity&entity-bytes=0:1023&order=dfs&dups=n'). Defaults to a single plain ?format=car request.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CAR_VARIANTS"),
+		Value:       probeGatewaysConfig.CARVariants,
+		Destination: &probeGatewaysConfig.CARVariants,
+	},
+	&cli.StringSliceFlag{
+		Name:        "formats",
+		Usage:       "The response formats to probe every gateway with (none, raw, car, tar, dag-json, dag-cbor, ipns-record). ipns-record is only requested for /ipns/<key> paths.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_FORMATS"),
+		Value:       probeGatewaysConfig.Formats,
+		Destination: &probeGatewaysConfig.Formats,
+	},
+	&cli.StringSliceFlag{
+		Name:        "url.styles",
+		Usage:       "How content paths are requested from every gateway: path (gateway/ipfs/<cid>) and/or subdomain (<cidv1>.ipfs.gateway)",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_URL_STYLES"),
+		Value:       probeGatewaysConfig.URLStyles,
+		Destination: &probeGatewaysConfig.URLStyles,
+	},
+	&cli.BoolFlag{
+		Name:        "cache.precheck",
+		Usage:       "Ask gateways with 'Cache-Control: only-if-cached' whether they already cached the content before its first, cold probe. Gateways that ignore the directive are warmed up by it.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CACHE_PRECHECK"),
+		Value:       probeGatewaysConfig.CachePrecheck,
+		Destination: &probeGatewaysConfig.CachePrecheck,
+	},
+	&cli.DurationFlag{
+		Name:        "cache.cold.ttl",
+		Usage:       "How long a CID that was probed on a gateway isn't expected to find the gateway's cache cold again",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CACHE_COLD_TTL"),
+		Value:       probeGatewaysConfig.ColdTTL,
+		Destination: &probeGatewaysConfig.ColdTTL,
+	},
+	&cli.StringFlag{
+		Name:        "size.precheck",
+		Usage:       "How the size of a response body is learned before the probe if the response may not announce it: none, head (a HEAD request), or block (the UnixFS file size from a dag-scope=block request, only for the none format). Only made for the second, warm attempt.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_SIZE_PRECHECK"),
+		Value:       probeGatewaysConfig.SizePrecheck,
+		Destination: &probeGatewaysConfig.SizePrecheck,
+	},
+	&cli.BoolFlag{
+		Name:        "conformance.negative",
+		Usage:       "Check that gateways reject an invalid CID with 400 and an unsupported Accept header with 406. Made before the second, warm attempt of the none format.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CONFORMANCE_NEGATIVE"),
+		Value:       probeGatewaysConfig.NegativeChecks,
+		Destination: &probeGatewaysConfig.NegativeChecks,
+	},
+	&cli.StringFlag{
+		Name:        "ip.family",
+		Usage:       "Restrict all gateway requests to IPv4 (4) or IPv6 (6). By default, both are used with a fallback from one to the other.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_IP_FAMILY"),
+		Value:       probeGatewaysConfig.IPFamily,
+		Destination: &probeGatewaysConfig.IPFamily,
+	},
+	&cli.StringFlag{
+		Name:        "dns.resolver",
+		Usage:       "The resolver of gateway host names: system, the address of a UDP resolver (e.g., udp://1.1.1.1:53), or a DNS-over-HTTPS URL (e.g., https://cloudflare-dns.com/dns-query)",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_DNS_RESOLVER"),
+		Value:       probeGatewaysConfig.DNSResolver,
+		Destination: &probeGatewaysConfig.DNSResolver,
+	},
+	&cli.BoolFlag{
+		Name:        "dns.cache",
+		Usage:       "Cache the answers of a UDP or DNS-over-HTTPS resolver for their TTL. By default, every probe resolves the gateway with a fresh query. The system resolver is subject to the caching of the OS.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_DNS_CACHE"),
+		Value:       probeGatewaysConfig.DNSCache,
+		Destination: &probeGatewaysConfig.DNSCache,
+	},
+	&cli.DurationFlag{
+		Name:        "stall.threshold",
+		Usage:       "The minimum gap in the arrival of response body bytes that counts as a stall. 0 doesn't count stalls.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_STALL_THRESHOLD"),
+		Value:       probeGatewaysConfig.StallThreshold,
+		Destination: &probeGatewaysConfig.StallThreshold,
+	},
+	&cli.DurationFlag{
+		Name:        "tls.expiry.warning",
+		Usage:       "Warn about gateway TLS certificates that expire within this duration. Certificates are inspected at most once per refresh interval.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_TLS_EXPIRY_WARNING"),
+		Value:       probeGatewaysConfig.TLSMonitor.ExpiryWarning,
+		Destination: &probeGatewaysConfig.TLSMonitor.ExpiryWarning,
+	},
+	&cli.IntFlag{
+		Name:        "breaker.threshold",
+		Usage:       "Consecutive failures (no response, 429, or 5xx other than 502 and 504) after which a gateway isn't probed until its backoff has elapsed. 0 disables the circuit breaker.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_BREAKER_THRESHOLD"),
+		Value:       probeGatewaysConfig.BreakerConfig.Threshold,
+		Destination: &probeGatewaysConfig.BreakerConfig.Threshold,
+	},
+	&cli.DurationFlag{
+		Name:        "breaker.backoff.initial",
+		Usage:       "How long a failing gateway isn't probed the first time. Doubles with every failed trial probe.",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_BREAKER_BACKOFF_INITIAL"),
+		Value:       probeGatewaysConfig.BreakerConfig.InitialBackoff,
+		Destination: &probeGatewaysConfig.BreakerConfig.InitialBackoff,
+	},
+	&cli.DurationFlag{
+		Name:        "breaker.backoff.max",
+		Usage:       "The maximum duration a failing gateway isn't probed",
+		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_BREAKER_BACKOFF_MAX"),
+		Value:       probeGatewaysConfig.BreakerConfig.MaxBackoff,
+		Destination: &probeGatewaysConfig.BreakerConfig.MaxBackoff,
+	},
 }
 
 var probeGatewaysCmd = &cli.Command{
@@ -144,26 +318,6 @@ var probeGatewaysCmd = &cli.Command{
 	Action: probeGatewaysAction,
 }
 
-type gatewayMetrics struct {
-	dnsStart      time.Time
-	dnsDuration   time.Duration
-	connStart     time.Time
-	connDuration  time.Duration
-	tlsStart      time.Time
-	tlsDuration   time.Duration
-	reqStart      time.Time
-	ttfb          time.Duration
-	downloadEnd   time.Time
-	bytesReceived int64
-	statusCode    int
-	headers       http.Header
-	carValidated  *bool
-	redirectCount int
-	finalURL      string
-	redirectChain []string
-	err           error
-}
-
 func probeGatewaysAction(ctx context.Context, cmd *cli.Command) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -175,6 +329,11 @@ func probeGatewaysAction(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 
+	skippedCounter, err := meter.Int64Counter("gateway_probes_skipped")
+	if err != nil {
+		return err
+	}
+
 	runID, err := uuid.NewV7()
 	if err != nil {
 		return fmt.Errorf("creating run id: %w", err)
@@ -191,6 +350,82 @@ func probeGatewaysAction(ctx context.Context, cmd *cli.Command) error {
 		authKeys[gateway] = key
 	}
 
+	protocols := make([]gw.Protocol, 0, len(probeGatewaysConfig.Protocols))
+	for _, entry := range probeGatewaysConfig.Protocols {
+		protocol, err := gw.ParseProtocol(strings.TrimSpace(entry))
+		if err != nil {
+			return fmt.Errorf("invalid protocols entry: %w", err)
+		}
+		if !slices.Contains(protocols, protocol) {
+			protocols = append(protocols, protocol)
+		}
+	}
+
+	carVariants := []gw.CARParams{{}}
+	if len(probeGatewaysConfig.CARVariants) > 0 {
+		carVariants = carVariants[:0]
+	}
+	for _, entry := range probeGatewaysConfig.CARVariants {
+		params, err := gw.ParseCARParams(strings.TrimSpace(entry))
+		if err != nil {
+			return fmt.Errorf("invalid car.variants entry: %w", err)
+		}
+		carVariants = append(carVariants, params)
+	}
+
+	urlStyles := make([]gw.URLStyle, 0, len(probeGatewaysConfig.URLStyles))
+	for _, entry := range probeGatewaysConfig.URLStyles {
+		style, err := gw.ParseURLStyle(strings.TrimSpace(entry))
+		if err != nil {
+			return fmt.Errorf("invalid url.styles entry: %w", err)
+		}
+		if !slices.Contains(urlStyles, style) {
+			urlStyles = append(urlStyles, style)
+		}
+	}
+
+	ipFamily, err := gw.ParseIPFamily(strings.TrimSpace(probeGatewaysConfig.IPFamily))
+	if err != nil {
+		return fmt.Errorf("invalid ip.family: %w", err)
+	}
+
+	sizePrecheck, err := gw.ParseSizePrecheck(strings.TrimSpace(probeGatewaysConfig.SizePrecheck))
+	if err != nil {
+		return fmt.Errorf("invalid size.precheck: %w", err)
+	}
+
+	// the request variants that every gateway is probed with: one per
+	// format, and one per CAR variant for the car format
+	var formatVariants []gw.Request
+	for _, entry := range probeGatewaysConfig.Formats {
+		format, err := gw.ParseFormat(strings.TrimSpace(entry))
+		if err != nil {
+			return fmt.Errorf("invalid formats entry: %w", err)
+		}
+
+		if slices.ContainsFunc(formatVariants, func(r gw.Request) bool { return r.Format == format }) {
+			continue
+		}
+
+		if format != db.GatewayProbeFormatCAR {
+			formatVariants = append(formatVariants, gw.Request{Format: format})
+			continue
+		}
+
+		for _, params := range carVariants {
+			formatVariants = append(formatVariants, gw.Request{Format: format, CAR: params})
+		}
+	}
+
+	// and every variant is requested in each URL style
+	variants := make([]gw.Request, 0, len(formatVariants)*len(urlStyles))
+	for _, variant := range formatVariants {
+		for _, style := range urlStyles {
+			variant.URLStyle = style
+			variants = append(variants, variant)
+		}
+	}
+
 	// Initialize the db client
 	dbClient, err := newDBClient(ctx)
 	if err != nil {
@@ -199,68 +434,104 @@ func probeGatewaysAction(ctx context.Context, cmd *cli.Command) error {
 	defer pllog.Defer(dbClient.Close, "Failed closing database client")
 
 	// Initialize CID provider
-	var cidProvider pkg.CIDProvider
-	var cidProviderName string
-	if len(probeGatewaysConfig.DownloadCIDs) > 0 {
-		cidProvider, err = pkg.NewStaticCIDProvider(probeGatewaysConfig.DownloadCIDs)
-		if err != nil {
-			return fmt.Errorf("creating static cid provider: %w", err)
-		}
-		cidProviderName = "StaticCIDProvider"
-	} else {
-		cidProvider, err = pkg.NewBitswapSnifferClickhouseCIDProvider(dbClient)
-		if err != nil {
-			return fmt.Errorf("creating clickhouse cid provider: %w", err)
-		}
-		cidProviderName = "BitswapSnifferClickhouseCIDProvider"
+	cidProvider, err := newCIDProvider(ctx, dbClient, cidProviderConfig{
+		StaticCIDs:      probeGatewaysConfig.DownloadCIDs,
+		ControlledCIDs:  probeGatewaysConfig.ControlledCIDs,
+		ControlledShare: probeGatewaysConfig.ControlledShare,
+		OncePerSlot:     true,
+	})
+	if err != nil {
+		return fmt.Errorf("creating cid provider: %w", err)
 	}
-	slog.With("provider", cidProviderName).Info("Using CID provider for gateway probes")
+	slog.With("sources", cidProvider.String()).Info("Using CID sources for gateway probes")
 
-	controlledCIDsProvider, err := pkg.NewControlledCIDProvider()
+	resolver, err := gw.ParseResolver(strings.TrimSpace(probeGatewaysConfig.DNSResolver), probeGatewaysConfig.DNSCache)
 	if err != nil {
-		return fmt.Errorf("creating controlled cid provider: %w", err)
+		return fmt.Errorf("invalid dns.resolver: %w", err)
 	}
 
+	prober := gw.NewProber(&gw.ProberConfig{
+		Timeout:        probeGatewaysConfig.Timeout,
+		MaxBytes:       int64(probeGatewaysConfig.MaxDownloadMB) * 1024 * 1024,
+		UserAgent:      "Tiros",
+		Resolver:       resolver,
+		StallThreshold: probeGatewaysConfig.StallThreshold,
+	})
+
+	// shared by all workers, so that a gateway that is down isn't probed by
+	// any of them
+	breakers := gw.NewBreakers(probeGatewaysConfig.BreakerConfig)
+
+	// only the first probe of a CID on a gateway finds its cache cold, also
+	// across rounds
+	coldProbes := gw.NewColdProbes(probeGatewaysConfig.ColdTTL, coldProbesMax)
+
+	// Make sure concurrent workers never probe the same CID at the same time
+	cidLeaser := pkg.NewLeasingCIDProvider(cidProvider, probeGatewaysConfig.CIDReuseWindow)
+
 	// Gateway list management
 	var gatewaysMu sync.RWMutex
-	var gateways []string
+	var gateways []*gw.Gateway
 
-	// Check if static gateways are provided via command line
-	if len(probeGatewaysConfig.Gateways) > 0 {
-		gateways = probeGatewaysConfig.Gateways
-		slog.With("count", len(gateways), "gateways", gateways).Info("Using static gateway list from command line")
+	// Check if a gateway registry or static gateways are provided via command line
+	if probeGatewaysConfig.Registry != "" {
+		gateways, err = gw.LoadRegistry(probeGatewaysConfig.Registry)
+		if err != nil {
+			return err
+		}
+
+		if len(gateways) == 0 {
+			return fmt.Errorf("no gateways found in registry %s", probeGatewaysConfig.Registry)
+		}
+
+		slog.With("count", len(gateways), "registry", probeGatewaysConfig.Registry).Info("Using gateway registry from file")
+	} else if len(probeGatewaysConfig.Gateways) > 0 {
+		for _, host := range probeGatewaysConfig.Gateways {
+			gateways = append(gateways, &gw.Gateway{Host: host})
+		}
+		slog.With("count", len(gateways), "gateways", probeGatewaysConfig.Gateways).Info("Using static gateway list from command line")
 	} else {
 
 		// Function to update gateways from database
 		updateGateways := func() error {
-			newGateways, err := dbClient.Gateways(ctx)
+			dbGateways, err := dbClient.Gateways(ctx)
 			if err != nil {
 				return fmt.Errorf("fetching gateways: %w", err)
 			}
 
+			newGateways := make([]*gw.Gateway, 0, len(dbGateways))
+			for _, dbGateway := range dbGateways {
+				gateway, err := gw.GatewayFromModel(dbGateway)
+				if err != nil {
+					slog.With("err", err).Warn("Ignoring invalid gateway from database")
+					continue
+				}
+				newGateways = append(newGateways, gateway)
+			}
+
 			gatewaysMu.Lock()
 			defer gatewaysMu.Unlock()
 
 			// Calculate added and removed gateways
 			oldSet := make(map[string]bool, len(gateways))
 			for _, gw := range gateways {
-				oldSet[gw] = true
+				oldSet[gw.Host] = true
 			}
 
 			newSet := make(map[string]bool, len(newGateways))
 			for _, gw := range newGateways {
-				newSet[gw] = true
+				newSet[gw.Host] = true
 			}
 
 			var added, removed []string
 			for _, gw := range newGateways {
-				if !oldSet[gw] {
-					added = append(added, gw)
+				if !oldSet[gw.Host] {
+					added = append(added, gw.Host)
 				}
 			}
 			for _, gw := range gateways {
-				if !newSet[gw] {
-					removed = append(removed, gw)
+				if !newSet[gw.Host] {
+					removed = append(removed, gw.Host)
 				}
 			}
 
@@ -321,397 +592,310 @@ func probeGatewaysAction(ctx context.Context, cmd *cli.Command) error {
 		return fmt.Errorf("creating active_gateways gauge: %w", err)
 	}
 
-	downloadCIDsCount := math.MaxInt
-	if len(probeGatewaysConfig.DownloadCIDs) > 0 {
-		downloadCIDsCount = len(probeGatewaysConfig.DownloadCIDs)
+	scheduler, err := gw.NewScheduler(probeGatewaysConfig.SchedulerConfig)
+	if err != nil {
+		return fmt.Errorf("creating scheduler: %w", err)
 	}
 
-	concurrency := min(probeGatewaysConfig.Concurrency, downloadCIDsCount)
+	// certificates are recorded at most once per refresh interval
+	probeGatewaysConfig.TLSMonitor.Interval = probeGatewaysConfig.RefreshInterval
+	tlsMonitor, err := gw.NewTLSMonitor(probeGatewaysConfig.TLSMonitor)
+	if err != nil {
+		return fmt.Errorf("creating tls monitor: %w", err)
+	}
 
-	// Test each gateway in parallel (limit to N concurrent)
-	g, gctx := errgroup.WithContext(ctx)
+	insertProbe := func(ctx context.Context, result *gw.Result, gateway *gw.Gateway, sel *pkg.CIDLease) error {
+		dbGatewayProbe := result.Model()
+		dbGatewayProbe.RunID = runID.String()
+		dbGatewayProbe.Region = rootConfig.AWSRegion
+		dbGatewayProbe.TirosVersion = cmd.Root().Version
+		dbGatewayProbe.CID = cidString(sel.CID)
+		dbGatewayProbe.CIDSource = sel.Source
+		dbGatewayProbe.CIDMetadata = sel.Metadata
+		dbGatewayProbe.CIDSizeClass = pkg.ControlledSizeClass(sel.CID)
+		dbGatewayProbe.GatewayTags = gateway.Tags
+		dbGatewayProbe.CapabilityExpected = gateway.Expects(result.Request)
+
+		if err := dbClient.InsertGatewayProbe(ctx, dbGatewayProbe); err != nil {
+			return fmt.Errorf("inserting gateway probe into database: %w", err)
+		}
 
-	slog.Info(fmt.Sprintf("Starting %d concurrent worker", concurrency))
-	for worker := range concurrency {
-		logEntry := slog.With("worker", worker)
+		if !tlsMonitor.Inspect(ctx, gateway.Host, result.TLS) {
+			return nil
+		}
 
-		ticker := time.NewTimer(0)
-		iterationStart := time.Now()
-		maxIter := probeGatewaysConfig.MaxIterations
+		dbGatewayCert := result.TLS.Model(gateway.Host)
+		dbGatewayCert.RunID = dbGatewayProbe.RunID
+		dbGatewayCert.Region = dbGatewayProbe.Region
+		dbGatewayCert.TirosVersion = dbGatewayProbe.TirosVersion
 
-		g.Go(func() error {
-			logEntry.Info("Started gateway probing worker...")
+		if err := dbClient.InsertGatewayCertificate(ctx, dbGatewayCert); err != nil {
+			return fmt.Errorf("inserting gateway certificate into database: %w", err)
+		}
 
-		mainLoop:
-			for i := 0; maxIter == 0 || i < maxIter; i++ {
+		return nil
+	}
 
-				// Wait for the next iteration
-				waitTime := time.Until(iterationStart.Add(probeGatewaysConfig.Interval)).Truncate(time.Second)
-				if i > 0 {
-					ticker.Reset(waitTime)
-					if waitTime > 0 {
-						logEntry.With("iteration", i).Info(fmt.Sprintf("Waiting %s until the next iteration...", waitTime))
-					}
-				}
+	// probe runs a single job and schedules its follow-up attempt
+	probe := func(ctx context.Context, job *gw.Job, state *gatewayState, lease *roundLease) error {
+		sel := lease.lease
+		req := &job.Request
 
-				select {
-				case <-gctx.Done():
-					return gctx.Err()
-				case <-ticker.C:
-					// pass
-				}
+		if !state.runs(req.Protocol) {
+			return nil
+		}
 
-				iterationStart = time.Now()
+		var result *gw.Result
+		if ok, reason := breakers.Allow(req.Gateway); ok {
+			// all other first attempts of the CID on the gateway follow
+			// the cold one
+			if req.Attempt == 0 {
+				req.Primed = !coldProbes.Claim(req.Gateway, req.Path)
+			}
 
-				logEntry.With("iteration", i).Info("Starting new gateway probing iteration...")
+			slog.With("path", req.Path.String(), "gateway", req.Gateway, "format", req.Format, "car", req.CAR.String(), "url_style", req.URLStyle, "protocol", req.Protocol, "ip_family", req.IPFamily, "attempt", req.Attempt, "expected_cache", req.ExpectedCacheState()).Debug("Probing gateway")
 
-				// Get the current gateway list (thread-safe)
-				gatewaysMu.RLock()
-				currentGateways := make([]string, len(gateways))
-				copy(currentGateways, gateways)
-				gatewaysMu.RUnlock()
+			result = prober.Probe(ctx, req)
+			breakers.Record(req.Gateway, result.GatewayFailed())
 
-				var ciid cid.Cid
-				var cidSource string
-				if rand.Float32() < probeGatewaysConfig.ControlledShare {
-					cidSource = "controlled"
-					ciid, err = controlledCIDsProvider.SelectCID(gctx, "controlled")
-				} else {
-					// Get CID to download (origin doesn't matter for gateways, use "bitswap")
-					ciid, err = cidProvider.SelectCID(gctx, "bitswap")
+			downloadCounter.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("source", sel.Source),
+				attribute.String("gateway", req.Gateway),
+				attribute.String("protocol", string(req.Protocol)),
+				attribute.Bool("success", result.Err == nil),
+			))
+		} else {
+			result = gw.Skipped(req, reason)
 
-					cidSource = "bitsniffer_bitswap"
-					if _, ok := cidProvider.(*pkg.StaticCIDProvider); ok {
-						cidSource = "static"
-					}
-				}
+			skippedCounter.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("gateway", req.Gateway),
+				attribute.String("reason", reason),
+			))
+		}
 
-				if errors.Is(err, sql.ErrNoRows) {
-					logEntry.Warn("No CID available for gateway probing")
-					continue mainLoop
-				} else if err != nil {
-					return fmt.Errorf("selecting cid from database: %w", err)
-				}
+		if result.SkippedReason != "" {
+			slog.With("path", req.Path.String(), "gateway", req.Gateway, "reason", result.SkippedReason).Debug("Skipping gateway")
+		} else if result.Err != nil {
+			slog.With("path", req.Path.String(), "gateway", req.Gateway, "err", result.Err, "format", req.Format, "protocol", req.Protocol).Info("Error downloading from gateway")
+		} else {
+			slog.With("path", req.Path.String(), "gateway", req.Gateway, "format", req.Format, "protocol", req.Protocol, "ttfb_s", result.TTFB.Seconds(), "cache", deref(result.CacheStatus()), "expected_cache", req.ExpectedCacheState()).Info("Gateway probe successful")
+		}
 
-				slog.Info(fmt.Sprintf("Worker %d will now start probing %s (%s)", worker, ciid.String(), cidSource))
-
-				rand.Shuffle(len(currentGateways), func(i, j int) {
-					currentGateways[i], currentGateways[j] = currentGateways[j], currentGateways[i]
-				})
-
-			gatewaysLoop:
-				for _, gateway := range currentGateways {
-					// Test both raw and trustless (CAR) formats
-					formats := []db.GatewayProbeFormat{db.GatewayProbeFormatNone, db.GatewayProbeFormatCAR}
-
-					for _, format := range formats {
-						// first iteration uncached, second cached
-						for j := 0; j < 2; j++ {
-							logEntry.With("cid", ciid.String(), "gateway", gateway, "format", format).Debug("Probing gateway")
-
-							pgc := gatewayProbeConfig{
-								gateway:  gateway,
-								cid:      ciid,
-								format:   format,
-								maxBytes: int64(probeGatewaysConfig.MaxDownloadMB) * 1024 * 1024,
-								timeout:  probeGatewaysConfig.Timeout,
-								authKey:  authKeys[gateway],
-							}
+		if err := insertProbe(ctx, result, state.gateway, sel); err != nil {
+			return err
+		}
 
-							metrics := pgc.probe(ctx)
+		state.record(req.Protocol, result)
 
-							downloadCounter.Add(gctx, 1, metric.WithAttributes(
-								attribute.String("source", cidSource),
-								attribute.String("gateway", gateway),
-								attribute.Bool("success", metrics.err == nil),
-							))
+		// the first attempt warms up the cache for the second
+		if result.SkippedReason == "" && result.Err == nil && req.Attempt == 0 {
+			followUp := *job
+			followUp.Request.Attempt += 1
+			lease.add()
+			scheduler.PushFront(&followUp)
+		}
 
-							// Calculate download speed
-							var downloadSpeedMbps *float64
-							if metrics.bytesReceived > 0 && metrics.downloadEnd.Sub(metrics.reqStart) > 0 {
-								durationS := metrics.downloadEnd.Sub(metrics.reqStart).Seconds()
-								speedBps := float64(metrics.bytesReceived) / durationS
-								speedMbps := (speedBps * 8) / (1024 * 1024)
-								downloadSpeedMbps = &speedMbps
-							}
+		return nil
+	}
 
-							// Extract IPFS headers
-							var ipfsPath, ipfsRoots, cacheStatus, contentType *string
-							if metrics.headers != nil {
-								if v := metrics.headers.Get("X-Ipfs-Path"); v != "" {
-									ipfsPath = &v
-								}
-								if v := metrics.headers.Get("X-Ipfs-Roots"); v != "" {
-									ipfsRoots = &v
-								}
-
-								// convert header type
-								hdr := make(map[string]any, len(metrics.headers))
-								for k, v := range metrics.headers {
-									if len(v) > 0 {
-										// drop multi-value header fields
-										hdr[strings.ToLower(k)] = v[0]
-									}
-								}
-								cacheStatus = pkg.ParseCacheStatus(hdr)
-
-								if v := metrics.headers.Get("Content-Type"); v != "" {
-									contentType = &v
-								}
-							}
+	g, gctx := errgroup.WithContext(ctx)
 
-							// Get Content-Length if present
-							var contentLength *int64
-							if metrics.headers != nil {
-								if cl := metrics.headers.Get("Content-Length"); cl != "" {
-									var clVal int64
-									if _, err := fmt.Sscanf(cl, "%d", &clVal); err == nil {
-										contentLength = &clVal
-									}
-								}
-							}
+	g.Go(func() error {
+		return scheduler.Run(gctx)
+	})
 
-							// Prepare database model
-							dbGatewayProbe := &db.GatewayProbeModel{
-								RunID:             runID.String(),
-								Region:            rootConfig.AWSRegion,
-								TirosVersion:      cmd.Root().Version,
-								Gateway:           gateway,
-								CID:               ciid.String(),
-								CIDSource:         cidSource,
-								Format:            string(format),
-								RequestStart:      metrics.reqStart,
-								DNSDurationS:      toPtr(metrics.dnsDuration.Seconds()),
-								ConnDurationS:     toPtr(metrics.connDuration.Seconds()),
-								TTFBS:             toPtr(metrics.ttfb.Seconds()),
-								DownloadDurationS: metrics.downloadEnd.Sub(metrics.reqStart).Seconds(),
-								BytesReceived:     metrics.bytesReceived,
-								ContentLength:     contentLength,
-								DownloadSpeedMbps: downloadSpeedMbps,
-								StatusCode:        metrics.statusCode,
-								IPFSPath:          ipfsPath,
-								IPFSRoots:         ipfsRoots,
-								CacheStatus:       cacheStatus,
-								ContentType:       contentType,
-								CARValidated:      metrics.carValidated,
-								RedirectCount:     metrics.redirectCount,
-								FinalURL:          toPtr(metrics.finalURL),
-								CreatedAt:         time.Now(),
-							}
+	// the producer leases CIDs and queues the probes of all gateways
+	g.Go(func() error {
+		defer scheduler.Close()
 
-							if metrics.err != nil {
-								errStr := metrics.err.Error()
-								dbGatewayProbe.Error = &errStr
-								logEntry.With("cid", ciid.String(), "gateway", gateway, "err", metrics.err, "format", format).Info("Error downloading from gateway")
-							} else {
-								logEntry.With("cid", ciid.String(), "gateway", gateway, "format", format, "ttfb_s", metrics.ttfb.Seconds(), "cache", deref(cacheStatus)).Info("Gateway probe successful")
-							}
+		// Every interval, --concurrency CIDs are leased, capped at the
+		// number of --cids and evenly spread over the interval.
+		// --iterations.max counts the intervals.
+		perInterval := probeGatewaysConfig.SchedulerConfig.Concurrency
+		if len(probeGatewaysConfig.DownloadCIDs) > 0 {
+			perInterval = min(perInterval, len(probeGatewaysConfig.DownloadCIDs))
+		}
+		spacing := probeGatewaysConfig.Interval / time.Duration(perInterval)
+		maxIter := probeGatewaysConfig.MaxIterations * perInterval
 
-							if err := dbClient.InsertGatewayProbe(gctx, dbGatewayProbe); err != nil {
-								return fmt.Errorf("inserting gateway probe into database: %w", err)
-							}
+		ticker := time.NewTimer(0)
+		iterationStart := time.Now()
 
-							if metrics.err != nil {
-								// if we encountered an error, we're done with this gateway
-								continue gatewaysLoop
-							}
-						}
-					}
-				}
+		for i := 0; maxIter == 0 || i < maxIter; i++ {
+
+			// Wait for the next iteration
+			if i > 0 {
+				ticker.Reset(time.Until(iterationStart.Add(spacing)))
 			}
-			return nil
-		})
-	}
 
-	return g.Wait()
-}
+			select {
+			case <-gctx.Done():
+				return gctx.Err()
+			case <-ticker.C:
+				// pass
+			}
 
-type gatewayProbeConfig struct {
-	gateway  string
-	cid      cid.Cid
-	format   db.GatewayProbeFormat
-	maxBytes int64
-	timeout  time.Duration
-	authKey  string
-}
+			// don't run ahead of the workers
+			if err := scheduler.WaitCapacity(gctx); err != nil {
+				return err
+			}
 
-func (g *gatewayProbeConfig) probe(ctx context.Context) *gatewayMetrics {
-	metrics := &gatewayMetrics{
-		reqStart: time.Now(),
-	}
+			iterationStart = time.Now()
 
-	// Create request context with timeout
-	reqCtx, cancel := context.WithTimeout(ctx, g.timeout)
-	defer cancel()
+			// Get the current gateway list (thread-safe)
+			gatewaysMu.RLock()
+			currentGateways := make([]*gw.Gateway, len(gateways))
+			copy(currentGateways, gateways)
+			gatewaysMu.RUnlock()
 
-	// Construct URL based on format
-	var url string
-	if !strings.HasPrefix(g.gateway, "http://") && !strings.HasPrefix(g.gateway, "https://") {
-		g.gateway = "https://" + g.gateway
-	}
-	g.gateway = strings.TrimSuffix(g.gateway, "/")
-
-	switch g.format {
-	case db.GatewayProbeFormatNone:
-		url = fmt.Sprintf("%s/ipfs/%s", g.gateway, g.cid.String())
-	case db.GatewayProbeFormatRaw:
-		url = fmt.Sprintf("%s/ipfs/%s?format=raw", g.gateway, g.cid.String())
-	case db.GatewayProbeFormatCAR:
-		url = fmt.Sprintf("%s/ipfs/%s?format=car", g.gateway, g.cid.String())
-	default:
-		panic(fmt.Sprintf("unknown gateway probe format: %s", g.format))
-	}
+			// Lease CID to download so that no other round probes it
+			// concurrently (origin doesn't matter for gateways, use "bitswap")
+			sel, err := cidLeaser.Lease(gctx, "bitswap")
+			if errors.Is(err, pkg.ErrSlotServed) {
+				slog.With("iteration", i).Debug("Waiting for the next slot of the CID schedule")
+				continue
+			} else if errors.Is(err, sql.ErrNoRows) || errors.Is(err, pkg.ErrNoCIDAvailable) {
+				slog.With("iteration", i, "err", err).Warn("No CID available for gateway probing")
+				continue
+			} else if err != nil {
+				return fmt.Errorf("selecting cid from database: %w", err)
+			}
+			contentPath := sel.ContentPath()
 
-	// Create HTTP client with detailed tracing
-	trace := &httptrace.ClientTrace{
-		DNSStart: func(_ httptrace.DNSStartInfo) {
-			metrics.dnsStart = time.Now()
-		},
-		DNSDone: func(_ httptrace.DNSDoneInfo) {
-			metrics.dnsDuration = time.Since(metrics.dnsStart)
-		},
-		ConnectStart: func(_, _ string) {
-			metrics.connStart = time.Now()
-		},
-		ConnectDone: func(_, _ string, _ error) {
-			metrics.connDuration = time.Since(metrics.connStart)
-		},
-		TLSHandshakeStart: func() {
-			metrics.tlsStart = time.Now()
-		},
-		TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
-			metrics.tlsDuration = time.Since(metrics.tlsStart)
-		},
-		GotFirstResponseByte: func() {
-			metrics.ttfb = time.Since(metrics.reqStart)
-		},
-	}
+			slog.With("iteration", i).Info(fmt.Sprintf("Scheduling probes of %s (%s)", contentPath, sel.Source))
 
-	reqCtx = httptrace.WithClientTrace(reqCtx, trace)
-
-	// Create HTTP client with redirect tracking
-	client := &http.Client{
-		Transport: &http.Transport{
-			DialContext: (&net.Dialer{
-				Timeout:   15 * time.Second,
-				KeepAlive: 15 * time.Second,
-			}).DialContext,
-			TLSHandshakeTimeout:   5 * time.Second,
-			ResponseHeaderTimeout: 15 * time.Second,
-			MaxIdleConns:          10,
-			IdleConnTimeout:       30 * time.Second,
-		},
-		Timeout: g.timeout,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Track redirect chain
-			metrics.redirectCount = len(via)
-			if req.URL != nil {
-				metrics.redirectChain = append(metrics.redirectChain, req.URL.String())
-			}
-			// Allow up to 10 redirects (Go's default)
-			if len(via) >= 10 {
-				return fmt.Errorf("stopped after 10 redirects")
-			}
-			return nil
-		},
-	}
+			rand.Shuffle(len(currentGateways), func(i, j int) {
+				currentGateways[i], currentGateways[j] = currentGateways[j], currentGateways[i]
+			})
 
-	// Create request
-	req, err := http.NewRequestWithContext(reqCtx, "GET", url, nil)
-	if err != nil {
-		metrics.err = fmt.Errorf("creating request: %w", err)
-		metrics.downloadEnd = time.Now()
-		return metrics
-	}
+			// the lease is released once all probes of the CID are done
+			lease := &roundLease{lease: sel}
+			lease.add()
 
-	// Identify tiros to gateway operators so they can correlate traffic in logs.
-	req.Header.Set("User-Agent", "Tiros")
+		gatewaysLoop:
+			for _, gateway := range currentGateways {
+				// gateways with a weight below 1 are only probed in
+				// that share of iterations
+				if rand.Float64() >= gateway.ProbeWeight() {
+					continue
+				}
 
-	// Optional shared secret so trusted gateways can distinguish tiros probes
-	// from arbitrary clients (e.g. to bypass rate limits or bot protection).
-	// Configure the auth key in the tiros deployment and mirror the same value
-	// on the gateway side. If unset, the header is omitted and the probe still
-	// works against public gateways.
-	if g.authKey != "" {
-		req.Header.Set("Tiros-Auth", g.authKey)
-	}
+				queueFull := scheduler.Full(gateway.Host)
 
-	// Set appropriate Accept header for CAR format
-	switch g.format {
-	case db.GatewayProbeFormatNone:
-		// none
-	case db.GatewayProbeFormatRaw:
-		req.Header.Set("Accept", "application/vnd.ipld.raw")
-	case db.GatewayProbeFormatCAR:
-		req.Header.Set("Accept", "application/vnd.ipld.car")
-	default:
-		panic(fmt.Sprintf("unknown gateway probe format: %s", g.format))
-	}
+				state := &gatewayState{
+					gateway: gateway,
+					failed:  make(map[gw.Protocol]bool, len(protocols)),
+				}
 
-	// Execute request
-	resp, err := client.Do(req)
-	if err != nil {
-		metrics.err = fmt.Errorf("executing request: %w", err)
-		metrics.downloadEnd = time.Now()
-		return metrics
-	}
-	defer resp.Body.Close()
+				for _, variant := range variants {
+					if !gw.FormatApplies(variant.Format, contentPath) || !gateway.Supports(&variant) {
+						continue
+					}
 
-	metrics.statusCode = resp.StatusCode
-	metrics.headers = resp.Header
+					for _, protocol := range protocols {
+						req := variant
+						gateway.Apply(&req)
+						req.Path = contentPath
+						req.Protocol = protocol
+						req.AuthKey = authKeys[gateway.Host]
+						req.CachePrecheck = probeGatewaysConfig.CachePrecheck
+						req.SizePrecheck = sizePrecheck
+						req.NegativeChecks = probeGatewaysConfig.NegativeChecks
+						req.IPFamily = ipFamily
+
+						// a gateway that can't keep up gets a single skipped
+						// row instead of more queued probes
+						if queueFull {
+							skippedCounter.Add(gctx, 1, metric.WithAttributes(
+								attribute.String("gateway", gateway.Host),
+								attribute.String("reason", gw.SkippedReasonQueueFull),
+							))
 
-	// Store final URL (may differ from initial if redirected)
-	if resp.Request != nil && resp.Request.URL != nil {
-		metrics.finalURL = resp.Request.URL.String()
-	}
+							slog.With("path", contentPath.String(), "gateway", gateway.Host).Debug("Skipping gateway with full queue")
+							if err := insertProbe(gctx, gw.Skipped(&req, gw.SkippedReasonQueueFull), gateway, sel); err != nil {
+								return err
+							}
+							continue gatewaysLoop
+						}
 
-	// Check for error status codes
-	if resp.StatusCode >= 400 {
-		metrics.err = fmt.Errorf("HTTP %d", resp.StatusCode)
-		metrics.downloadEnd = time.Now()
-		return metrics
-	}
+						lease.add()
+						scheduler.Push(&gw.Job{
+							Request: req,
+							Run: func(ctx context.Context, job *gw.Job) error {
+								defer lease.done()
+								return probe(ctx, job, state, lease)
+							},
+						})
+					}
+				}
+			}
 
-	// Read response body up to maxBytes, always capturing bytes in buffer
-	var buf bytes.Buffer
-	teeReader := io.TeeReader(io.LimitReader(resp.Body, g.maxBytes), &buf)
+			lease.done()
+		}
 
-	bytesRead, err := io.Copy(io.Discard, teeReader)
-	metrics.bytesReceived = bytesRead
-	metrics.downloadEnd = time.Now()
+		return nil
+	})
 
-	if err != nil && err != io.EOF {
-		metrics.err = fmt.Errorf("reading response: %w", err)
-		return metrics
-	}
+	return g.Wait()
+}
 
-	// Validate CAR format if applicable
-	if g.format != db.GatewayProbeFormatCAR || resp.StatusCode != 200 {
-		return metrics
-	}
+// roundLease releases the lease of a CID once all of its probes are done.
+type roundLease struct {
+	lease   *pkg.CIDLease
+	pending atomic.Int64
+}
 
-	// set to false by default
-	metrics.carValidated = toPtr(false)
+func (l *roundLease) add() {
+	l.pending.Add(1)
+}
 
-	if buf.Len() == 0 {
-		return metrics
+func (l *roundLease) done() {
+	if l.pending.Add(-1) == 0 {
+		l.lease.Release()
 	}
+}
 
-	// Try to parse the CAR file
-	carReader, err := carv2.NewBlockReader(bytes.NewReader(buf.Bytes()))
-	if err != nil {
-		return metrics
-	}
+// coldProbesMax is the number of gateway and CID pairs whose first probe is
+// remembered, so that the memory stays bounded if many CIDs are probed within
+// --cache.cold.ttl.
+const coldProbesMax = 100_000
 
-	// Check if requested CID is in the roots
-	for _, root := range carReader.Roots {
-		if root.Equals(g.cid) {
-			metrics.carValidated = toPtr(true)
-			break
-		}
+// gatewayState tracks the probes of a gateway for a single CID: a protocol
+// that failed is not tried again, and after a skipped probe the gateway isn't
+// probed again for this CID.
+type gatewayState struct {
+	gateway *gw.Gateway
+
+	mu      sync.Mutex
+	failed  map[gw.Protocol]bool
+	skipped bool
+}
+
+func (s *gatewayState) runs(protocol gw.Protocol) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.skipped && !s.failed[protocol]
+}
+
+func (s *gatewayState) record(protocol gw.Protocol, result *gw.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if result.SkippedReason != "" {
+		// one row per gateway and CID is enough to derive the outage
+		s.skipped = true
+	} else if result.Err != nil {
+		s.failed[protocol] = true
 	}
+}
 
-	return metrics
+// cidString returns an empty string for undefined CIDs, e.g., of /ipns paths.
+func cidString(c cid.Cid) string {
+	if !c.Defined() {
+		return ""
+	}
+	return c.String()
 }
 
 func deref[T any](p *T) T {
@@ -222,23 +222,16 @@ func probeKuboAction(ctx context.Context, cmd *cli.Command) error {
 	}
 	defer pllog.Defer(dbClient.Close, "Failed closing database client")
 
-	var cidProvider pkg.CIDProvider
+	// cid provider not needed for upload only
+	var cidProvider *pkg.WeightedCIDProvider
 	if !probeKuboConfig.UploadOnly {
-		if len(probeKuboConfig.DownloadCIDs) > 0 {
-			// cid provider not needed for upload only
-			cidProvider, err = pkg.NewStaticCIDProvider(probeKuboConfig.DownloadCIDs)
-			if err != nil {
-				return fmt.Errorf("creating static cid provider: %w", err)
-			}
-			slog.Info("Using CID provider for Kubo probes: StaticCIDProvider")
-		} else {
-			// cid provider not needed for upload only
-			cidProvider, err = pkg.NewBitswapSnifferClickhouseCIDProvider(dbClient)
-			if err != nil {
-				return fmt.Errorf("creating clickhouse cid provider: %w", err)
-			}
-			slog.Info("Using CID provider for Kubo probes: BitswapSnifferClickhouseCIDProvider")
+		cidProvider, err = newCIDProvider(ctx, dbClient, cidProviderConfig{
+			StaticCIDs: probeKuboConfig.DownloadCIDs,
+		})
+		if err != nil {
+			return fmt.Errorf("creating cid provider: %w", err)
 		}
+		slog.With("sources", cidProvider.String()).Info("Using CID sources for Kubo probes")
 	}
 
 	kuboCfg := &kubo.KuboConfig{
@@ -366,13 +359,18 @@ func probeKuboAction(ctx context.Context, cmd *cli.Command) error {
 				slog.Info(strings.Repeat("-", 80))
 
 				slog.With("origin", origin).Info("Starting download measurement")
-				ciid, err := cidProvider.SelectCID(ctx, origin)
+				sel, err := cidProvider.Select(ctx, origin)
 				if errors.Is(err, sql.ErrNoRows) {
 					slog.With("origin", origin).Info("No CID found in database")
 					continue
 				} else if err != nil {
 					return fmt.Errorf("selecting cid from database: %w", err)
 				}
+				ciid := sel.CID
+				if !ciid.Defined() {
+					slog.With("origin", origin, "path", sel.ContentPath().String()).Info("Skipping content path without a CID")
+					continue
+				}
 
 				dr, err := kubo.Download(ctx, ciid)
 				downloadCounter.Add(ctx, 1, metric.WithAttributes(
@@ -380,11 +378,6 @@ func probeKuboAction(ctx context.Context, cmd *cli.Command) error {
 					attribute.Bool("success", err == nil),
 				))
 
-				cidSource := "bitsniffer_" + origin
-				if _, ok := cidProvider.(*pkg.StaticCIDProvider); ok {
-					cidSource = "static"
-				}
-
 				dbDownload := &db.DownloadModel{
 					RunID:                runID.String(),
 					Region:               rootConfig.AWSRegion,
@@ -408,7 +401,9 @@ func probeKuboAction(ctx context.Context, cmd *cli.Command) error {
 					IPNIStatus:           toPtr(int32(dr.IPNIStatus)),
 					FirstBlockReceivedAt: toPtr(dr.FirstBlockReceivedAt),
 					DiscoveryMethod:      toPtr(dr.DiscoveryMethod),
-					CIDSource:            cidSource,
+					CIDSource:            sel.Source,
+					CIDMetadata:          sel.Metadata,
+					CIDSizeClass:         pkg.ControlledSizeClass(sel.CID),
 				}
 				if err != nil {
 					slog.With("err", err).Warn("Error downloading file from Kubo")
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"math/rand/v2"
 	"net"
 	"net/url"
 	"strconv"
@@ -14,7 +13,6 @@ import (
 
 	"github.com/chromedp/chromedp"
 	"github.com/google/uuid"
-	"github.com/ipfs/go-cid"
 	pllog "github.com/probe-lab/go-commons/log"
 	"github.com/probe-lab/tiros/pkg"
 	"github.com/probe-lab/tiros/pkg/db"
@@ -110,15 +108,15 @@ var probeServiceWorkerFlags = []cli.Flag{
 		Name:        "controlled.cids",
 		Usage:       "Whether to use the ControlledCIDProvider to select CIDs to probe",
 		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CONTROLLED_CIDS"),
-		Value:       probeGatewaysConfig.ControlledCIDs,
-		Destination: &probeGatewaysConfig.ControlledCIDs,
+		Value:       probeServiceWorkerConfig.ControlledCIDs,
+		Destination: &probeServiceWorkerConfig.ControlledCIDs,
 	},
 	&cli.Float32Flag{
 		Name:        "controlled.share",
 		Usage:       "What share of requests should be made for controlled CIDs",
 		Sources:     cli.EnvVars("TIROS_PROBE_GATEWAYS_CONTROLLED_SHARE"),
-		Value:       probeGatewaysConfig.ControlledShare,
-		Destination: &probeGatewaysConfig.ControlledShare,
+		Value:       probeServiceWorkerConfig.ControlledShare,
+		Destination: &probeServiceWorkerConfig.ControlledShare,
 	},
 }
 
@@ -153,27 +151,15 @@ func probeServiceWorkerAction(ctx context.Context, cmd *cli.Command) error {
 	defer pllog.Defer(dbClient.Close, "Failed closing database client")
 
 	// Initialize CID provider
-	var cidProvider pkg.CIDProvider
-	var cidProviderName string
-	if len(probeServiceWorkerConfig.DownloadCIDs) > 0 {
-		cidProvider, err = pkg.NewStaticCIDProvider(probeServiceWorkerConfig.DownloadCIDs)
-		if err != nil {
-			return fmt.Errorf("creating static cid provider: %w", err)
-		}
-		cidProviderName = "StaticCIDProvider"
-	} else {
-		cidProvider, err = pkg.NewBitswapSnifferClickhouseCIDProvider(dbClient)
-		if err != nil {
-			return fmt.Errorf("creating clickhouse cid provider: %w", err)
-		}
-		cidProviderName = "BitswapSnifferClickhouseCIDProvider"
-	}
-	slog.With("provider", cidProviderName).Info("Using CID provider for service worker probes")
-
-	controlledCIDsProvider, err := pkg.NewControlledCIDProvider()
+	cidProvider, err := newCIDProvider(ctx, dbClient, cidProviderConfig{
+		StaticCIDs:      probeServiceWorkerConfig.DownloadCIDs,
+		ControlledCIDs:  probeServiceWorkerConfig.ControlledCIDs,
+		ControlledShare: probeServiceWorkerConfig.ControlledShare,
+	})
 	if err != nil {
-		return fmt.Errorf("creating controlled cid provider: %w", err)
+		return fmt.Errorf("creating cid provider: %w", err)
 	}
+	slog.With("sources", cidProvider.String()).Info("Using CID sources for service worker probes")
 
 	// Use configured gateways (defaults to inbrowser.link)
 	gateways := probeServiceWorkerConfig.Gateways
@@ -216,26 +202,14 @@ func probeServiceWorkerAction(ctx context.Context, cmd *cli.Command) error {
 
 		slog.With("iteration", i).Info("Starting new service worker probing iteration...")
 
-		var ciid cid.Cid
-		var cidSource string
-		if rand.Float32() < probeGatewaysConfig.ControlledShare {
-			cidSource = "controlled"
-			ciid, err = controlledCIDsProvider.SelectCID(ctx, "controlled")
-		} else {
-			ciid, err = cidProvider.SelectCID(ctx, "dht")
-
-			cidSource = "bitsniffer_bitswap"
-			if _, ok := cidProvider.(*pkg.StaticCIDProvider); ok {
-				cidSource = "static"
-			}
-		}
-
+		sel, err := cidProvider.Select(ctx, "dht")
 		if errors.Is(err, sql.ErrNoRows) {
 			slog.With("err", err).Warn("No CID available for service worker probing")
 			continue
 		} else if err != nil {
 			return fmt.Errorf("selecting cid from database: %w", err)
 		}
+		ciid, cidSource, contentPath := sel.CID, sel.Source, sel.ContentPath()
 
 		// Probe each gateway
 		for _, gateway := range gateways {
@@ -243,7 +217,7 @@ func probeServiceWorkerAction(ctx context.Context, cmd *cli.Command) error {
 			navURL := url.URL{
 				Scheme: "https",
 				Host:   gateway,
-				Path:   "/ipfs/" + ciid.String(),
+				Path:   contentPath.String(),
 			}
 
 			// Create and run probe
@@ -272,16 +246,20 @@ func probeServiceWorkerAction(ctx context.Context, cmd *cli.Command) error {
 			}
 
 			dbModel := &db.ServiceWorkerProbeModel{
-				RunID:         runID.String(),
-				Region:        rootConfig.AWSRegion,
-				TirosVersion:  cmd.Root().Version,
-				Gateway:       gateway,
-				CID:           ciid.String(),
-				CIDSource:     cidSource,
-				URL:           navURL.String(),
-				Error:         errStr,
-				CreatedAt:     time.Now(),
-				ServerTimings: "{}",
+				RunID:          runID.String(),
+				Region:         rootConfig.AWSRegion,
+				TirosVersion:   cmd.Root().Version,
+				Gateway:        gateway,
+				CID:            cidString(ciid),
+				CIDSource:      cidSource,
+				CIDMetadata:    sel.Metadata,
+				CIDSizeClass:   pkg.ControlledSizeClass(sel.CID),
+				ContentPath:    contentPath.String(),
+				ResolutionType: string(contentPath.ResolutionType()),
+				URL:            navURL.String(),
+				Error:          errStr,
+				CreatedAt:      time.Now(),
+				ServerTimings:  "{}",
 			}
 
 			// Populate fields from result if successful
@@ -296,6 +274,7 @@ func probeServiceWorkerAction(ctx context.Context, cmd *cli.Command) error {
 				dbModel.ContentLength = toPtr(result.ContentLength)
 				dbModel.IPFSPath = toPtr(result.IPFSPath)
 				dbModel.IPFSRoots = toPtr(result.IPFSRoots)
+				dbModel.ResolvedRoot = pkg.ParseResolvedRoot(result.IPFSRoots)
 				dbModel.FoundProviders = result.FoundProviders
 				dbModel.ServedFromGateway = result.ServedFromGateway
 				dbModel.GatewayCacheStatus = result.GatewayCacheStatus
@@ -328,7 +307,7 @@ func probeServiceWorkerAction(ctx context.Context, cmd *cli.Command) error {
 				"finalTTFBs", deref(dbModel.FinalTTFBS),
 				"stFirstBlockS", deref(dbModel.STFirstBlockS),
 				"serverTimingCount", len(dbModel.ServerTimingName),
-				"cid", ciid.String(),
+				"path", contentPath.String(),
 			).Info("Inserting service worker probe into database")
 
 			if err := dbClient.InsertServiceWorkerProbe(ctx, dbModel); err != nil {
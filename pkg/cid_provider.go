@@ -2,14 +2,13 @@ package pkg
 
 import (
 	"context"
-	_ "embed"
+	"database/sql"
 	"fmt"
 	"log/slog"
 	"math/rand/v2"
 	"net"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -32,6 +31,7 @@ type CIDProvider interface {
 }
 
 type StaticCIDProvider struct {
+	mu   sync.Mutex
 	cids []cid.Cid
 	idx  int
 }
@@ -39,19 +39,31 @@ type StaticCIDProvider struct {
 var _ CIDProvider = (*StaticCIDProvider)(nil)
 
 func NewStaticCIDProvider(cids []string) (*StaticCIDProvider, error) {
-	testCIDs := make([]cid.Cid, 0, len(cids))
+	testCIDs, err := ParseCIDs(cids)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StaticCIDProvider{cids: testCIDs}, nil
+}
+
+// ParseCIDs parses the given list of CID strings.
+func ParseCIDs(cids []string) ([]cid.Cid, error) {
+	parsed := make([]cid.Cid, 0, len(cids))
 	for _, c := range cids {
 		parse, err := cid.Parse(c)
 		if err != nil {
 			return nil, fmt.Errorf("parsing cid: %w", err)
 		}
-		testCIDs = append(testCIDs, parse)
+		parsed = append(parsed, parse)
 	}
-
-	return &StaticCIDProvider{cids: testCIDs}, nil
+	return parsed, nil
 }
 
 func (p *StaticCIDProvider) SelectCID(ctx context.Context, origin string) (cid.Cid, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	testCID := p.cids[p.idx]
 	p.idx += 1
 	p.idx %= len(p.cids)
@@ -130,6 +142,8 @@ func (p *BitswapSnifferClickhouseCIDProvider) SelectCID(ctx context.Context, ori
 		if c.Prefix().Codec != uint64(multicodec.DagPb) {
 			c = cid.NewCidV1(uint64(multicodec.Raw), c.Hash())
 		}
+	} else if rows.Err() == nil {
+		return c, sql.ErrNoRows
 	}
 
 	return c, rows.Err()
@@ -179,45 +193,81 @@ func (p *KuboCIDProvider) SelectCID(ctx context.Context, origin string) (cid.Cid
 	return p.cids[idx], nil
 }
 
-//go:embed controlledcids
-var controlledCIDs string
-
+// ControlledCIDProvider selects CIDs from the controlled classes. It first
+// picks a class proportionally to its share and then iterates the shuffled
+// CIDs of that class.
 type ControlledCIDProvider struct {
-	cids []cid.Cid
-	idx  atomic.Int32
+	classes []*controlledClassCIDs
+	total   float64
+}
+
+type controlledClassCIDs struct {
+	name  string
+	share float64
+	cids  []cid.Cid
+	idx   atomic.Int32
 }
 
 var _ CIDProvider = (*ControlledCIDProvider)(nil)
 
-func NewControlledCIDProvider() (*ControlledCIDProvider, error) {
-	var cids []cid.Cid
-	for _, line := range strings.Split(controlledCIDs, "\n") {
-		if strings.HasPrefix(line, "#") {
-			continue
+// NewControlledCIDProvider initializes a provider for the controlled classes
+// with the given shares by class name. If shares is empty, the default share
+// of every class is used. Classes without any embedded CIDs are skipped.
+func NewControlledCIDProvider(shares map[string]float64) (*ControlledCIDProvider, error) {
+	for name := range shares {
+		if _, found := ControlledClassByName(name); !found {
+			return nil, fmt.Errorf("unknown controlled class %q", name)
+		}
+	}
+
+	p := &ControlledCIDProvider{}
+	for _, class := range ControlledClasses {
+		share := class.Share
+		if len(shares) > 0 {
+			share = shares[class.Name]
 		}
 
-		if strings.TrimSpace(line) == "" {
+		if share < 0 {
+			return nil, fmt.Errorf("negative share for controlled class %s", class.Name)
+		} else if share == 0 {
 			continue
 		}
 
-		c, err := cid.Parse(strings.TrimSpace(line))
-		if err != nil {
-			slog.Warn("failed to parse CID from controlledcids file", "err", err)
+		cids := class.CIDs()
+		if len(cids) == 0 {
+			slog.Warn("No CIDs for controlled class", "class", class.Name)
 			continue
 		}
-		cids = append(cids, c)
+
+		// shuffle once and then iterate in order with every call to SelectCID
+		rand.Shuffle(len(cids), func(i, j int) { cids[i], cids[j] = cids[j], cids[i] })
+
+		p.classes = append(p.classes, &controlledClassCIDs{name: class.Name, share: share, cids: cids})
+		p.total += share
 	}
 
-	// shuffle once and then iterate in order with every call to SelectCID
-	rand.Shuffle(len(cids), func(i, j int) { cids[i], cids[j] = cids[j], cids[i] })
+	if len(p.classes) == 0 {
+		return nil, fmt.Errorf("no controlled classes with CIDs configured")
+	}
 
-	return &ControlledCIDProvider{cids: cids}, nil
+	return p, nil
 }
 
 func (p *ControlledCIDProvider) SelectCID(ctx context.Context, origin string) (cid.Cid, error) {
-	idx := p.idx.Add(1)
-	idx %= int32(len(p.cids))
-	return p.cids[idx], nil
+	class := p.classes[len(p.classes)-1]
+
+	f := rand.Float64() * p.total
+	for _, c := range p.classes {
+		if f < c.share {
+			class = c
+			break
+		}
+		f -= c.share
+	}
+
+	idx := class.idx.Add(1)
+	idx %= int32(len(class.cids))
+	return class.cids[idx], nil
 }
 
 type NoopCIDProvider struct{}
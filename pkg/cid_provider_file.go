@@ -0,0 +1,431 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	pllog "github.com/probe-lab/go-commons/log"
+)
+
+// CIDEntry is a single entry of a CID list together with optional metadata
+// that is attached to every probe of that CID. Instead of a CID, an entry can
+// hold an /ipns/<key> or /ipns/<domain> name, in which case CID is undefined.
+type CIDEntry struct {
+	CID      cid.Cid
+	Name     string   // IPNS key or DNSLink domain of /ipns entries
+	Size     *int64   // expected size of the content in bytes
+	MIMEType string   // expected MIME type of the content
+	Codec    string   // codec of the root block (e.g., "dag-pb", "raw")
+	Path     string   // path below the CID (e.g., "/index.html")
+	Tags     []string // arbitrary tags to group probes by in analysis
+}
+
+// ContentPath returns the path that should be requested for the entry.
+func (e *CIDEntry) ContentPath() ContentPath {
+	if e.Name != "" {
+		return ContentPath{Namespace: "ipns", Root: e.Name, SubPath: e.Path}
+	}
+
+	p := CIDPath(e.CID)
+	p.SubPath = e.Path
+	return p
+}
+
+// Metadata returns the non-empty fields of the entry as a flat map.
+func (e *CIDEntry) Metadata() map[string]string {
+	md := map[string]string{}
+	if e.Size != nil {
+		md["size"] = strconv.FormatInt(*e.Size, 10)
+	}
+	if e.MIMEType != "" {
+		md["mime_type"] = e.MIMEType
+	}
+	if e.Codec != "" {
+		md["codec"] = e.Codec
+	}
+	if e.Path != "" {
+		md["path"] = e.Path
+	}
+	if len(e.Tags) > 0 {
+		md["tags"] = strings.Join(e.Tags, ",")
+	}
+	return md
+}
+
+// FileCIDProvider serves CIDs from a CSV or NDJSON list that is read from a
+// local file or an HTTP(S) URL. The list is reloaded lazily: at most once per
+// reload interval, the provider checks whether the list has changed (by
+// modification time for files and by ETag/Last-Modified for URLs) and
+// replaces the list if so. The list is fetched and parsed without holding the
+// lock, so other callers keep selecting from the previous list while one of
+// them reloads it. If reloading fails, the provider keeps serving the
+// previous list.
+//
+// CSV lists may have a header row with the columns cid, size, mime_type,
+// codec, path, and tags (separated by "|"). Without a header, only the first
+// column is interpreted as the CID. Instead of a bare CID, the cid column can
+// hold a content path like /ipfs/<cid>/sub/path, /ipns/<key>, or
+// /ipns/<domain>. A sub path in the cid column takes precedence over the path
+// column. NDJSON lists contain one JSON object per
+// line with the same keys, where tags is an array of strings.
+type FileCIDProvider struct {
+	location       string
+	reloadInterval time.Duration
+	client         *http.Client
+
+	mu        sync.Mutex
+	entries   []*CIDEntry
+	idx       int
+	lastCheck time.Time
+	reloading bool
+
+	// only accessed by the single caller that reloads
+	modTime      time.Time
+	etag         string
+	lastModified string
+}
+
+var _ SelectingCIDProvider = (*FileCIDProvider)(nil)
+
+// NewFileCIDProvider loads the CID list from the given location. A reload
+// interval of zero disables reloading.
+func NewFileCIDProvider(ctx context.Context, location string, reloadInterval time.Duration) (*FileCIDProvider, error) {
+	p := &FileCIDProvider{
+		location:       location,
+		reloadInterval: reloadInterval,
+		client:         &http.Client{Timeout: 30 * time.Second},
+	}
+
+	entries, err := p.load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading cid list from %s: %w", location, err)
+	} else if len(entries) == 0 {
+		return nil, fmt.Errorf("no cids in %s", location)
+	}
+
+	p.entries = entries
+	p.lastCheck = time.Now()
+
+	return p, nil
+}
+
+func (p *FileCIDProvider) SelectCID(ctx context.Context, origin string) (cid.Cid, error) {
+	sel, err := p.Select(ctx, origin)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return sel.CID, nil
+}
+
+func (p *FileCIDProvider) Select(ctx context.Context, origin string) (*SelectedCID, error) {
+	p.maybeReload(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry := p.entries[p.idx%len(p.entries)]
+	p.idx = (p.idx + 1) % len(p.entries)
+
+	return &SelectedCID{
+		CID:      entry.CID,
+		Path:     entry.ContentPath(),
+		Metadata: entry.Metadata(),
+	}, nil
+}
+
+// maybeReload reloads the list if the reload interval has passed since the
+// last check. Only one caller reloads at a time, all others return right away.
+func (p *FileCIDProvider) maybeReload(ctx context.Context) {
+	if p.reloadInterval <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	due := !p.reloading && time.Since(p.lastCheck) >= p.reloadInterval
+	if due {
+		p.reloading = true
+		p.lastCheck = time.Now()
+	}
+	p.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	entries, err := p.load(ctx)
+
+	p.mu.Lock()
+	p.reloading = false
+	if err == nil && entries != nil {
+		p.entries = entries
+		p.idx = 0
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		slog.With("location", p.location, "err", err).Warn("Failed reloading cid list, keeping previous list")
+	} else if entries != nil {
+		slog.With("location", p.location, "count", len(entries)).Info("Reloaded cid list")
+	}
+}
+
+// load reads and parses the list. It returns nil entries if the list hasn't
+// changed since the last load. It must not be called concurrently.
+func (p *FileCIDProvider) load(ctx context.Context) ([]*CIDEntry, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if strings.HasPrefix(p.location, "http://") || strings.HasPrefix(p.location, "https://") {
+		data, err = p.fetchURL(ctx)
+	} else {
+		data, err = p.readFile()
+	}
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	entries, err := ParseCIDEntries(p.location, data)
+	if err != nil {
+		return nil, err
+	} else if len(entries) == 0 {
+		return nil, fmt.Errorf("cid list is empty")
+	}
+
+	return entries, nil
+}
+
+// readFile returns nil data if the file hasn't changed since the last load.
+func (p *FileCIDProvider) readFile() ([]byte, error) {
+	fi, err := os.Stat(p.location)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.modTime.IsZero() && fi.ModTime().Equal(p.modTime) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(p.location)
+	if err != nil {
+		return nil, err
+	}
+	p.modTime = fi.ModTime()
+
+	return data, nil
+}
+
+// fetchURL returns nil data if the server reports that the list hasn't
+// changed since the last load.
+func (p *FileCIDProvider) fetchURL(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	if p.lastModified != "" {
+		req.Header.Set("If-Modified-Since", p.lastModified)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer pllog.Defer(resp.Body.Close, "Failed closing cid list response body")
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// pass
+	case http.StatusNotModified:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	p.etag = resp.Header.Get("ETag")
+	p.lastModified = resp.Header.Get("Last-Modified")
+
+	return data, nil
+}
+
+// ParseCIDEntries parses a CSV or NDJSON CID list. The format is derived from
+// the file extension of name (.csv, .txt, .ndjson, .jsonl) and falls back to
+// sniffing the first non-empty line.
+func ParseCIDEntries(name string, data []byte) ([]*CIDEntry, error) {
+	switch strings.ToLower(filepath.Ext(strings.SplitN(name, "?", 2)[0])) {
+	case ".ndjson", ".jsonl":
+		return parseNDJSONCIDEntries(data)
+	case ".csv", ".txt":
+		return parseCSVCIDEntries(data)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseNDJSONCIDEntries(data)
+	}
+
+	return parseCSVCIDEntries(data)
+}
+
+type ndjsonCIDEntry struct {
+	CID      string   `json:"cid"`
+	Size     *int64   `json:"size"`
+	MIMEType string   `json:"mime_type"`
+	Codec    string   `json:"codec"`
+	Path     string   `json:"path"`
+	Tags     []string `json:"tags"`
+}
+
+func parseNDJSONCIDEntries(data []byte) ([]*CIDEntry, error) {
+	var entries []*CIDEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var raw ndjsonCIDEntry
+		if err := json.Unmarshal(line, &raw); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		entry := &CIDEntry{
+			Size:     raw.Size,
+			MIMEType: raw.MIMEType,
+			Codec:    raw.Codec,
+			Path:     normalizeCIDEntryPath(raw.Path),
+			Tags:     raw.Tags,
+		}
+
+		if err := entry.setContentPath(raw.CID); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func parseCSVCIDEntries(data []byte) ([]*CIDEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	// without a header, only the first column holds the CID
+	columns := map[string]int{"cid": 0}
+	if _, err := ParseContentPath(records[0][0]); err != nil {
+		columns = map[string]int{}
+		for i, col := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(col))] = i
+		}
+		records = records[1:]
+
+		if _, found := columns["cid"]; !found {
+			return nil, fmt.Errorf("csv header without cid column")
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, found := columns[name]
+		if !found || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	entries := make([]*CIDEntry, 0, len(records))
+	for i, record := range records {
+		entry := &CIDEntry{
+			MIMEType: field(record, "mime_type"),
+			Codec:    field(record, "codec"),
+			Path:     normalizeCIDEntryPath(field(record, "path")),
+		}
+
+		if err := entry.setContentPath(field(record, "cid")); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+
+		if sizeStr := field(record, "size"); sizeStr != "" {
+			size, err := strconv.ParseInt(sizeStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("record %d: parsing size: %w", i+1, err)
+			}
+			entry.Size = &size
+		}
+
+		if tagsStr := field(record, "tags"); tagsStr != "" {
+			for _, tag := range strings.Split(tagsStr, "|") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					entry.Tags = append(entry.Tags, tag)
+				}
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// setContentPath sets the CID or IPNS name of the entry from the given bare
+// CID or content path.
+func (e *CIDEntry) setContentPath(s string) error {
+	p, err := ParseContentPath(s)
+	if err != nil {
+		return fmt.Errorf("parsing cid: %w", err)
+	}
+
+	if p.Namespace == "ipns" {
+		e.Name = p.Root
+	} else {
+		e.CID = p.CID()
+	}
+
+	if p.SubPath != "" {
+		e.Path = p.SubPath
+	}
+
+	return nil
+}
+
+func normalizeCIDEntryPath(p string) string {
+	if p == "" || p == "/" {
+		return ""
+	}
+	return "/" + strings.TrimPrefix(p, "/")
+}
@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testCID1 = "QmUvSqPqYsjeab2JgsNc4PjbAGnCzfn5xid6piJgYYzehH"
+	testCID2 = "bafybeigvylgfkdzxw2nxlzlij23ocx73yg77dxtlnb37bg6lo5n34nrrpu"
+)
+
+func TestParseCIDEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		data     string
+		wantLen  int
+		wantMD   map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "plain_list",
+			fileName: "cids.txt",
+			data:     "# comment\n" + testCID1 + "\n\n" + testCID2 + "\n",
+			wantLen:  2,
+			wantMD:   map[string]string{},
+		},
+		{
+			name:     "csv_with_header",
+			fileName: "cids.csv",
+			data:     "cid,size,mime_type,codec,path,tags\n" + testCID1 + ",1024,text/plain,dag-pb,index.html,a|b\n" + testCID2 + ",,,,,\n",
+			wantLen:  2,
+			wantMD: map[string]string{
+				"size":      "1024",
+				"mime_type": "text/plain",
+				"codec":     "dag-pb",
+				"path":      "/index.html",
+				"tags":      "a,b",
+			},
+		},
+		{
+			name:     "ndjson",
+			fileName: "cids.ndjson",
+			data:     `{"cid":"` + testCID1 + `","size":5,"tags":["x"]}` + "\n" + `{"cid":"` + testCID2 + `"}`,
+			wantLen:  2,
+			wantMD:   map[string]string{"size": "5", "tags": "x"},
+		},
+		{
+			name:     "ndjson_sniffed",
+			fileName: "https://example.com/cids",
+			data:     `{"cid":"` + testCID1 + `","codec":"raw"}`,
+			wantLen:  1,
+			wantMD:   map[string]string{"codec": "raw"},
+		},
+		{
+			name:     "csv_without_cid_column",
+			fileName: "cids.csv",
+			data:     "size,path\n1,/a\n",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid_cid",
+			fileName: "cids.csv",
+			data:     "cid\nnot-a-cid\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseCIDEntries(tt.fileName, []byte(tt.data))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, entries, tt.wantLen)
+			assert.Equal(t, testCID1, entries[0].CID.String())
+			assert.Equal(t, tt.wantMD, entries[0].Metadata())
+		})
+	}
+}
+
+func TestFileCIDProvider_reload(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "cids.txt")
+	require.NoError(t, os.WriteFile(path, []byte(testCID1), 0o644))
+
+	p, err := NewFileCIDProvider(ctx, path, time.Nanosecond)
+	require.NoError(t, err)
+
+	c, err := p.SelectCID(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, testCID1, c.String())
+
+	require.NoError(t, os.WriteFile(path, []byte(testCID2), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	c, err = p.SelectCID(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, testCID2, c.String())
+
+	// a broken list keeps the previous one
+	require.NoError(t, os.WriteFile(path, []byte("not-a-cid"), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+
+	c, err = p.SelectCID(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, testCID2, c.String())
+}
+
+func TestFileCIDProvider_url(t *testing.T) {
+	ctx := context.Background()
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("cid,tags\n" + testCID1 + ",remote\n"))
+	}))
+	defer srv.Close()
+
+	p, err := NewFileCIDProvider(ctx, srv.URL+"/cids.csv", time.Nanosecond)
+	require.NoError(t, err)
+
+	sel, err := p.Select(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, testCID1, sel.CID.String())
+	assert.Equal(t, "remote", sel.Metadata["tags"])
+	assert.Equal(t, 2, requests)
+}
+
+func TestFileCIDProvider_slowReload(t *testing.T) {
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			<-release
+		}
+		_, _ = w.Write([]byte(testCID1 + "\n"))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	p, err := NewFileCIDProvider(ctx, srv.URL+"/cids.csv", time.Nanosecond)
+	require.NoError(t, err)
+
+	// the first select hangs in the reload
+	go func() { _, _ = p.Select(ctx, "") }()
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.reloading
+	}, time.Second, time.Millisecond)
+
+	// all others select from the previous list in the meantime
+	selected := make(chan struct{})
+	go func() {
+		_, _ = p.Select(ctx, "")
+		close(selected)
+	}()
+
+	select {
+	case <-selected:
+	case <-time.After(time.Second):
+		t.Fatal("select blocked on the reload")
+	}
+}
+
+func TestParseCIDEntries_contentPaths(t *testing.T) {
+	data := "cid,path\n/ipns/en.wikipedia-on-ipfs.org/wiki,\n/ipfs/" + testCID2 + "/a/b,/ignored\n" + testCID1 + ",index.html\n"
+
+	entries, err := ParseCIDEntries("cids.csv", []byte(data))
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.False(t, entries[0].CID.Defined())
+	assert.Equal(t, "/ipns/en.wikipedia-on-ipfs.org/wiki", entries[0].ContentPath().String())
+	assert.Equal(t, "/ipfs/"+testCID2+"/a/b", entries[1].ContentPath().String())
+	assert.Equal(t, "/ipfs/"+testCID1+"/index.html", entries[2].ContentPath().String())
+}
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ErrNoCIDAvailable is returned by LeasingCIDProvider.Lease if the underlying
+// provider only returned CIDs that are currently leased or were released
+// within the reuse window.
+var ErrNoCIDAvailable = errors.New("no unleased cid available")
+
+// CIDLease is a selected CID that is checked out by a single worker. No other
+// worker can lease the same content until Release is called.
+type CIDLease struct {
+	*SelectedCID
+
+	key      string
+	provider *LeasingCIDProvider
+	once     sync.Once
+}
+
+// Release returns the CID to the provider. It's safe to call Release multiple
+// times.
+func (l *CIDLease) Release() {
+	l.once.Do(func() {
+		l.provider.release(l.key)
+	})
+}
+
+// LeasingCIDProvider wraps a CIDProvider and makes sure that concurrent
+// workers never probe the same content at the same time. CIDs are keyed by
+// their multihash, so a CIDv0 and its CIDv1 counterpart count as the same
+// content. Content paths are keyed by their root and sub path. Optionally, a
+// released CID isn't handed out again within a reuse window.
+type LeasingCIDProvider struct {
+	provider    CIDProvider
+	reuseWindow time.Duration
+	maxAttempts int
+
+	mu       sync.Mutex
+	leased   map[string]struct{}
+	released map[string]time.Time
+}
+
+func NewLeasingCIDProvider(provider CIDProvider, reuseWindow time.Duration) *LeasingCIDProvider {
+	return &LeasingCIDProvider{
+		provider:    provider,
+		reuseWindow: reuseWindow,
+		maxAttempts: 10,
+		leased:      map[string]struct{}{},
+		released:    map[string]time.Time{},
+	}
+}
+
+// Lease selects a CID from the underlying provider that isn't currently
+// leased by another worker. It gives up with ErrNoCIDAvailable after a few
+// conflicting selections. A scheduled source whose slot was already served
+// counts as a conflict, so that other sources get a chance. If it was the
+// only source that was drawn, Lease returns ErrSlotServed.
+func (p *LeasingCIDProvider) Lease(ctx context.Context, origin string) (*CIDLease, error) {
+	slotServed := 0
+	for range p.maxAttempts {
+		var (
+			sel *SelectedCID
+			err error
+		)
+
+		if sp, ok := p.provider.(SelectingCIDProvider); ok {
+			sel, err = sp.Select(ctx, origin)
+		} else {
+			var c cid.Cid
+			c, err = p.provider.SelectCID(ctx, origin)
+			sel = &SelectedCID{CID: c}
+		}
+		if errors.Is(err, ErrSlotServed) {
+			slotServed++
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		key := leaseKey(sel)
+		if p.acquire(key) {
+			return &CIDLease{SelectedCID: sel, key: key, provider: p}, nil
+		}
+	}
+
+	if slotServed == p.maxAttempts {
+		return nil, ErrSlotServed
+	}
+
+	return nil, ErrNoCIDAvailable
+}
+
+func leaseKey(sel *SelectedCID) string {
+	path := sel.ContentPath()
+	if c := path.CID(); c.Defined() {
+		return string(c.Hash()) + path.SubPath
+	}
+	return path.String()
+}
+
+// Leased returns the number of currently leased CIDs.
+func (p *LeasingCIDProvider) Leased() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.leased)
+}
+
+func (p *LeasingCIDProvider) acquire(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, found := p.leased[key]; found {
+		return false
+	}
+
+	if p.reuseWindow > 0 {
+		now := time.Now()
+		for k, releasedAt := range p.released {
+			if now.Sub(releasedAt) >= p.reuseWindow {
+				delete(p.released, k)
+			}
+		}
+
+		if _, found := p.released[key]; found {
+			return false
+		}
+	}
+
+	p.leased[key] = struct{}{}
+	return true
+}
+
+func (p *LeasingCIDProvider) release(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.leased, key)
+	if p.reuseWindow > 0 {
+		p.released[key] = time.Now()
+	}
+}
@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeasingCIDProvider_Lease(t *testing.T) {
+	ctx := context.Background()
+
+	static, err := NewStaticCIDProvider([]string{testCID1, testCID2})
+	require.NoError(t, err)
+
+	p := NewLeasingCIDProvider(static, 0)
+
+	l1, err := p.Lease(ctx, "")
+	require.NoError(t, err)
+
+	l2, err := p.Lease(ctx, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, l1.CID, l2.CID)
+	assert.Equal(t, 2, p.Leased())
+
+	// both cids are checked out
+	_, err = p.Lease(ctx, "")
+	assert.ErrorIs(t, err, ErrNoCIDAvailable)
+
+	l1.Release()
+	l1.Release() // idempotent
+	assert.Equal(t, 1, p.Leased())
+
+	l3, err := p.Lease(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, l1.CID, l3.CID)
+}
+
+func TestLeasingCIDProvider_Lease_sameMultihash(t *testing.T) {
+	ctx := context.Background()
+
+	v0 := cid.MustParse(testCID1)
+	v1 := cid.NewCidV1(uint64(multicodec.DagPb), v0.Hash())
+
+	static, err := NewStaticCIDProvider([]string{v0.String(), v1.String()})
+	require.NoError(t, err)
+
+	p := NewLeasingCIDProvider(static, 0)
+
+	_, err = p.Lease(ctx, "")
+	require.NoError(t, err)
+
+	_, err = p.Lease(ctx, "")
+	assert.ErrorIs(t, err, ErrNoCIDAvailable)
+}
+
+func TestLeasingCIDProvider_Lease_reuseWindow(t *testing.T) {
+	ctx := context.Background()
+
+	static, err := NewStaticCIDProvider([]string{testCID1})
+	require.NoError(t, err)
+
+	p := NewLeasingCIDProvider(static, 50*time.Millisecond)
+
+	l, err := p.Lease(ctx, "")
+	require.NoError(t, err)
+	l.Release()
+
+	_, err = p.Lease(ctx, "")
+	assert.ErrorIs(t, err, ErrNoCIDAvailable)
+
+	time.Sleep(60 * time.Millisecond)
+
+	_, err = p.Lease(ctx, "")
+	assert.NoError(t, err)
+}
+
+func TestLeasingCIDProvider_Lease_slotServed(t *testing.T) {
+	ctx := context.Background()
+
+	scheduled, err := NewScheduledCIDProvider(ControlledCIDs()[:8], 42, time.Hour)
+	require.NoError(t, err)
+	scheduled.ServeOncePerSlot()
+
+	p := NewLeasingCIDProvider(scheduled, 0)
+
+	l, err := p.Lease(ctx, "")
+	require.NoError(t, err)
+	l.Release()
+
+	// the released cid isn't leased again within the slot
+	_, err = p.Lease(ctx, "")
+	assert.ErrorIs(t, err, ErrSlotServed)
+
+	// other sources are drawn instead
+	static, err := NewStaticCIDProvider([]string{testCID1})
+	require.NoError(t, err)
+
+	weighted, err := NewWeightedCIDProvider(
+		&WeightedCIDSource{Name: "scheduled", Weight: 1, Provider: scheduled},
+		&WeightedCIDSource{Name: "static", Weight: 1, Provider: static},
+	)
+	require.NoError(t, err)
+
+	p = NewLeasingCIDProvider(weighted, 0)
+	p.maxAttempts = 100
+
+	l, err = p.Lease(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, "static", l.Source)
+}
@@ -0,0 +1,297 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	pllog "github.com/probe-lab/go-commons/log"
+	"github.com/probe-lab/tiros/pkg/db"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type PooledBitswapSnifferConfig struct {
+	// RefreshInterval is how frequently the pools are refreshed in the background.
+	RefreshInterval time.Duration
+
+	// Size is the number of the most recent CIDs that are fetched per origin.
+	// The "dht" origin always uses a tenth of this to stay close to the
+	// freshest provider records.
+	Size int
+}
+
+func DefaultPooledBitswapSnifferConfig() *PooledBitswapSnifferConfig {
+	return &PooledBitswapSnifferConfig{
+		RefreshInterval: 30 * time.Second,
+		Size:            100,
+	}
+}
+
+// PooledBitswapSnifferCIDProvider is a BitswapSnifferClickhouseCIDProvider
+// that keeps a deduplicated buffer of recent CIDs per origin in memory and
+// refreshes it in the background. Selections are served at random from the
+// buffer, so concurrent workers don't hit ClickHouse on every iteration.
+//
+// Origins are registered lazily: the first selection for an origin fills its
+// pool synchronously. If the pool of an origin is empty, SelectCID returns
+// sql.ErrNoRows. If a refresh fails, the previous pool is kept.
+type PooledBitswapSnifferCIDProvider struct {
+	conn driver.Conn
+	cfg  *PooledBitswapSnifferConfig
+
+	// queryCIDs returns the most recent CIDs of the given origin as they
+	// are stored by the bitswap sniffer. Tests replace it.
+	queryCIDs func(ctx context.Context, origin string) ([]string, error)
+
+	refreshMu sync.Mutex // serializes refreshes
+
+	poolsMu sync.RWMutex
+	pools   map[string]*cidPool
+
+	cidSelectCounter   metric.Int64Counter
+	poolRefreshCounter metric.Int64Counter
+}
+
+type cidPool struct {
+	cids        []cid.Cid
+	refreshedAt time.Time
+}
+
+var _ CIDProvider = (*PooledBitswapSnifferCIDProvider)(nil)
+
+// NewPooledBitswapSnifferCIDProvider initializes the provider and starts the
+// background refresh loop that runs until the given context is canceled.
+func NewPooledBitswapSnifferCIDProvider(ctx context.Context, dbClient db.Client, cfg *PooledBitswapSnifferConfig) (*PooledBitswapSnifferCIDProvider, error) {
+	chClient, ok := dbClient.(*db.ClickhouseClient)
+	if !ok {
+		return nil, fmt.Errorf("expected clickhouse client, got: %T", dbClient)
+	}
+
+	if cfg.Size <= 0 {
+		return nil, fmt.Errorf("pool size must be positive, got %d", cfg.Size)
+	} else if cfg.RefreshInterval <= 0 {
+		return nil, fmt.Errorf("pool refresh interval must be positive, got %s", cfg.RefreshInterval)
+	}
+
+	meter := otel.GetMeterProvider().Meter("tiros")
+	cidSelectCounter, err := meter.Int64Counter("cid_select")
+	if err != nil {
+		return nil, fmt.Errorf("creating cid select counter: %w", err)
+	}
+
+	poolRefreshCounter, err := meter.Int64Counter("cid_pool_refresh")
+	if err != nil {
+		return nil, fmt.Errorf("creating cid pool refresh counter: %w", err)
+	}
+
+	p := &PooledBitswapSnifferCIDProvider{
+		conn:               chClient.Conn,
+		cfg:                cfg,
+		pools:              map[string]*cidPool{},
+		cidSelectCounter:   cidSelectCounter,
+		poolRefreshCounter: poolRefreshCounter,
+	}
+	p.queryCIDs = p.querySharedCIDs
+
+	_, err = meter.Int64ObservableGauge(
+		"cid_pool_size",
+		metric.WithDescription("Number of CIDs in the bitswap sniffer pool per origin"),
+		metric.WithInt64Callback(func(ctx context.Context, observer metric.Int64Observer) error {
+			p.poolsMu.RLock()
+			defer p.poolsMu.RUnlock()
+			for origin, pool := range p.pools {
+				observer.Observe(int64(len(pool.cids)), metric.WithAttributes(attribute.String("origin", origin)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating cid_pool_size gauge: %w", err)
+	}
+
+	_, err = meter.Float64ObservableGauge(
+		"cid_pool_age",
+		metric.WithDescription("Seconds since the bitswap sniffer pool was last refreshed successfully"),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(func(ctx context.Context, observer metric.Float64Observer) error {
+			p.poolsMu.RLock()
+			defer p.poolsMu.RUnlock()
+			for origin, pool := range p.pools {
+				if pool.refreshedAt.IsZero() {
+					continue
+				}
+				observer.Observe(time.Since(pool.refreshedAt).Seconds(), metric.WithAttributes(attribute.String("origin", origin)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating cid_pool_age gauge: %w", err)
+	}
+
+	go p.refreshLoop(ctx)
+
+	return p, nil
+}
+
+func (p *PooledBitswapSnifferCIDProvider) SelectCID(ctx context.Context, origin string) (cid.Cid, error) {
+	p.poolsMu.RLock()
+	_, known := p.pools[origin]
+	p.poolsMu.RUnlock()
+
+	if !known {
+		// register the origin so that the refresh loop picks it up
+		p.poolsMu.Lock()
+		if _, found := p.pools[origin]; !found {
+			p.pools[origin] = &cidPool{}
+		}
+		p.poolsMu.Unlock()
+
+		p.refresh(ctx, origin)
+	}
+
+	p.poolsMu.RLock()
+	pool := p.pools[origin]
+	var c cid.Cid
+	if len(pool.cids) > 0 {
+		c = pool.cids[rand.IntN(len(pool.cids))]
+	}
+	p.poolsMu.RUnlock()
+
+	p.cidSelectCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("origin", origin),
+		attribute.Bool("success", c.Defined()),
+		attribute.Bool("pooled", true),
+	))
+
+	if !c.Defined() {
+		return cid.Undef, sql.ErrNoRows
+	}
+
+	return c, nil
+}
+
+func (p *PooledBitswapSnifferCIDProvider) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.poolsMu.RLock()
+		origins := make([]string, 0, len(p.pools))
+		for origin := range p.pools {
+			origins = append(origins, origin)
+		}
+		p.poolsMu.RUnlock()
+
+		for _, origin := range origins {
+			p.refresh(ctx, origin)
+		}
+	}
+}
+
+// refresh replaces the pool of the given origin with the most recent CIDs. If
+// the query fails, the previous pool is kept.
+func (p *PooledBitswapSnifferCIDProvider) refresh(ctx context.Context, origin string) {
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+
+	cids, err := p.queryRecentCIDs(ctx, origin)
+	p.poolRefreshCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("origin", origin),
+		attribute.Bool("success", err == nil),
+	))
+	if err != nil {
+		slog.With("origin", origin, "err", err).Warn("Failed refreshing bitswap sniffer cid pool")
+		return
+	}
+
+	if len(cids) == 0 {
+		slog.With("origin", origin).Warn("Bitswap sniffer returned no cids, keeping previous pool")
+		return
+	}
+
+	p.poolsMu.Lock()
+	p.pools[origin] = &cidPool{cids: cids, refreshedAt: time.Now()}
+	p.poolsMu.Unlock()
+}
+
+// queryRecentCIDs returns the most recent CIDs of the given origin,
+// normalized and deduplicated.
+func (p *PooledBitswapSnifferCIDProvider) queryRecentCIDs(ctx context.Context, origin string) ([]cid.Cid, error) {
+	cidStrs, err := p.queryCIDs(ctx, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[cid.Cid]struct{}, len(cidStrs))
+	cids := make([]cid.Cid, 0, len(cidStrs))
+	for _, cidStr := range cidStrs {
+		c, err := cid.Parse(cidStr)
+		if err != nil {
+			slog.With("cid", cidStr, "err", err).Debug("Skipping unparsable cid from bitswap sniffer")
+			continue
+		}
+
+		// tmp fix until https://github.com/probe-lab/bitswap-sniffer/pull/11 is merged
+		if c.Prefix().Codec != uint64(multicodec.DagPb) {
+			c = cid.NewCidV1(uint64(multicodec.Raw), c.Hash())
+		}
+
+		if _, found := seen[c]; found {
+			continue
+		}
+		seen[c] = struct{}{}
+		cids = append(cids, c)
+	}
+
+	return cids, nil
+}
+
+func (p *PooledBitswapSnifferCIDProvider) querySharedCIDs(ctx context.Context, origin string) ([]string, error) {
+	msgType := "%"
+	limit := p.cfg.Size
+
+	if origin == "dht" {
+		msgType = "add-provider-records"
+		limit = max(1, p.cfg.Size/10)
+	}
+
+	rows, err := p.conn.Query(ctx, `
+		SELECT cid
+		FROM bitswap_sniffer_ipfs.shared_cids
+		WHERE origin = $1
+		  AND msg_type LIKE $2
+		ORDER BY timestamp DESC
+		LIMIT $3
+	`, origin, msgType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer pllog.Defer(rows.Close, "Failed closing rows")
+
+	cidStrs := make([]string, 0, limit)
+	for rows.Next() {
+		var cidStr string
+		if err := rows.Scan(&cidStr); err != nil {
+			return nil, err
+		}
+		cidStrs = append(cidStrs, cidStr)
+	}
+
+	return cidStrs, rows.Err()
+}
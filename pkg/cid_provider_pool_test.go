@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+const testRawCID = "bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy"
+
+// stubSniffer serves the CIDs of the bitswap sniffer per origin.
+type stubSniffer struct {
+	mu      sync.Mutex
+	cids    map[string][]string
+	err     error
+	queries map[string]int
+}
+
+func (s *stubSniffer) queryCIDs(ctx context.Context, origin string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries[origin]++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.cids[origin], nil
+}
+
+func (s *stubSniffer) set(origin string, cids []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cids[origin] = cids
+	s.err = err
+}
+
+func newTestPool(t *testing.T) (*PooledBitswapSnifferCIDProvider, *stubSniffer) {
+	t.Helper()
+
+	meter := otel.GetMeterProvider().Meter("tiros")
+	cidSelectCounter, err := meter.Int64Counter("cid_select")
+	require.NoError(t, err)
+	poolRefreshCounter, err := meter.Int64Counter("cid_pool_refresh")
+	require.NoError(t, err)
+
+	sniffer := &stubSniffer{cids: map[string][]string{}, queries: map[string]int{}}
+	p := &PooledBitswapSnifferCIDProvider{
+		cfg:                DefaultPooledBitswapSnifferConfig(),
+		pools:              map[string]*cidPool{},
+		queryCIDs:          sniffer.queryCIDs,
+		cidSelectCounter:   cidSelectCounter,
+		poolRefreshCounter: poolRefreshCounter,
+	}
+
+	return p, sniffer
+}
+
+func TestPooledBitswapSnifferCIDProvider_dedup(t *testing.T) {
+	p, sniffer := newTestPool(t)
+
+	raw := cid.MustParse(testRawCID)
+	cbor := cid.NewCidV1(uint64(multicodec.DagCbor), raw.Hash())
+	sniffer.set("bitswap", []string{testRawCID, cbor.String(), testCID2, "not-a-cid", testCID2}, nil)
+
+	cids, err := p.queryRecentCIDs(context.Background(), "bitswap")
+	require.NoError(t, err)
+
+	// the dag-cbor cid is normalized to the raw one
+	assert.Equal(t, []cid.Cid{raw, cid.MustParse(testCID2)}, cids)
+}
+
+func TestPooledBitswapSnifferCIDProvider_SelectCID_lazyOrigin(t *testing.T) {
+	ctx := context.Background()
+	p, sniffer := newTestPool(t)
+	sniffer.set("bitswap", []string{testRawCID}, nil)
+
+	assert.Empty(t, p.pools)
+
+	c, err := p.SelectCID(ctx, "bitswap")
+	require.NoError(t, err)
+	assert.Equal(t, testRawCID, c.String())
+	assert.Contains(t, p.pools, "bitswap")
+	assert.Equal(t, 1, sniffer.queries["bitswap"])
+
+	// further selections are served from the pool
+	_, err = p.SelectCID(ctx, "bitswap")
+	require.NoError(t, err)
+	assert.Equal(t, 1, sniffer.queries["bitswap"])
+	assert.Zero(t, sniffer.queries["dht"])
+}
+
+func TestPooledBitswapSnifferCIDProvider_SelectCID_emptyOrigin(t *testing.T) {
+	ctx := context.Background()
+	p, _ := newTestPool(t)
+
+	_, err := p.SelectCID(ctx, "dht")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// the origin is registered anyway, so that the refresh loop picks it up
+	assert.Contains(t, p.pools, "dht")
+}
+
+func TestPooledBitswapSnifferCIDProvider_refresh_keepsPool(t *testing.T) {
+	ctx := context.Background()
+	p, sniffer := newTestPool(t)
+	sniffer.set("bitswap", []string{testRawCID}, nil)
+
+	_, err := p.SelectCID(ctx, "bitswap")
+	require.NoError(t, err)
+	refreshedAt := p.pools["bitswap"].refreshedAt
+
+	tests := []struct {
+		name string
+		cids []string
+		err  error
+	}{
+		{name: "failed", err: errors.New("connection refused")},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sniffer.set("bitswap", tt.cids, tt.err)
+			p.refresh(ctx, "bitswap")
+
+			assert.Equal(t, refreshedAt, p.pools["bitswap"].refreshedAt)
+
+			c, err := p.SelectCID(ctx, "bitswap")
+			require.NoError(t, err)
+			assert.Equal(t, testRawCID, c.String())
+		})
+	}
+
+	sniffer.set("bitswap", []string{testCID2}, nil)
+	p.refresh(ctx, "bitswap")
+
+	c, err := p.SelectCID(ctx, "bitswap")
+	require.NoError(t, err)
+	assert.Equal(t, testCID2, c.String())
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ErrSlotServed is returned by ScheduledCIDProvider.Select if it serves every
+// slot once and the CID of the current slot was already selected.
+var ErrSlotServed = errors.New("cid of the current slot already selected")
+
+// ScheduledCIDProvider derives the CID for every wall-clock slot from a shared
+// seed and the slot index. All instances that are configured with the same
+// CID set, seed, and slot duration select the same CID in the same slot,
+// regardless of the region they run in and without any coordination.
+//
+// The slots are grouped into epochs of len(cids) slots. Within an epoch, the
+// CIDs are visited in a seeded random permutation, so every CID is selected
+// exactly once per epoch.
+type ScheduledCIDProvider struct {
+	cids []cid.Cid
+	seed uint64
+	slot time.Duration
+	now  func() time.Time
+
+	permMu    sync.Mutex
+	permEpoch int64
+	perm      []int
+
+	servedMu    sync.Mutex
+	oncePerSlot bool
+	servedSlot  int64
+}
+
+var _ SelectingCIDProvider = (*ScheduledCIDProvider)(nil)
+
+func NewScheduledCIDProvider(cids []cid.Cid, seed uint64, slot time.Duration) (*ScheduledCIDProvider, error) {
+	if len(cids) == 0 {
+		return nil, fmt.Errorf("no cids to schedule")
+	} else if slot <= 0 {
+		return nil, fmt.Errorf("slot duration must be positive, got %s", slot)
+	}
+
+	// sort the CIDs so that the schedule doesn't depend on the order in which
+	// the CIDs were configured.
+	sorted := slices.Clone(cids)
+	slices.SortFunc(sorted, func(a, b cid.Cid) int {
+		return strings.Compare(a.KeyString(), b.KeyString())
+	})
+	sorted = slices.CompactFunc(sorted, cid.Cid.Equals)
+
+	return &ScheduledCIDProvider{
+		cids:       sorted,
+		seed:       seed,
+		slot:       slot,
+		now:        time.Now,
+		permEpoch:  -1,
+		servedSlot: -1,
+	}, nil
+}
+
+// ServeOncePerSlot makes Select hand out the CID of every slot only once.
+// Further selections within the same slot fail with ErrSlotServed, so that a
+// caller that selects more often than once per slot doesn't probe the same
+// CID over and over.
+func (p *ScheduledCIDProvider) ServeOncePerSlot() {
+	p.servedMu.Lock()
+	defer p.servedMu.Unlock()
+	p.oncePerSlot = true
+}
+
+// Slot returns the index of the slot that contains t.
+func (p *ScheduledCIDProvider) Slot(t time.Time) int64 {
+	return t.UnixNano() / int64(p.slot)
+}
+
+// SlotStart returns the wall-clock time at which the given slot starts.
+func (p *ScheduledCIDProvider) SlotStart(slot int64) time.Time {
+	return time.Unix(0, slot*int64(p.slot)).UTC()
+}
+
+func (p *ScheduledCIDProvider) SelectCID(ctx context.Context, origin string) (cid.Cid, error) {
+	sel, err := p.Select(ctx, origin)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return sel.CID, nil
+}
+
+func (p *ScheduledCIDProvider) Select(ctx context.Context, origin string) (*SelectedCID, error) {
+	slot := p.Slot(p.now())
+
+	p.servedMu.Lock()
+	if p.oncePerSlot && p.servedSlot == slot {
+		p.servedMu.Unlock()
+		return nil, ErrSlotServed
+	}
+	p.servedSlot = slot
+	p.servedMu.Unlock()
+
+	return &SelectedCID{
+		CID: p.cidForSlot(slot),
+		Metadata: map[string]string{
+			"slot":       strconv.FormatInt(slot, 10),
+			"slot_start": p.SlotStart(slot).Format(time.RFC3339Nano),
+		},
+	}, nil
+}
+
+func (p *ScheduledCIDProvider) cidForSlot(slot int64) cid.Cid {
+	n := int64(len(p.cids))
+	epoch := slot / n
+
+	p.permMu.Lock()
+	defer p.permMu.Unlock()
+
+	if p.permEpoch != epoch {
+		p.perm = schedulePermutation(p.seed, epoch, len(p.cids))
+		p.permEpoch = epoch
+	}
+
+	return p.cids[p.perm[slot%n]]
+}
+
+// schedulePermutation returns a seeded Fisher-Yates permutation of [0, n). It
+// only relies on the raw PCG output, which is specified and stable, so that
+// instances running different Go versions still agree on the schedule.
+func schedulePermutation(seed uint64, epoch int64, n int) []int {
+	src := rand.NewPCG(seed, uint64(epoch))
+
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+
+	for i := n - 1; i > 0; i-- {
+		j := int(src.Uint64() % uint64(i+1))
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+
+	return perm
+}
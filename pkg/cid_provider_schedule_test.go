@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledCIDProvider(t *testing.T) {
+	cids := ControlledCIDs()[:64]
+
+	p1, err := NewScheduledCIDProvider(cids, 42, time.Minute)
+	require.NoError(t, err)
+
+	// same set in a different order, e.g., in another region
+	reversed := slices.Clone(cids)
+	slices.Reverse(reversed)
+	p2, err := NewScheduledCIDProvider(reversed, 42, time.Minute)
+	require.NoError(t, err)
+
+	p3, err := NewScheduledCIDProvider(cids, 43, time.Minute)
+	require.NoError(t, err)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	firstSlot := p1.Slot(start)
+
+	// every cid is scheduled exactly once per epoch
+	epochStart := firstSlot - firstSlot%int64(len(cids))
+	seen := map[cid.Cid]struct{}{}
+	differs := false
+	for slot := epochStart; slot < epochStart+int64(len(cids)); slot++ {
+		c := p1.cidForSlot(slot)
+		assert.Equal(t, c, p2.cidForSlot(slot))
+		if !c.Equals(p3.cidForSlot(slot)) {
+			differs = true
+		}
+		seen[c] = struct{}{}
+	}
+	assert.Len(t, seen, len(cids))
+	assert.True(t, differs, "different seeds should produce different schedules")
+
+	// the selection only changes at slot boundaries
+	p1.now = func() time.Time { return p1.SlotStart(firstSlot).Add(59 * time.Second) }
+	sel1, err := p1.Select(context.Background(), "")
+	require.NoError(t, err)
+
+	p2.now = func() time.Time { return p2.SlotStart(firstSlot) }
+	sel2, err := p2.Select(context.Background(), "")
+	require.NoError(t, err)
+
+	assert.Equal(t, sel1.CID, sel2.CID)
+	assert.Equal(t, sel1.Metadata["slot"], sel2.Metadata["slot"])
+}
+
+func TestScheduledCIDProvider_ServeOncePerSlot(t *testing.T) {
+	ctx := context.Background()
+
+	p, err := NewScheduledCIDProvider(ControlledCIDs()[:8], 42, time.Minute)
+	require.NoError(t, err)
+	p.ServeOncePerSlot()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	_, err = p.Select(ctx, "")
+	require.NoError(t, err)
+
+	now = now.Add(59 * time.Second)
+	_, err = p.Select(ctx, "")
+	assert.ErrorIs(t, err, ErrSlotServed)
+
+	now = now.Add(time.Second)
+	_, err = p.Select(ctx, "")
+	assert.NoError(t, err)
+}
+
+func TestNewScheduledCIDProvider_invalid(t *testing.T) {
+	_, err := NewScheduledCIDProvider(nil, 0, time.Minute)
+	assert.Error(t, err)
+
+	_, err = NewScheduledCIDProvider(ControlledCIDs()[:1], 0, 0)
+	assert.Error(t, err)
+}
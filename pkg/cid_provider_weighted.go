@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// SelectedCID is a CID together with the label of the source it was drawn
+// from and any additional metadata the source knows about it. Sources that
+// serve content paths instead of bare CIDs set Path. For /ipns paths, the CID
+// is undefined.
+type SelectedCID struct {
+	CID      cid.Cid
+	Path     ContentPath
+	Source   string
+	Metadata map[string]string
+}
+
+// ContentPath returns the path to request for the selection. It falls back to
+// /ipfs/<cid> if the source didn't set a path.
+func (s *SelectedCID) ContentPath() ContentPath {
+	if !s.Path.IsZero() {
+		return s.Path
+	}
+	return CIDPath(s.CID)
+}
+
+// SelectingCIDProvider is implemented by CID providers that can report more
+// than just the bare CID. The WeightedCIDProvider prefers this method over
+// SelectCID if a sub-provider implements it.
+type SelectingCIDProvider interface {
+	CIDProvider
+	Select(ctx context.Context, origin string) (*SelectedCID, error)
+}
+
+// WeightedCIDSource is a single sub-provider of a WeightedCIDProvider.
+type WeightedCIDSource struct {
+	// Name is the label that is reported as the source of a selected CID
+	// (e.g., "controlled", "static", "bitsniffer").
+	Name string
+
+	// PerOrigin appends the requested origin to the source label. The
+	// bitswap sniffer provider, for example, reports "bitsniffer_bitswap"
+	// and "bitsniffer_dht" depending on the origin.
+	PerOrigin bool
+
+	// Weight is the relative share of selections that should be served by
+	// this source. Weights don't need to sum up to one.
+	Weight float64
+
+	Provider CIDProvider
+}
+
+// Label returns the source label for the given origin.
+func (s *WeightedCIDSource) Label(origin string) string {
+	if s.PerOrigin && origin != "" {
+		return s.Name + "_" + origin
+	}
+	return s.Name
+}
+
+// WeightedCIDProvider selects CIDs from a set of sub-providers. For every
+// selection, it picks a sub-provider at random with a probability that is
+// proportional to the sub-provider's weight.
+type WeightedCIDProvider struct {
+	sources     []*WeightedCIDSource
+	totalWeight float64
+}
+
+var _ SelectingCIDProvider = (*WeightedCIDProvider)(nil)
+
+func NewWeightedCIDProvider(sources ...*WeightedCIDSource) (*WeightedCIDProvider, error) {
+	p := &WeightedCIDProvider{}
+	seen := map[string]struct{}{}
+	for _, src := range sources {
+		if src.Provider == nil {
+			return nil, fmt.Errorf("cid source %q has no provider", src.Name)
+		} else if src.Weight < 0 {
+			return nil, fmt.Errorf("cid source %q has negative weight %f", src.Name, src.Weight)
+		} else if src.Weight == 0 {
+			continue
+		}
+
+		if _, found := seen[src.Name]; found {
+			return nil, fmt.Errorf("duplicate cid source %q", src.Name)
+		}
+		seen[src.Name] = struct{}{}
+
+		p.sources = append(p.sources, src)
+		p.totalWeight += src.Weight
+	}
+
+	if len(p.sources) == 0 {
+		return nil, fmt.Errorf("no cid source with a positive weight")
+	}
+
+	return p, nil
+}
+
+// Sources returns the sub-providers with a positive weight.
+func (p *WeightedCIDProvider) Sources() []*WeightedCIDSource {
+	return p.sources
+}
+
+// String returns a human-readable representation of the configured sources
+// and their normalized shares, e.g., "controlled=0.20,bitsniffer=0.80".
+func (p *WeightedCIDProvider) String() string {
+	parts := make([]string, 0, len(p.sources))
+	for _, src := range p.sources {
+		parts = append(parts, fmt.Sprintf("%s=%.2f", src.Name, src.Weight/p.totalWeight))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *WeightedCIDProvider) SelectCID(ctx context.Context, origin string) (cid.Cid, error) {
+	sel, err := p.Select(ctx, origin)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return sel.CID, nil
+}
+
+func (p *WeightedCIDProvider) Select(ctx context.Context, origin string) (*SelectedCID, error) {
+	src := p.pick(rand.Float64())
+
+	var (
+		sel *SelectedCID
+		err error
+	)
+
+	if sp, ok := src.Provider.(SelectingCIDProvider); ok {
+		sel, err = sp.Select(ctx, origin)
+	} else {
+		var c cid.Cid
+		c, err = src.Provider.SelectCID(ctx, origin)
+		sel = &SelectedCID{CID: c}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("select cid from %s source: %w", src.Name, err)
+	}
+
+	// nested weighted providers already report a more specific label
+	if sel.Source == "" {
+		sel.Source = src.Label(origin)
+	}
+
+	return sel, nil
+}
+
+// pick returns the source that corresponds to the given number in [0, 1).
+func (p *WeightedCIDProvider) pick(f float64) *WeightedCIDSource {
+	target := f * p.totalWeight
+	for _, src := range p.sources {
+		if target < src.Weight {
+			return src
+		}
+		target -= src.Weight
+	}
+
+	// floating point rounding
+	return p.sources[len(p.sources)-1]
+}
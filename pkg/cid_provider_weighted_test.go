@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCIDProvider struct {
+	c   cid.Cid
+	err error
+}
+
+func (p *fakeCIDProvider) SelectCID(ctx context.Context, origin string) (cid.Cid, error) {
+	return p.c, p.err
+}
+
+func TestWeightedCIDProvider_pick(t *testing.T) {
+	a := &WeightedCIDSource{Name: "a", Weight: 1, Provider: &NoopCIDProvider{}}
+	b := &WeightedCIDSource{Name: "b", Weight: 3, Provider: &NoopCIDProvider{}}
+	zero := &WeightedCIDSource{Name: "zero", Weight: 0, Provider: &NoopCIDProvider{}}
+
+	p, err := NewWeightedCIDProvider(a, zero, b)
+	require.NoError(t, err)
+	require.Len(t, p.Sources(), 2)
+
+	assert.Equal(t, a, p.pick(0))
+	assert.Equal(t, a, p.pick(0.24))
+	assert.Equal(t, b, p.pick(0.25))
+	assert.Equal(t, b, p.pick(0.999999))
+	assert.Equal(t, "a=0.25,b=0.75", p.String())
+}
+
+func TestWeightedCIDProvider_Select(t *testing.T) {
+	c := cid.MustParse("QmUvSqPqYsjeab2JgsNc4PjbAGnCzfn5xid6piJgYYzehH")
+
+	p, err := NewWeightedCIDProvider(&WeightedCIDSource{
+		Name:      "bitsniffer",
+		PerOrigin: true,
+		Weight:    1,
+		Provider:  &fakeCIDProvider{c: c},
+	})
+	require.NoError(t, err)
+
+	sel, err := p.Select(context.Background(), "dht")
+	require.NoError(t, err)
+	assert.Equal(t, c, sel.CID)
+	assert.Equal(t, "bitsniffer_dht", sel.Source)
+
+	// nested providers keep the label of the innermost source
+	outer, err := NewWeightedCIDProvider(&WeightedCIDSource{Name: "outer", Weight: 1, Provider: p})
+	require.NoError(t, err)
+
+	sel, err = outer.Select(context.Background(), "bitswap")
+	require.NoError(t, err)
+	assert.Equal(t, "bitsniffer_bitswap", sel.Source)
+}
+
+func TestWeightedCIDProvider_Select_error(t *testing.T) {
+	errFake := errors.New("fake")
+	p, err := NewWeightedCIDProvider(&WeightedCIDSource{Name: "static", Weight: 1, Provider: &fakeCIDProvider{err: errFake}})
+	require.NoError(t, err)
+
+	_, err = p.Select(context.Background(), "bitswap")
+	assert.ErrorIs(t, err, errFake)
+}
+
+func TestNewWeightedCIDProvider_invalid(t *testing.T) {
+	_, err := NewWeightedCIDProvider()
+	assert.Error(t, err)
+
+	_, err = NewWeightedCIDProvider(&WeightedCIDSource{Name: "a", Weight: -1, Provider: &NoopCIDProvider{}})
+	assert.Error(t, err)
+
+	_, err = NewWeightedCIDProvider(&WeightedCIDSource{Name: "a", Weight: 1})
+	assert.Error(t, err)
+
+	_, err = NewWeightedCIDProvider(
+		&WeightedCIDSource{Name: "a", Weight: 1, Provider: &NoopCIDProvider{}},
+		&WeightedCIDSource{Name: "a", Weight: 1, Provider: &NoopCIDProvider{}},
+	)
+	assert.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ResolutionType describes what a gateway has to resolve before it can serve
+// a content path.
+type ResolutionType string
+
+const (
+	// ResolutionTypeNone is a bare /ipfs/<cid> path.
+	ResolutionTypeNone ResolutionType = "none"
+	// ResolutionTypePath is an /ipfs/<cid>/sub/path that requires traversing
+	// the DAG below the CID.
+	ResolutionTypePath ResolutionType = "path"
+	// ResolutionTypeIPNS is an /ipns/<key> path that requires resolving an
+	// IPNS record.
+	ResolutionTypeIPNS ResolutionType = "ipns"
+	// ResolutionTypeDNSLink is an /ipns/<domain> path that requires resolving
+	// a DNSLink TXT record.
+	ResolutionTypeDNSLink ResolutionType = "dnslink"
+)
+
+// ContentPath is a path that can be requested from a gateway:
+// /ipfs/<cid>[/sub/path], /ipns/<key>[/sub/path], or /ipns/<domain>[/sub/path].
+type ContentPath struct {
+	Namespace string // "ipfs" or "ipns"
+	Root      string // a CID for /ipfs, an IPNS key or DNSLink domain for /ipns
+	SubPath   string // the path below the root (e.g., "/wiki/index.html")
+}
+
+// CIDPath returns the /ipfs/<cid> content path of the given CID.
+func CIDPath(c cid.Cid) ContentPath {
+	return ContentPath{Namespace: "ipfs", Root: c.String()}
+}
+
+// ParseContentPath parses an /ipfs/ or /ipns/ content path. A bare CID is
+// interpreted as /ipfs/<cid>.
+func ParseContentPath(s string) (ContentPath, error) {
+	s = strings.TrimSpace(s)
+
+	if !strings.HasPrefix(s, "/") {
+		c, err := cid.Decode(s)
+		if err != nil {
+			return ContentPath{}, fmt.Errorf("parsing cid: %w", err)
+		}
+		return CIDPath(c), nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(s, "/"), "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return ContentPath{}, fmt.Errorf("invalid content path %q", s)
+	}
+
+	p := ContentPath{Namespace: parts[0], Root: parts[1]}
+	if len(parts) == 3 {
+		p.SubPath = normalizeCIDEntryPath(parts[2])
+	}
+
+	switch p.Namespace {
+	case "ipfs":
+		c, err := cid.Decode(p.Root)
+		if err != nil {
+			return ContentPath{}, fmt.Errorf("parsing cid of %q: %w", s, err)
+		}
+		p.Root = c.String()
+	case "ipns":
+		if !isIPNSKey(p.Root) && !strings.Contains(p.Root, ".") {
+			return ContentPath{}, fmt.Errorf("%q is neither an ipns key nor a dnslink domain", p.Root)
+		}
+	default:
+		return ContentPath{}, fmt.Errorf("unsupported namespace %q in content path %q", p.Namespace, s)
+	}
+
+	return p, nil
+}
+
+// String returns the path as it is appended to a gateway URL.
+func (p ContentPath) String() string {
+	return "/" + p.Namespace + "/" + p.Root + p.SubPath
+}
+
+// IsZero returns true if the path is unset.
+func (p ContentPath) IsZero() bool {
+	return p.Root == ""
+}
+
+// CID returns the root CID of an /ipfs path. It's undefined for /ipns paths.
+func (p ContentPath) CID() cid.Cid {
+	if p.Namespace != "ipfs" {
+		return cid.Undef
+	}
+
+	c, err := cid.Decode(p.Root)
+	if err != nil {
+		return cid.Undef
+	}
+
+	return c
+}
+
+// ResolutionType returns what a gateway has to resolve to serve the path.
+// For /ipns paths, the name resolution takes precedence over the traversal of
+// a sub path.
+func (p ContentPath) ResolutionType() ResolutionType {
+	switch {
+	case p.Namespace == "ipns" && isIPNSKey(p.Root):
+		return ResolutionTypeIPNS
+	case p.Namespace == "ipns":
+		return ResolutionTypeDNSLink
+	case p.SubPath != "":
+		return ResolutionTypePath
+	default:
+		return ResolutionTypeNone
+	}
+}
+
+func isIPNSKey(s string) bool {
+	if _, err := peer.Decode(s); err == nil {
+		return true
+	}
+
+	c, err := cid.Decode(s)
+	return err == nil && c.Type() == cid.Libp2pKey
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentPath(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     string
+		wantType ResolutionType
+		wantCID  bool
+		wantErr  bool
+	}{
+		{in: testCID1, want: "/ipfs/" + testCID1, wantType: ResolutionTypeNone, wantCID: true},
+		{in: "/ipfs/" + testCID2, want: "/ipfs/" + testCID2, wantType: ResolutionTypeNone, wantCID: true},
+		{in: "/ipfs/" + testCID2 + "/wiki/index.html", want: "/ipfs/" + testCID2 + "/wiki/index.html", wantType: ResolutionTypePath, wantCID: true},
+		{in: "/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8", want: "/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8", wantType: ResolutionTypeIPNS},
+		{in: "/ipns/12D3KooWGzxzKZYveHXtpG6AsrUJBcWxHBFS2HsEoGTxrMLvKXtf/", want: "/ipns/12D3KooWGzxzKZYveHXtpG6AsrUJBcWxHBFS2HsEoGTxrMLvKXtf", wantType: ResolutionTypeIPNS},
+		{in: "/ipns/en.wikipedia-on-ipfs.org/wiki", want: "/ipns/en.wikipedia-on-ipfs.org/wiki", wantType: ResolutionTypeDNSLink},
+		{in: "/ipfs/not-a-cid", wantErr: true},
+		{in: "/ipns/localhost", wantErr: true},
+		{in: "/foo/" + testCID1, wantErr: true},
+		{in: "/ipfs/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			p, err := ParseContentPath(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, p.String())
+			assert.Equal(t, tt.wantType, p.ResolutionType())
+			assert.Equal(t, tt.wantCID, p.CID().Defined())
+		})
+	}
+}
+
+func TestParseResolvedRoot(t *testing.T) {
+	assert.Nil(t, ParseResolvedRoot(""))
+	assert.Equal(t, testCID1, *ParseResolvedRoot(testCID1 + "," + testCID2))
+}
@@ -0,0 +1,293 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"sync"
+
+	chunker "github.com/ipfs/boxo/chunker"
+	"github.com/ipfs/boxo/files"
+	mdtest "github.com/ipfs/boxo/ipld/merkledag/test"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/balanced"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/helpers"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+)
+
+//go:embed controlled/*.txt
+var controlledFS embed.FS
+
+// ControlledShape describes the DAG layout of the content in a controlled
+// class.
+type ControlledShape string
+
+const (
+	// ControlledShapeRaw is a single raw block.
+	ControlledShapeRaw ControlledShape = "raw"
+	// ControlledShapeFile is a UnixFS file that's chunked into 256 KiB blocks.
+	ControlledShapeFile ControlledShape = "file"
+	// ControlledShapeDir is a UnixFS directory of ControlledDirEntries files.
+	ControlledShapeDir ControlledShape = "dir"
+)
+
+const (
+	// ControlledChunkSize is the chunk size with which controlled files are
+	// imported.
+	ControlledChunkSize = 256 << 10
+
+	// ControlledDirEntries is the number of files in a controlled directory.
+	// The content of the class is split evenly across the files.
+	ControlledDirEntries = 16
+)
+
+// ControlledClass is a set of controlled CIDs whose content has the same size
+// and DAG shape. The CIDs of a class are embedded in controlled/<name>.txt.
+type ControlledClass struct {
+	Name  string
+	Size  int
+	Shape ControlledShape
+	Share float64 // the default share of the class in the controlled mix
+	Count int     // the number of CIDs that `tiros controlled generate` creates
+
+	// CIDVersion is the CID version the content is imported with. Version 1
+	// implies raw leaves.
+	CIDVersion int
+}
+
+// ControlledClasses are all known controlled classes. The 1 KiB class is the
+// original set of controlled CIDs.
+var ControlledClasses = []ControlledClass{
+	{Name: "1KiB-file", Size: 1 << 10, Shape: ControlledShapeFile, Share: 0.55, Count: 4096, CIDVersion: 0},
+	{Name: "256KiB-raw", Size: 256 << 10, Shape: ControlledShapeRaw, Share: 0.2, Count: 256, CIDVersion: 1},
+	{Name: "4MiB-file", Size: 4 << 20, Shape: ControlledShapeFile, Share: 0.15, Count: 64, CIDVersion: 1},
+	{Name: "4MiB-dir", Size: 4 << 20, Shape: ControlledShapeDir, Share: 0.05, Count: 32, CIDVersion: 1},
+	{Name: "32MiB-file", Size: 32 << 20, Shape: ControlledShapeFile, Share: 0.05, Count: 16, CIDVersion: 1},
+}
+
+// ControlledClassByName returns the controlled class with the given name.
+func ControlledClassByName(name string) (ControlledClass, bool) {
+	for _, class := range ControlledClasses {
+		if class.Name == name {
+			return class, true
+		}
+	}
+	return ControlledClass{}, false
+}
+
+// CIDs returns the embedded CIDs of the class in file order.
+func (c ControlledClass) CIDs() []cid.Cid {
+	data, err := controlledFS.ReadFile(path.Join("controlled", c.Name+".txt"))
+	if err != nil {
+		return nil
+	}
+
+	var cids []cid.Cid
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parsed, err := cid.Parse(line)
+		if err != nil {
+			slog.Warn("failed to parse CID from controlled CIDs file", "class", c.Name, "err", err)
+			continue
+		}
+		cids = append(cids, parsed)
+	}
+
+	return cids
+}
+
+// ControlledCIDs returns the embedded CIDs of all controlled classes.
+func ControlledCIDs() []cid.Cid {
+	var cids []cid.Cid
+	for _, class := range ControlledClasses {
+		cids = append(cids, class.CIDs()...)
+	}
+	return cids
+}
+
+var controlledSizeClasses = sync.OnceValue(func() map[string]string {
+	classes := map[string]string{}
+	for _, class := range ControlledClasses {
+		for _, c := range class.CIDs() {
+			classes[string(c.Hash())] = class.Name
+		}
+	}
+	return classes
+})
+
+// ControlledSizeClass returns the name of the controlled class the given CID
+// belongs to or an empty string if it's not a controlled CID.
+func ControlledSizeClass(c cid.Cid) string {
+	if !c.Defined() {
+		return ""
+	}
+	return controlledSizeClasses()[string(c.Hash())]
+}
+
+// Chunker returns the chunker spec the content of the class is imported with.
+// Raw blocks are imported as a single chunk.
+func (c ControlledClass) Chunker() string {
+	if c.Shape == ControlledShapeRaw {
+		return fmt.Sprintf("size-%d", c.Size)
+	}
+	return fmt.Sprintf("size-%d", ControlledChunkSize)
+}
+
+// Node returns the deterministic content with the given index as a file or
+// directory that can be added to an IPFS node.
+func (c ControlledClass) Node(seed uint64, idx int) files.Node {
+	data := ControlledBlob(seed, c.Name, idx, c.Size)
+	if c.Shape != ControlledShapeDir {
+		return files.NewBytesFile(data)
+	}
+
+	entries := make([]files.DirEntry, 0, ControlledDirEntries)
+	for i, part := range controlledDirParts(data) {
+		entries = append(entries, files.FileEntry(fmt.Sprintf("%02d", i), files.NewBytesFile(part)))
+	}
+
+	return files.NewSliceDirectory(entries)
+}
+
+// Root derives the root CID of the content with the given index locally. It
+// imports the content with the same settings that `tiros controlled generate`
+// passes to Kubo.
+func (c ControlledClass) Root(ctx context.Context, seed uint64, idx int) (cid.Cid, error) {
+	if c.Shape == ControlledShapeRaw && c.Size > helpers.BlockSizeLimit {
+		return cid.Undef, fmt.Errorf("raw class %s exceeds the block size limit", c.Name)
+	}
+
+	dserv := mdtest.Mock()
+	data := ControlledBlob(seed, c.Name, idx, c.Size)
+
+	if c.Shape != ControlledShapeDir {
+		nd, err := c.importFile(dserv, data)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return nd.Cid(), nil
+	}
+
+	dir, err := uio.NewBasicDirectory(dserv, uio.WithCidBuilder(c.cidBuilder()))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("creating directory: %w", err)
+	}
+
+	for i, part := range controlledDirParts(data) {
+		nd, err := c.importFile(dserv, part)
+		if err != nil {
+			return cid.Undef, err
+		}
+
+		if err := dir.AddChild(ctx, fmt.Sprintf("%02d", i), nd); err != nil {
+			return cid.Undef, fmt.Errorf("adding directory entry: %w", err)
+		}
+	}
+
+	nd, err := dir.GetNode()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("building directory node: %w", err)
+	}
+
+	return nd.Cid(), nil
+}
+
+func (c ControlledClass) cidBuilder() cid.Builder {
+	if c.CIDVersion == 0 {
+		return cid.V0Builder{}
+	}
+	return cid.V1Builder{Codec: cid.DagProtobuf, MhType: mh.SHA2_256}
+}
+
+func (c ControlledClass) importFile(dserv ipld.DAGService, data []byte) (ipld.Node, error) {
+	chunkSize := int64(ControlledChunkSize)
+	if c.Shape == ControlledShapeRaw {
+		chunkSize = int64(c.Size)
+	}
+
+	params := helpers.DagBuilderParams{
+		Dagserv:    dserv,
+		Maxlinks:   helpers.DefaultLinksPerBlock,
+		RawLeaves:  c.CIDVersion > 0,
+		CidBuilder: c.cidBuilder(),
+	}
+
+	db, err := params.New(chunker.NewSizeSplitter(bytes.NewReader(data), chunkSize))
+	if err != nil {
+		return nil, fmt.Errorf("creating dag builder: %w", err)
+	}
+
+	nd, err := balanced.Layout(db)
+	if err != nil {
+		return nil, fmt.Errorf("importing file: %w", err)
+	}
+
+	return nd, nil
+}
+
+func controlledDirParts(data []byte) [][]byte {
+	partSize := len(data) / ControlledDirEntries
+
+	parts := make([][]byte, 0, ControlledDirEntries)
+	for i := range ControlledDirEntries {
+		end := (i + 1) * partSize
+		if i == ControlledDirEntries-1 {
+			end = len(data)
+		}
+		parts = append(parts, data[i*partSize:end])
+	}
+
+	return parts
+}
+
+// ControlledBlob deterministically derives the content of the controlled blob
+// of the given class and index from the seed. The same arguments always
+// produce the same bytes, so the controlled CID set can be regenerated and
+// re-provided from any node.
+func ControlledBlob(seed uint64, class string, idx int, size int) []byte {
+	h := sha256.New()
+	h.Write([]byte("tiros-controlled"))
+	h.Write([]byte(class))
+	_ = binary.Write(h, binary.BigEndian, seed)
+	_ = binary.Write(h, binary.BigEndian, uint64(idx))
+
+	var key [32]byte
+	copy(key[:], h.Sum(nil))
+
+	data := make([]byte, size)
+	_, _ = rand.NewChaCha8(key).Read(data) // never returns an error
+
+	return data
+}
+
+// WriteControlledCIDs writes the given CIDs in the format of the embedded
+// controlled CID files. The comment lines are prefixed with "#".
+func WriteControlledCIDs(w io.Writer, comment string, cids []cid.Cid) error {
+	for _, line := range strings.Split(strings.TrimSpace(comment), "\n") {
+		if _, err := fmt.Fprintln(w, "# "+line); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range cids {
+		if _, err := fmt.Fprintln(w, c.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlledBlob(t *testing.T) {
+	b1 := ControlledBlob(1, "a", 0, 1024)
+	assert.Len(t, b1, 1024)
+	assert.Equal(t, b1, ControlledBlob(1, "a", 0, 1024))
+	assert.NotEqual(t, b1, ControlledBlob(1, "a", 1, 1024))
+	assert.NotEqual(t, b1, ControlledBlob(2, "a", 0, 1024))
+	assert.NotEqual(t, b1, ControlledBlob(1, "b", 0, 1024))
+}
+
+func TestControlledClass_Root(t *testing.T) {
+	ctx := context.Background()
+
+	for _, class := range ControlledClasses {
+		t.Run(class.Name, func(t *testing.T) {
+			cids := class.CIDs()
+			require.NotEmpty(t, cids)
+
+			for _, c := range cids {
+				assert.Equal(t, class.Name, ControlledSizeClass(c))
+			}
+
+			// the original 1 KiB CIDs weren't derived from a seed
+			if class.Name == "1KiB-file" {
+				return
+			}
+
+			root, err := class.Root(ctx, 0, 0)
+			require.NoError(t, err)
+			assert.Equal(t, cids[0], root)
+
+			switch class.Shape {
+			case ControlledShapeRaw:
+				assert.EqualValues(t, cid.Raw, root.Prefix().Codec)
+			default:
+				assert.EqualValues(t, cid.DagProtobuf, root.Prefix().Codec)
+			}
+		})
+	}
+
+	assert.Empty(t, ControlledSizeClass(cid.Undef))
+}
+
+func TestNewControlledCIDProvider(t *testing.T) {
+	ctx := context.Background()
+
+	p, err := NewControlledCIDProvider(map[string]float64{"4MiB-file": 1})
+	require.NoError(t, err)
+
+	for range 10 {
+		c, err := p.SelectCID(ctx, "")
+		require.NoError(t, err)
+		assert.Equal(t, "4MiB-file", ControlledSizeClass(c))
+	}
+
+	_, err = NewControlledCIDProvider(map[string]float64{"unknown": 1})
+	assert.Error(t, err)
+
+	_, err = NewControlledCIDProvider(map[string]float64{"4MiB-file": 0})
+	assert.Error(t, err)
+}
+
+func TestWriteControlledCIDs(t *testing.T) {
+	cids := []cid.Cid{cid.MustParse(testCID1), cid.MustParse(testCID2)}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteControlledCIDs(&buf, "first line\nsecond line", cids))
+
+	assert.Equal(t, "# first line\n# second line\n"+testCID1+"\n"+testCID2+"\n", buf.String())
+
+	entries, err := ParseCIDEntries("controlledcids", buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, cids[0], entries[0].CID)
+}
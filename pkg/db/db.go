@@ -23,12 +23,13 @@ var migrations embed.FS
 type Client interface {
 	io.Closer
 	Websites(ctx context.Context) ([]string, error)
-	Gateways(ctx context.Context) ([]string, error)
+	Gateways(ctx context.Context) ([]*GatewayModel, error)
 	InsertUpload(ctx context.Context, upload *UploadModel) error
 	InsertDownload(ctx context.Context, download *DownloadModel) error
 	InsertWebsiteProbe(ctx context.Context, websiteProbe *WebsiteProbeModel) error
 	InsertProvider(ctx context.Context, provider *ProviderModel) error
 	InsertGatewayProbe(ctx context.Context, gatewayProbe *GatewayProbeModel) error
+	InsertGatewayCertificate(ctx context.Context, gatewayCertificate *GatewayCertificateModel) error
 	InsertServiceWorkerProbe(ctx context.Context, serviceWorkerProbe *ServiceWorkerProbeModel) error
 }
 
@@ -41,6 +42,7 @@ type ClickhouseClient struct {
 	biWebsiteProbes *pldb.BatchInserter[WebsiteProbeModel]
 	biProviders     *pldb.BatchInserter[ProviderModel]
 	biGatewayProbes *pldb.BatchInserter[GatewayProbeModel]
+	biGatewayCerts  *pldb.BatchInserter[GatewayCertificateModel]
 	biSWProbes      *pldb.BatchInserter[ServiceWorkerProbeModel]
 }
 
@@ -87,6 +89,11 @@ func NewClickhouseClient(
 		return nil, fmt.Errorf("creating gateway_probes batch inserter: %w", err)
 	}
 
+	biGatewayCerts, err := newBatchInserter[GatewayCertificateModel](conn, "gateway_certificates")
+	if err != nil {
+		return nil, fmt.Errorf("creating gateway_certificates batch inserter: %w", err)
+	}
+
 	biSWProbes, err := newBatchInserter[ServiceWorkerProbeModel](conn, "service_worker_probes")
 	if err != nil {
 		return nil, fmt.Errorf("creating service_worker_probes batch inserter: %w", err)
@@ -98,6 +105,7 @@ func NewClickhouseClient(
 	biGroup.Add(biWebsiteProbes)
 	biGroup.Add(biProviders)
 	biGroup.Add(biGatewayProbes)
+	biGroup.Add(biGatewayCerts)
 	biGroup.Add(biSWProbes)
 	biGroup.Start(context.Background())
 
@@ -110,6 +118,7 @@ func NewClickhouseClient(
 		biWebsiteProbes: biWebsiteProbes,
 		biProviders:     biProviders,
 		biGatewayProbes: biGatewayProbes,
+		biGatewayCerts:  biGatewayCerts,
 		biSWProbes:      biSWProbes,
 	}
 
@@ -164,17 +173,29 @@ func (c *ClickhouseClient) Websites(ctx context.Context) ([]string, error) {
 	return websites, nil
 }
 
-func (c *ClickhouseClient) Gateways(ctx context.Context) ([]string, error) {
-	rows, err := c.Conn.Query(ctx, `SELECT domain FROM gateways WHERE deactivated_at IS NULL`)
+func (c *ClickhouseClient) Gateways(ctx context.Context) ([]*GatewayModel, error) {
+	rows, err := c.Conn.Query(ctx, `
+		SELECT domain, url_style, formats, auth_scheme, headers, weight, tags, capabilities
+		FROM gateways
+		WHERE deactivated_at IS NULL`)
 	if err != nil {
 		return nil, err
 	}
 	defer pllog.Defer(rows.Close, "Failed closing rows")
 
-	var gateways []string
+	var gateways []*GatewayModel
 	for rows.Next() {
-		var gateway string
-		if err := rows.Scan(&gateway); err != nil {
+		gateway := &GatewayModel{}
+		if err := rows.Scan(
+			&gateway.Domain,
+			&gateway.URLStyle,
+			&gateway.Formats,
+			&gateway.AuthScheme,
+			&gateway.Headers,
+			&gateway.Weight,
+			&gateway.Tags,
+			&gateway.Capabilities,
+		); err != nil {
 			return nil, err
 		}
 		gateways = append(gateways, gateway)
@@ -203,6 +224,10 @@ func (c *ClickhouseClient) InsertGatewayProbe(ctx context.Context, gatewayProbe
 	return c.biGatewayProbes.Submit(ctx, *gatewayProbe)
 }
 
+func (c *ClickhouseClient) InsertGatewayCertificate(ctx context.Context, gatewayCertificate *GatewayCertificateModel) error {
+	return c.biGatewayCerts.Submit(ctx, *gatewayCertificate)
+}
+
 func (c *ClickhouseClient) InsertServiceWorkerProbe(ctx context.Context, serviceWorkerProbe *ServiceWorkerProbeModel) error {
 	return c.biSWProbes.Submit(ctx, *serviceWorkerProbe)
 }
@@ -224,8 +249,8 @@ func (c *NoopClient) Websites(ctx context.Context) ([]string, error) {
 	return []string{"protocol.ai"}, nil
 }
 
-func (c *NoopClient) Gateways(ctx context.Context) ([]string, error) {
-	return []string{"ipfs.io"}, nil
+func (c *NoopClient) Gateways(ctx context.Context) ([]*GatewayModel, error) {
+	return []*GatewayModel{{Domain: "ipfs.io", Weight: 1}}, nil
 }
 
 func (c *NoopClient) InsertUpload(ctx context.Context, upload *UploadModel) error {
@@ -248,6 +273,10 @@ func (c *NoopClient) InsertGatewayProbe(ctx context.Context, gatewayProbe *Gatew
 	return nil
 }
 
+func (c *NoopClient) InsertGatewayCertificate(ctx context.Context, gatewayCertificate *GatewayCertificateModel) error {
+	return nil
+}
+
 func (c *NoopClient) InsertServiceWorkerProbe(ctx context.Context, serviceWorkerProbe *ServiceWorkerProbeModel) error {
 	return nil
 }
@@ -264,7 +293,7 @@ func (c *LogClient) Websites(ctx context.Context) ([]string, error) {
 	panic("not implemented")
 }
 
-func (c *LogClient) Gateways(ctx context.Context) ([]string, error) {
+func (c *LogClient) Gateways(ctx context.Context) ([]*GatewayModel, error) {
 	panic("not implemented")
 }
 
@@ -288,6 +317,10 @@ func (c *LogClient) InsertGatewayProbe(ctx context.Context, gatewayProbe *Gatewa
 	panic("implement me")
 }
 
+func (c *LogClient) InsertGatewayCertificate(ctx context.Context, gatewayCertificate *GatewayCertificateModel) error {
+	panic("implement me")
+}
+
 func (c *LogClient) InsertServiceWorkerProbe(ctx context.Context, serviceWorkerProbe *ServiceWorkerProbeModel) error {
 	panic("implement me")
 }
@@ -298,6 +331,7 @@ type JSONClient struct {
 	websiteProbesFile       *os.File
 	providersFile           *os.File
 	gatewayProbesFile       *os.File
+	gatewayCertsFile        *os.File
 	serviceWorkerProbesFile *os.File
 }
 
@@ -334,6 +368,11 @@ func NewJSONClient(dir string) (*JSONClient, error) {
 		return nil, err
 	}
 
+	gatewayCertsFile, err := os.Create(path.Join(dir, "gateway_certificates.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+
 	serviceWorkerProbesFile, err := os.Create(path.Join(dir, "service_worker_probes.ndjson"))
 	if err != nil {
 		return nil, err
@@ -346,6 +385,7 @@ func NewJSONClient(dir string) (*JSONClient, error) {
 		websiteProbesFile:       websiteProbesFile,
 		providersFile:           providersFile,
 		gatewayProbesFile:       gatewayProbesFile,
+		gatewayCertsFile:        gatewayCertsFile,
 		serviceWorkerProbesFile: serviceWorkerProbesFile,
 	}, nil
 }
@@ -357,6 +397,7 @@ func (c *JSONClient) Close() error {
 	errg.Go(c.websiteProbesFile.Close)
 	errg.Go(c.providersFile.Close)
 	errg.Go(c.gatewayProbesFile.Close)
+	errg.Go(c.gatewayCertsFile.Close)
 	errg.Go(c.serviceWorkerProbesFile.Close)
 	return errg.Wait()
 }
@@ -365,8 +406,8 @@ func (c *JSONClient) Websites(ctx context.Context) ([]string, error) {
 	return []string{"protocol.ai"}, nil
 }
 
-func (c *JSONClient) Gateways(ctx context.Context) ([]string, error) {
-	return []string{"ipfs.io"}, nil
+func (c *JSONClient) Gateways(ctx context.Context) ([]*GatewayModel, error) {
+	return []*GatewayModel{{Domain: "ipfs.io", Weight: 1}}, nil
 }
 
 func (c *JSONClient) InsertUpload(ctx context.Context, upload *UploadModel) error {
@@ -394,6 +435,11 @@ func (c *JSONClient) InsertGatewayProbe(ctx context.Context, gatewayProbe *Gatew
 	return enc.Encode(gatewayProbe)
 }
 
+func (c *JSONClient) InsertGatewayCertificate(ctx context.Context, gatewayCertificate *GatewayCertificateModel) error {
+	enc := json.NewEncoder(c.gatewayCertsFile)
+	return enc.Encode(gatewayCertificate)
+}
+
 func (c *JSONClient) InsertServiceWorkerProbe(ctx context.Context, serviceWorkerProbe *ServiceWorkerProbeModel) error {
 	enc := json.NewEncoder(c.serviceWorkerProbesFile)
 	return enc.Encode(serviceWorkerProbe)
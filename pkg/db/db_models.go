@@ -23,29 +23,31 @@ type UploadModel struct {
 
 type DownloadModel struct {
 	RunID                string
-	Region               string     `ch:"region"`
-	TirosVersion         string     `ch:"tiros_version"`
-	KuboVersion          string     `ch:"kubo_version"`
-	KuboPeerID           string     `ch:"kubo_peer_id"`
-	FileSizeB            int32      `ch:"file_size_b"`
-	MIMEType             string     `ch:"mime_type"`
-	CID                  string     `ch:"cid"`
-	IPFSCatStart         time.Time  `ch:"ipfs_cat_start"`
-	IPFSCatDurationS     float64    `ch:"ipfs_cat_duration_s"`
-	IPFSCatTTFBS         *float64   `ch:"ipfs_cat_ttfb_s"`
-	IdleBroadcastStart   *time.Time `ch:"idle_broadcast_start"`
-	FoundProvCount       int32      `ch:"found_prov_count"`
-	ConnProvCount        int32      `ch:"conn_prov_count"`
-	FirstConnProvFoundAt *time.Time `ch:"first_conn_prov_found_at"`
-	FirstProvConnAt      *time.Time `ch:"first_prov_conn_at"`
-	FirstProvPeerID      *string    `ch:"first_prov_peer_id"`
-	IPNIStart            *time.Time `ch:"ipni_start"`
-	IPNIDurationS        *float64   `ch:"ipni_duration_s"`
-	IPNIStatus           *int32     `ch:"ipni_status"`
-	FirstBlockReceivedAt *time.Time `ch:"first_block_rec_at"`
-	DiscoveryMethod      *string    `ch:"discovery_method"`
-	CIDSource            string     `ch:"cid_source"`
-	Error                *string    `ch:"error"`
+	Region               string            `ch:"region"`
+	TirosVersion         string            `ch:"tiros_version"`
+	KuboVersion          string            `ch:"kubo_version"`
+	KuboPeerID           string            `ch:"kubo_peer_id"`
+	FileSizeB            int32             `ch:"file_size_b"`
+	MIMEType             string            `ch:"mime_type"`
+	CID                  string            `ch:"cid"`
+	IPFSCatStart         time.Time         `ch:"ipfs_cat_start"`
+	IPFSCatDurationS     float64           `ch:"ipfs_cat_duration_s"`
+	IPFSCatTTFBS         *float64          `ch:"ipfs_cat_ttfb_s"`
+	IdleBroadcastStart   *time.Time        `ch:"idle_broadcast_start"`
+	FoundProvCount       int32             `ch:"found_prov_count"`
+	ConnProvCount        int32             `ch:"conn_prov_count"`
+	FirstConnProvFoundAt *time.Time        `ch:"first_conn_prov_found_at"`
+	FirstProvConnAt      *time.Time        `ch:"first_prov_conn_at"`
+	FirstProvPeerID      *string           `ch:"first_prov_peer_id"`
+	IPNIStart            *time.Time        `ch:"ipni_start"`
+	IPNIDurationS        *float64          `ch:"ipni_duration_s"`
+	IPNIStatus           *int32            `ch:"ipni_status"`
+	FirstBlockReceivedAt *time.Time        `ch:"first_block_rec_at"`
+	DiscoveryMethod      *string           `ch:"discovery_method"`
+	CIDSource            string            `ch:"cid_source"`
+	CIDMetadata          map[string]string `ch:"cid_metadata"`
+	CIDSizeClass         string            `ch:"cid_size_class"`
+	Error                *string           `ch:"error"`
 }
 
 type WebsiteProbeProtocol string
@@ -98,40 +100,178 @@ type ProviderModel struct {
 	CreatedAt      time.Time `ch:"created_at"`
 }
 
+// GatewayModel is an active gateway of the gateways table with the metadata
+// that configures how it's probed. Empty values mean the probe defaults.
+type GatewayModel struct {
+	Domain       string            `ch:"domain"`
+	URLStyle     string            `ch:"url_style"`
+	Formats      []string          `ch:"formats"`
+	AuthScheme   string            `ch:"auth_scheme"`
+	Headers      map[string]string `ch:"headers"`
+	Weight       float64           `ch:"weight"`
+	Tags         map[string]string `ch:"tags"`
+	Capabilities []string          `ch:"capabilities"`
+}
+
 type GatewayProbeFormat string
 
 const (
-	GatewayProbeFormatNone GatewayProbeFormat = "none"
-	GatewayProbeFormatRaw  GatewayProbeFormat = "raw"
-	GatewayProbeFormatCAR  GatewayProbeFormat = "car"
+	GatewayProbeFormatNone       GatewayProbeFormat = "none"
+	GatewayProbeFormatRaw        GatewayProbeFormat = "raw"
+	GatewayProbeFormatCAR        GatewayProbeFormat = "car"
+	GatewayProbeFormatTAR        GatewayProbeFormat = "tar"
+	GatewayProbeFormatDAGJSON    GatewayProbeFormat = "dag-json"
+	GatewayProbeFormatDAGCBOR    GatewayProbeFormat = "dag-cbor"
+	GatewayProbeFormatIPNSRecord GatewayProbeFormat = "ipns-record"
 )
 
 type GatewayProbeModel struct {
-	RunID             string    `ch:"run_id"`
-	Region            string    `ch:"region"`
-	TirosVersion      string    `ch:"tiros_version"`
-	Gateway           string    `ch:"gateway"`
-	CID               string    `ch:"cid"`
-	CIDSource         string    `ch:"cid_source"`
-	Format            string    `ch:"format"`
-	RequestStart      time.Time `ch:"request_start"`
-	DNSDurationS      *float64  `ch:"dns_duration_s"`
-	ConnDurationS     *float64  `ch:"conn_duration_s"`
-	TTFBS             *float64  `ch:"ttfb_s"`
-	DownloadDurationS float64   `ch:"download_duration_s"`
-	BytesReceived     int64     `ch:"bytes_received"`
-	ContentLength     *int64    `ch:"content_length"`
-	DownloadSpeedMbps *float64  `ch:"download_speed_mbps"`
-	StatusCode        int       `ch:"status_code"`
-	IPFSPath          *string   `ch:"ipfs_path"`
-	IPFSRoots         *string   `ch:"ipfs_roots"`
-	CacheStatus       *string   `ch:"cache_status"`
-	ContentType       *string   `ch:"content_type"`
-	CARValidated      *bool     `ch:"car_validated"`
-	RedirectCount     int       `ch:"redirect_count"`
-	FinalURL          *string   `ch:"final_url"`
-	Error             *string   `ch:"error"`
-	CreatedAt         time.Time `ch:"created_at"`
+	RunID             string            `ch:"run_id"`
+	Region            string            `ch:"region"`
+	TirosVersion      string            `ch:"tiros_version"`
+	Gateway           string            `ch:"gateway"`
+	CID               string            `ch:"cid"`
+	CIDSource         string            `ch:"cid_source"`
+	CIDMetadata       map[string]string `ch:"cid_metadata"`
+	CIDSizeClass      string            `ch:"cid_size_class"`
+	ContentPath       string            `ch:"content_path"`
+	ResolutionType    string            `ch:"resolution_type"`
+	ResolvedRoot      *string           `ch:"resolved_root"`
+	Format            string            `ch:"format"`
+	Protocol          string            `ch:"protocol"`
+	NegotiatedProto   string            `ch:"negotiated_protocol"`
+	ALPN              string            `ch:"alpn"`
+	URLStyle          string            `ch:"url_style"`
+	RequestStart      time.Time         `ch:"request_start"`
+	DNSDurationS      *float64          `ch:"dns_duration_s"`
+	ConnDurationS     *float64          `ch:"conn_duration_s"`
+	TLSDurationS      *float64          `ch:"tls_duration_s"`
+	TTFBS             *float64          `ch:"ttfb_s"`
+	DownloadDurationS float64           `ch:"download_duration_s"`
+	BytesReceived     int64             `ch:"bytes_received"`
+	ContentLength     *int64            `ch:"content_length"`
+	DownloadSpeedMbps *float64          `ch:"download_speed_mbps"`
+	StatusCode        int               `ch:"status_code"`
+	IPFSPath          *string           `ch:"ipfs_path"`
+	IPFSRoots         *string           `ch:"ipfs_roots"`
+	CacheStatus       *string           `ch:"cache_status"`
+	ContentType       *string           `ch:"content_type"`
+	CARValidated      *bool             `ch:"car_validated"`
+	FormatValid       *bool             `ch:"format_valid"`
+	FormatError       *string           `ch:"format_error"`
+	DAGScope          string            `ch:"dag_scope"`
+	RedirectCount     int               `ch:"redirect_count"`
+	FinalURL          *string           `ch:"final_url"`
+	SkippedReason     *string           `ch:"skipped_reason"`
+	Error             *string           `ch:"error"`
+	CreatedAt         time.Time         `ch:"created_at"`
+
+	// Redirect chain — parallel arrays bound to the Nested `redirect_chain`
+	// column. Every request of the probe is a hop, the last hop is the final
+	// request. Timings that weren't measured (e.g., DNS for a reused
+	// connection) are 0.
+	RedirectChainURL           []string  `ch:"redirect_chain.url"`
+	RedirectChainStatusCode    []int32   `ch:"redirect_chain.status_code"`
+	RedirectChainStartS        []float64 `ch:"redirect_chain.start_s"` // offset of the hop from request_start
+	RedirectChainDNSDurationS  []float64 `ch:"redirect_chain.dns_duration_s"`
+	RedirectChainConnDurationS []float64 `ch:"redirect_chain.conn_duration_s"`
+	RedirectChainTLSDurationS  []float64 `ch:"redirect_chain.tls_duration_s"`
+	RedirectChainTTFBS         []float64 `ch:"redirect_chain.ttfb_s"` // time to first byte since the start of the hop
+
+	// Subdomain gateway — the first request to a <root>.ipfs.<gateway> host,
+	// either requested directly or after a redirect of a path style request.
+	SubdomainRedirect     bool     `ch:"subdomain_redirect"`       // Whether the gateway redirected a path style request to its subdomain
+	SubdomainDNSDurationS *float64 `ch:"subdomain_dns_duration_s"` // Resolution of the per-root host name, usually a DNS wildcard
+	SubdomainTLSDurationS *float64 `ch:"subdomain_tls_duration_s"` // Handshake for the per-root host name
+
+	// CAR verification — only set for successful CAR requests.
+	CARBlocks           *int64  `ch:"car_blocks"`              // All blocks in the CAR including duplicates
+	CARInvalidBlocks    *int64  `ch:"car_invalid_blocks"`      // Blocks whose data doesn't hash to their CID
+	CARDuplicateBlocks  *int64  `ch:"car_duplicate_blocks"`    // Blocks that were sent more than once
+	CARUnexpectedBlocks *int64  `ch:"car_unexpected_blocks"`   // Valid blocks that aren't part of the requested path and dag-scope
+	CAROutOfRangeBlocks *int64  `ch:"car_out_of_range_blocks"` // Valid blocks of the entity outside the requested entity-bytes
+	CARMissingBlocks    *int64  `ch:"car_missing_blocks"`      // Blocks of the requested path and dag-scope that weren't sent
+	CARComplete         *bool   `ch:"car_complete"`            // Whether the CAR was read to its end and has valid data for all blocks of the path and dag-scope
+	CARError            *string `ch:"car_error"`               // Why the CAR couldn't be read to its end or the path couldn't be resolved
+	CARVersion          *int64  `ch:"car_version"`             // The CAR version of the response
+	CARDFSOrder         *bool   `ch:"car_dfs_order"`           // Whether the needed blocks arrived in depth-first order
+
+	// Trustless request parameters and how the gateway honored them.
+	EntityBytes       string            `ch:"entity_bytes"`
+	Accept            string            `ch:"accept"`              // The Accept header that was sent
+	ContentTypeParams map[string]string `ch:"content_type_params"` // The parameters of the response Content-Type, e.g., version, order, dups
+	ParamViolations   []string          `ch:"param_violations"`    // CAR parameters the gateway ignored or mishandled
+
+	// Gateway registry metadata of the probed gateway.
+	GatewayTags        map[string]string `ch:"gateway_tags"`        // e.g., operator or CDN
+	CapabilityExpected *bool             `ch:"capability_expected"` // Whether the gateway declares all capabilities the request needs. NULL if it declares none.
+
+	// Cache experiment: the first attempt of the first request of a content
+	// path to a gateway is expected to find the gateway's cache cold, the
+	// first attempts of its other variants primed, all later attempts warm.
+	AttemptIndex        int    `ch:"attempt_index"`
+	ExpectedCacheState  string `ch:"expected_cache_state"`  // cold, primed, or warm
+	CachePrecheckStatus *int32 `ch:"cache_precheck_status"` // Status code of the Cache-Control: only-if-cached pre-check
+	CachePrecheckHit    *bool  `ch:"cache_precheck_hit"`    // Whether the pre-check found the content cached
+	CacheExpectationMet *bool  `ch:"cache_expectation_met"` // Whether the cache status matched the expected state. NULL if unknown.
+
+	// Connection of the final request. The redirect_chain columns hold the
+	// same per hop.
+	RemoteIP                  *string  `ch:"remote_ip"`                // The IP address the connection was made to. NULL if none was made.
+	IPFamily                  *string  `ch:"ip_family"`                // 4 or 6
+	ForcedIPFamily            *string  `ch:"forced_ip_family"`         // The family the probe was restricted to. NULL if both were allowed.
+	ConnReused                *bool    `ch:"conn_reused"`              // Whether the connection was reused, e.g., from an earlier redirect
+	ConnAttempts              int32    `ch:"conn_attempts"`            // Dial attempts, more than 1 if the dialer fell back to another address
+	RedirectChainRemoteIP     []string `ch:"redirect_chain.remote_ip"` // "" if no connection was made
+	RedirectChainConnReused   []bool   `ch:"redirect_chain.conn_reused"`
+	RedirectChainConnAttempts []int32  `ch:"redirect_chain.conn_attempts"`
+
+	// DNS resolution of the gateway host.
+	DNSResolver   *string  `ch:"dns_resolver"`   // system, udp://<addr>, or the DNS-over-HTTPS URL. NULL for skipped probes.
+	ResolvedAddrs []string `ch:"resolved_addrs"` // The addresses of the last lookup, empty if none was made
+
+	// Byte-arrival timeline of the body — only set if the body was read. The
+	// shares of the content are relative to the expected size, or to the
+	// bytes received if it's unknown.
+	TimeTo25PctS      *float64 `ch:"time_to_25pct_s"`     // Since request_start
+	TimeTo50PctS      *float64 `ch:"time_to_50pct_s"`     // Since request_start
+	TimeTo100PctS     *float64 `ch:"time_to_100pct_s"`    // Since request_start. NULL if the content didn't arrive completely.
+	TransferSpeedMbps *float64 `ch:"transfer_speed_mbps"` // From the first to the last body bytes, excluding the time to first byte
+	LongestStallS     *float64 `ch:"longest_stall_s"`     // Longest gap in the arrival of body bytes, including the wait for the first ones
+	StallCount        int32    `ch:"stall_count"`         // Gaps of at least the stall threshold
+
+	// Size accounting of the body.
+	Truncated          bool    `ch:"truncated"`            // Whether the download stopped at download.max.mb before the body ended
+	ExpectedSize       *int64  `ch:"expected_size"`        // Size of the complete body. NULL if unknown.
+	ExpectedSizeSource *string `ch:"expected_size_source"` // content-length, head, or unixfs
+
+	// HTTP semantics conformance — parallel arrays bound to the Nested
+	// `conformance` column. Every check against the path and trustless
+	// gateway specs that applied to the probe is an entry.
+	ConformanceName   []string `ch:"conformance.name"` // e.g., etag, cache-control, or 406-unsupported-accept
+	ConformancePassed []bool   `ch:"conformance.passed"`
+	ConformanceDetail []string `ch:"conformance.detail"` // The header value or status code that was checked
+}
+
+// GatewayCertificateModel is the TLS connection and certificate of a gateway
+// as seen by a probe. It's recorded at most once per refresh interval.
+type GatewayCertificateModel struct {
+	RunID         string    `ch:"run_id"`
+	Region        string    `ch:"region"`
+	TirosVersion  string    `ch:"tiros_version"`
+	Gateway       string    `ch:"gateway"`
+	Host          string    `ch:"host"`         // The host the certificate was presented for, e.g., a subdomain of the gateway
+	TLSVersion    string    `ch:"tls_version"`  // e.g., "TLS 1.3", empty if the handshake failed
+	CipherSuite   string    `ch:"cipher_suite"` // e.g., "TLS_AES_128_GCM_SHA256"
+	Issuer        string    `ch:"issuer"`
+	Subject       string    `ch:"subject"`
+	SANs          []string  `ch:"sans"` // DNS names and IP addresses of the certificate
+	NotBefore     time.Time `ch:"not_before"`
+	NotAfter      time.Time `ch:"not_after"`
+	OCSPStapled   bool      `ch:"ocsp_stapled"`   // Whether the server stapled an OCSP response
+	HostnameValid bool      `ch:"hostname_valid"` // Whether the certificate is valid for the host
+	VerifyError   *string   `ch:"verify_error"`   // Why the certificate couldn't be verified
+	CreatedAt     time.Time `ch:"created_at"`
 }
 
 // ServiceWorkerProbeModel represents a performance measurement of an IPFS Service Worker Gateway.
@@ -139,13 +279,18 @@ type GatewayProbeModel struct {
 // from the service worker, after an initial redirect chain from the gateway domain.
 type ServiceWorkerProbeModel struct {
 	// Run metadata
-	RunID        string `ch:"run_id"`        // Unique identifier for this measurement run
-	Region       string `ch:"region"`        // AWS region where the measurement was performed
-	TirosVersion string `ch:"tiros_version"` // Version of Tiros performing the measurement
-	Gateway      string `ch:"gateway"`       // Service worker gateway domain (e.g., "inbrowser.link")
-	CID          string `ch:"cid"`           // IPFS Content ID being retrieved
-	CIDSource    string `ch:"cid_source"`    // Source of the CID (e.g., "static", "bitsniffer_bitswap")
-	URL          string `ch:"url"`           // Full URL requested (e.g., "https://inbrowser.link/ipfs/QmXxx")
+	RunID          string            `ch:"run_id"`          // Unique identifier for this measurement run
+	Region         string            `ch:"region"`          // AWS region where the measurement was performed
+	TirosVersion   string            `ch:"tiros_version"`   // Version of Tiros performing the measurement
+	Gateway        string            `ch:"gateway"`         // Service worker gateway domain (e.g., "inbrowser.link")
+	CID            string            `ch:"cid"`             // IPFS Content ID being retrieved
+	CIDSource      string            `ch:"cid_source"`      // Source of the CID (e.g., "static", "bitsniffer_bitswap")
+	CIDMetadata    map[string]string `ch:"cid_metadata"`    // Metadata of the CID from its source (e.g., "size", "mime_type", "tags")
+	CIDSizeClass   string            `ch:"cid_size_class"`  // Controlled class of the CID (e.g., "4MiB-file"), empty for other CIDs
+	ContentPath    string            `ch:"content_path"`    // Requested content path (e.g., "/ipns/en.wikipedia-on-ipfs.org/wiki")
+	ResolutionType string            `ch:"resolution_type"` // What the gateway had to resolve: "none", "path", "ipns", or "dnslink"
+	ResolvedRoot   *string           `ch:"resolved_root"`   // Root CID the path resolved to (first CID of x-ipfs-roots)
+	URL            string            `ch:"url"`             // Full URL requested (e.g., "https://inbrowser.link/ipfs/QmXxx")
 
 	// Core timing metrics (all measured using browser's ResourceTiming API)
 	// All timings use the same clock source (browser performance API) for consistency
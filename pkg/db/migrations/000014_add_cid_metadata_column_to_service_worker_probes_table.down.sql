@@ -0,0 +1,2 @@
+ALTER TABLE service_worker_probes
+    DROP COLUMN IF EXISTS cid_metadata;
@@ -0,0 +1,2 @@
+ALTER TABLE service_worker_probes
+    ADD COLUMN IF NOT EXISTS cid_metadata Map(LowCardinality(String), String) AFTER cid_source;
@@ -0,0 +1,2 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS cid_size_class LowCardinality(String) AFTER cid_metadata;
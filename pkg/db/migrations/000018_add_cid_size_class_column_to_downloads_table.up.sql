@@ -0,0 +1,2 @@
+ALTER TABLE downloads
+    ADD COLUMN IF NOT EXISTS cid_size_class LowCardinality(String) AFTER cid_metadata;
@@ -0,0 +1,4 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS content_path,
+    DROP COLUMN IF EXISTS resolution_type,
+    DROP COLUMN IF EXISTS resolved_root;
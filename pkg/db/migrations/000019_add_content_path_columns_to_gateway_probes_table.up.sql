@@ -0,0 +1,4 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS content_path    String AFTER cid_size_class,
+    ADD COLUMN IF NOT EXISTS resolution_type LowCardinality(String) AFTER content_path,
+    ADD COLUMN IF NOT EXISTS resolved_root   Nullable(String) AFTER resolution_type;
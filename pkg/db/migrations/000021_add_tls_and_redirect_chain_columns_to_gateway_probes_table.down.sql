@@ -0,0 +1,3 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS tls_duration_s,
+    DROP COLUMN IF EXISTS redirect_chain;
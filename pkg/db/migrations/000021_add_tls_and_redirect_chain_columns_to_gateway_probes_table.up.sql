@@ -0,0 +1,11 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS tls_duration_s Nullable(Float64) AFTER conn_duration_s,
+    ADD COLUMN IF NOT EXISTS redirect_chain Nested(
+        url             String,
+        status_code     Int32,
+        start_s         Float64,
+        dns_duration_s  Float64,
+        conn_duration_s Float64,
+        tls_duration_s  Float64,
+        ttfb_s          Float64
+    ) AFTER redirect_count;
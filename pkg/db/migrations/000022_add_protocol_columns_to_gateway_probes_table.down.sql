@@ -0,0 +1,4 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS protocol,
+    DROP COLUMN IF EXISTS negotiated_protocol,
+    DROP COLUMN IF EXISTS alpn;
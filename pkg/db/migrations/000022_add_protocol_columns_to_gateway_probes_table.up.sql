@@ -0,0 +1,4 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS protocol            LowCardinality(String) AFTER format,
+    ADD COLUMN IF NOT EXISTS negotiated_protocol LowCardinality(String) AFTER protocol,
+    ADD COLUMN IF NOT EXISTS alpn                LowCardinality(String) AFTER negotiated_protocol;
@@ -0,0 +1,9 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS dag_scope,
+    DROP COLUMN IF EXISTS car_blocks,
+    DROP COLUMN IF EXISTS car_invalid_blocks,
+    DROP COLUMN IF EXISTS car_duplicate_blocks,
+    DROP COLUMN IF EXISTS car_unexpected_blocks,
+    DROP COLUMN IF EXISTS car_missing_blocks,
+    DROP COLUMN IF EXISTS car_complete,
+    DROP COLUMN IF EXISTS car_error;
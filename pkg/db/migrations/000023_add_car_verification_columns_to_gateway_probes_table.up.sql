@@ -0,0 +1,9 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS dag_scope             LowCardinality(String) AFTER car_validated,
+    ADD COLUMN IF NOT EXISTS car_blocks            Nullable(Int64) AFTER dag_scope,
+    ADD COLUMN IF NOT EXISTS car_invalid_blocks    Nullable(Int64) AFTER car_blocks,
+    ADD COLUMN IF NOT EXISTS car_duplicate_blocks  Nullable(Int64) AFTER car_invalid_blocks,
+    ADD COLUMN IF NOT EXISTS car_unexpected_blocks Nullable(Int64) AFTER car_duplicate_blocks,
+    ADD COLUMN IF NOT EXISTS car_missing_blocks    Nullable(Int64) AFTER car_unexpected_blocks,
+    ADD COLUMN IF NOT EXISTS car_complete          Nullable(Bool) AFTER car_missing_blocks,
+    ADD COLUMN IF NOT EXISTS car_error             Nullable(String) AFTER car_complete;
@@ -0,0 +1,7 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS car_version,
+    DROP COLUMN IF EXISTS car_dfs_order,
+    DROP COLUMN IF EXISTS entity_bytes,
+    DROP COLUMN IF EXISTS accept,
+    DROP COLUMN IF EXISTS content_type_params,
+    DROP COLUMN IF EXISTS param_violations;
@@ -0,0 +1,7 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS car_version         Nullable(Int64) AFTER car_error,
+    ADD COLUMN IF NOT EXISTS car_dfs_order       Nullable(Bool) AFTER car_version,
+    ADD COLUMN IF NOT EXISTS entity_bytes        LowCardinality(String) AFTER dag_scope,
+    ADD COLUMN IF NOT EXISTS accept              String AFTER entity_bytes,
+    ADD COLUMN IF NOT EXISTS content_type_params Map(String, String) AFTER content_type,
+    ADD COLUMN IF NOT EXISTS param_violations    Array(LowCardinality(String)) AFTER car_dfs_order;
@@ -0,0 +1,3 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS format_valid,
+    DROP COLUMN IF EXISTS format_error;
@@ -0,0 +1,3 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS format_valid Nullable(Bool) AFTER car_validated,
+    ADD COLUMN IF NOT EXISTS format_error Nullable(String) AFTER format_valid;
@@ -0,0 +1,5 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS url_style,
+    DROP COLUMN IF EXISTS subdomain_redirect,
+    DROP COLUMN IF EXISTS subdomain_dns_duration_s,
+    DROP COLUMN IF EXISTS subdomain_tls_duration_s;
@@ -0,0 +1,5 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS url_style                LowCardinality(String) AFTER alpn,
+    ADD COLUMN IF NOT EXISTS subdomain_redirect       Bool AFTER redirect_count,
+    ADD COLUMN IF NOT EXISTS subdomain_dns_duration_s Nullable(Float64) AFTER subdomain_redirect,
+    ADD COLUMN IF NOT EXISTS subdomain_tls_duration_s Nullable(Float64) AFTER subdomain_dns_duration_s;
@@ -0,0 +1,2 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS skipped_reason LowCardinality(Nullable(String)) AFTER final_url;
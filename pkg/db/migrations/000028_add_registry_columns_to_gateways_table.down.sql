@@ -0,0 +1,8 @@
+ALTER TABLE gateways
+    DROP COLUMN IF EXISTS url_style,
+    DROP COLUMN IF EXISTS formats,
+    DROP COLUMN IF EXISTS auth_scheme,
+    DROP COLUMN IF EXISTS headers,
+    DROP COLUMN IF EXISTS weight,
+    DROP COLUMN IF EXISTS tags,
+    DROP COLUMN IF EXISTS capabilities;
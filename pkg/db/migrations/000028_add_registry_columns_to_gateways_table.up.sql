@@ -0,0 +1,8 @@
+ALTER TABLE gateways
+    ADD COLUMN IF NOT EXISTS url_style    LowCardinality(String) AFTER domain,
+    ADD COLUMN IF NOT EXISTS formats      Array(LowCardinality(String)) AFTER url_style,
+    ADD COLUMN IF NOT EXISTS auth_scheme  LowCardinality(String) AFTER formats,
+    ADD COLUMN IF NOT EXISTS headers      Map(String, String) AFTER auth_scheme,
+    ADD COLUMN IF NOT EXISTS weight       Float64 DEFAULT 1 AFTER headers,
+    ADD COLUMN IF NOT EXISTS tags         Map(LowCardinality(String), String) AFTER weight,
+    ADD COLUMN IF NOT EXISTS capabilities Array(LowCardinality(String)) AFTER tags;
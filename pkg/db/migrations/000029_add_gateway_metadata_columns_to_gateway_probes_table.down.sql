@@ -0,0 +1,3 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS gateway_tags,
+    DROP COLUMN IF EXISTS capability_expected;
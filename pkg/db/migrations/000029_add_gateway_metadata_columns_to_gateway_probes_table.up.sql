@@ -0,0 +1,3 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS gateway_tags        Map(LowCardinality(String), String) AFTER gateway,
+    ADD COLUMN IF NOT EXISTS capability_expected Nullable(Bool) AFTER gateway_tags;
@@ -0,0 +1,6 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS attempt_index,
+    DROP COLUMN IF EXISTS expected_cache_state,
+    DROP COLUMN IF EXISTS cache_precheck_status,
+    DROP COLUMN IF EXISTS cache_precheck_hit,
+    DROP COLUMN IF EXISTS cache_expectation_met;
@@ -0,0 +1,6 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS attempt_index         Int32 AFTER cache_status,
+    ADD COLUMN IF NOT EXISTS expected_cache_state  LowCardinality(String) AFTER attempt_index,
+    ADD COLUMN IF NOT EXISTS cache_precheck_status Nullable(Int32) AFTER expected_cache_state,
+    ADD COLUMN IF NOT EXISTS cache_precheck_hit    Nullable(Bool) AFTER cache_precheck_status,
+    ADD COLUMN IF NOT EXISTS cache_expectation_met Nullable(Bool) AFTER cache_precheck_hit;
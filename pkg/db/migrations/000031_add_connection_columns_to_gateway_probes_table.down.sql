@@ -0,0 +1,9 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS remote_ip,
+    DROP COLUMN IF EXISTS ip_family,
+    DROP COLUMN IF EXISTS forced_ip_family,
+    DROP COLUMN IF EXISTS conn_reused,
+    DROP COLUMN IF EXISTS conn_attempts,
+    DROP COLUMN IF EXISTS redirect_chain.remote_ip,
+    DROP COLUMN IF EXISTS redirect_chain.conn_reused,
+    DROP COLUMN IF EXISTS redirect_chain.conn_attempts;
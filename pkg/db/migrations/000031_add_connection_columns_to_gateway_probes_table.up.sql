@@ -0,0 +1,9 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS remote_ip                    Nullable(String) AFTER conn_duration_s,
+    ADD COLUMN IF NOT EXISTS ip_family                    LowCardinality(Nullable(String)) AFTER remote_ip,
+    ADD COLUMN IF NOT EXISTS forced_ip_family             LowCardinality(Nullable(String)) AFTER ip_family,
+    ADD COLUMN IF NOT EXISTS conn_reused                  Nullable(Bool) AFTER forced_ip_family,
+    ADD COLUMN IF NOT EXISTS conn_attempts                Int32 AFTER conn_reused,
+    ADD COLUMN IF NOT EXISTS redirect_chain.remote_ip     Array(String) AFTER redirect_chain.ttfb_s,
+    ADD COLUMN IF NOT EXISTS redirect_chain.conn_reused   Array(Bool) AFTER redirect_chain.remote_ip,
+    ADD COLUMN IF NOT EXISTS redirect_chain.conn_attempts Array(Int32) AFTER redirect_chain.conn_reused;
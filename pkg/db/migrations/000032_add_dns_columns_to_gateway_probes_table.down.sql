@@ -0,0 +1,3 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS dns_resolver,
+    DROP COLUMN IF EXISTS resolved_addrs;
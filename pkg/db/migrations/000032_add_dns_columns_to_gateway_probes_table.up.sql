@@ -0,0 +1,3 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS dns_resolver   LowCardinality(Nullable(String)) AFTER dns_duration_s,
+    ADD COLUMN IF NOT EXISTS resolved_addrs Array(String) AFTER dns_resolver;
@@ -0,0 +1 @@
+DROP TABLE gateway_certificates;
@@ -0,0 +1,35 @@
+CREATE TABLE gateway_certificates
+(
+    run_id         String,
+    -- the AWS region Tiros was deployed in
+    region         String,
+    -- the Tiros version that inspected the certificate
+    tiros_version  String,
+    -- the IPFS gateway under test (e.g., "ipfs.io", "dweb.link")
+    gateway        String,
+    -- the host the certificate was presented for, e.g., a subdomain of the gateway
+    host           String,
+    -- the negotiated TLS version (e.g., "TLS 1.3"), empty if the handshake failed
+    tls_version    LowCardinality(String),
+    -- the negotiated cipher suite (e.g., "TLS_AES_128_GCM_SHA256")
+    cipher_suite   LowCardinality(String),
+    -- the issuer and subject of the leaf certificate
+    issuer         String,
+    subject        String,
+    -- the DNS names and IP addresses of the leaf certificate
+    sans           Array(String),
+    -- the validity period of the leaf certificate
+    not_before     DateTime('UTC'),
+    not_after      DateTime('UTC'),
+    -- whether the server stapled an OCSP response
+    ocsp_stapled   Bool,
+    -- whether the certificate is valid for the host
+    hostname_valid Bool,
+    -- why the certificate couldn't be verified
+    verify_error   Nullable(String),
+    -- the time the certificate was inspected
+    created_at     DateTime64(3, 'UTC')
+
+) ENGINE = ReplicatedMergeTree
+      PRIMARY KEY (created_at, region, gateway)
+      PARTITION BY toStartOfMonth(created_at);
@@ -0,0 +1,7 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS time_to_25pct_s,
+    DROP COLUMN IF EXISTS time_to_50pct_s,
+    DROP COLUMN IF EXISTS time_to_100pct_s,
+    DROP COLUMN IF EXISTS transfer_speed_mbps,
+    DROP COLUMN IF EXISTS longest_stall_s,
+    DROP COLUMN IF EXISTS stall_count;
@@ -0,0 +1,7 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS time_to_25pct_s     Nullable(Float64) AFTER download_speed_mbps,
+    ADD COLUMN IF NOT EXISTS time_to_50pct_s     Nullable(Float64) AFTER time_to_25pct_s,
+    ADD COLUMN IF NOT EXISTS time_to_100pct_s    Nullable(Float64) AFTER time_to_50pct_s,
+    ADD COLUMN IF NOT EXISTS transfer_speed_mbps Nullable(Float64) AFTER time_to_100pct_s,
+    ADD COLUMN IF NOT EXISTS longest_stall_s     Nullable(Float64) AFTER transfer_speed_mbps,
+    ADD COLUMN IF NOT EXISTS stall_count         Int32 AFTER longest_stall_s;
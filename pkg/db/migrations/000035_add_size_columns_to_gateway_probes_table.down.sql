@@ -0,0 +1,4 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS truncated,
+    DROP COLUMN IF EXISTS expected_size,
+    DROP COLUMN IF EXISTS expected_size_source;
@@ -0,0 +1,4 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS truncated            Bool AFTER bytes_received,
+    ADD COLUMN IF NOT EXISTS expected_size        Nullable(Int64) AFTER truncated,
+    ADD COLUMN IF NOT EXISTS expected_size_source LowCardinality(Nullable(String)) AFTER expected_size;
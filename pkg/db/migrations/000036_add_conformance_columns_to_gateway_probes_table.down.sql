@@ -0,0 +1,2 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS conformance;
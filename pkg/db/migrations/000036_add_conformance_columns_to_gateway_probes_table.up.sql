@@ -0,0 +1,6 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS conformance Nested(
+        name   LowCardinality(String),
+        passed Bool,
+        detail String
+    ) AFTER expected_size_source;
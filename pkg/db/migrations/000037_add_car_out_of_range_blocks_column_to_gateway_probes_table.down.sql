@@ -0,0 +1,2 @@
+ALTER TABLE gateway_probes
+    DROP COLUMN IF EXISTS car_out_of_range_blocks;
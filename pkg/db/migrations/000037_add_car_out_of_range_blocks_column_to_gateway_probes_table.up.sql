@@ -0,0 +1,2 @@
+ALTER TABLE gateway_probes
+    ADD COLUMN IF NOT EXISTS car_out_of_range_blocks Nullable(Int64) AFTER car_unexpected_blocks;
@@ -0,0 +1,179 @@
+package gw
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of the circuit breaker of a gateway.
+type BreakerState string
+
+const (
+	// BreakerClosed lets all probes through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen skips all probes until the backoff has elapsed.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single trial probe through that decides whether
+	// the breaker closes again or reopens with a longer backoff.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// Reasons for skipped probes.
+const (
+	SkippedReasonCircuitOpen     = "circuit_open"
+	SkippedReasonCircuitHalfOpen = "circuit_half_open"
+	// SkippedReasonQueueFull is set if the gateway still had too many queued
+	// probes when the probes of a new CID were scheduled.
+	SkippedReasonQueueFull = "queue_full"
+)
+
+// BreakerConfig configures Breakers.
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failures after which the breaker
+	// of a gateway opens. 0 disables the breakers.
+	Threshold int
+
+	// InitialBackoff is how long a breaker stays open the first time. It
+	// doubles every time the trial probe fails, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultBreakerConfig returns the default configuration of Breakers.
+func DefaultBreakerConfig() *BreakerConfig {
+	return &BreakerConfig{
+		Threshold:      3,
+		InitialBackoff: time.Minute,
+		MaxBackoff:     30 * time.Minute,
+	}
+}
+
+// Breakers tracks the health of gateways with one circuit breaker per
+// gateway. It's safe for concurrent use, so that all workers share the
+// breakers and a gateway that is down isn't probed by any of them.
+type Breakers struct {
+	cfg *BreakerConfig
+	now func() time.Time
+
+	mu       sync.Mutex
+	gateways map[string]*breaker
+}
+
+type breaker struct {
+	state      BreakerState
+	failures   int           // consecutive failures while closed
+	backoff    time.Duration // of the current or last open state
+	openUntil  time.Time
+	trialStart time.Time // zero if no trial probe is in flight
+}
+
+// NewBreakers returns circuit breakers with the given configuration. A nil
+// cfg means the default configuration.
+func NewBreakers(cfg *BreakerConfig) *Breakers {
+	if cfg == nil {
+		cfg = DefaultBreakerConfig()
+	}
+
+	return &Breakers{
+		cfg:      cfg,
+		now:      time.Now,
+		gateways: map[string]*breaker{},
+	}
+}
+
+func (b *Breakers) get(gateway string) *breaker {
+	br, found := b.gateways[gateway]
+	if !found {
+		br = &breaker{state: BreakerClosed}
+		b.gateways[gateway] = br
+	}
+	return br
+}
+
+// Allow reports whether the gateway may be probed. If not, it returns the
+// reason why the probe is skipped. An open breaker turns half-open after its
+// backoff and lets the next caller through as the trial probe. A trial probe
+// that isn't recorded within the initial backoff is given up, and another
+// one is let through.
+func (b *Breakers) Allow(gateway string) (bool, string) {
+	if b.cfg.Threshold <= 0 {
+		return true, ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(gateway)
+	now := b.now()
+
+	switch br.state {
+	case BreakerOpen:
+		if now.Before(br.openUntil) {
+			return false, SkippedReasonCircuitOpen
+		}
+		br.state = BreakerHalfOpen
+		br.trialStart = now
+		slog.With("gateway", gateway).Info("Circuit breaker half-open, sending trial probe")
+		return true, ""
+
+	case BreakerHalfOpen:
+		if !br.trialStart.IsZero() && now.Sub(br.trialStart) < b.cfg.InitialBackoff {
+			return false, SkippedReasonCircuitHalfOpen
+		}
+		br.trialStart = now
+		return true, ""
+
+	default:
+		return true, ""
+	}
+}
+
+// Record updates the breaker of the gateway with the outcome of a probe.
+func (b *Breakers) Record(gateway string, failed bool) {
+	if b.cfg.Threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(gateway)
+
+	if !failed {
+		if br.state != BreakerClosed {
+			slog.With("gateway", gateway).Info("Circuit breaker closed")
+		}
+		br.state = BreakerClosed
+		br.failures = 0
+		br.backoff = 0
+		br.trialStart = time.Time{}
+		return
+	}
+
+	switch br.state {
+	case BreakerHalfOpen:
+		b.open(gateway, br, min(2*br.backoff, b.cfg.MaxBackoff))
+	case BreakerClosed:
+		br.failures += 1
+		if br.failures >= b.cfg.Threshold {
+			b.open(gateway, br, b.cfg.InitialBackoff)
+		}
+	}
+}
+
+func (b *Breakers) open(gateway string, br *breaker, backoff time.Duration) {
+	br.state = BreakerOpen
+	br.backoff = backoff
+	br.openUntil = b.now().Add(backoff)
+	br.trialStart = time.Time{}
+	slog.With("gateway", gateway, "backoff", backoff).Info("Circuit breaker opened")
+}
+
+// State returns the state of the gateway's breaker.
+func (b *Breakers) State(gateway string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.get(gateway).state
+}
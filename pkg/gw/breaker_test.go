@@ -0,0 +1,133 @@
+package gw
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBreakers() (*Breakers, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBreakers(&BreakerConfig{Threshold: 2, InitialBackoff: time.Minute, MaxBackoff: 3 * time.Minute})
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakers(t *testing.T) {
+	b, now := newTestBreakers()
+
+	// failures below the threshold keep the breaker closed, a success resets
+	// the count
+	b.Record("gw", true)
+	b.Record("gw", false)
+	b.Record("gw", true)
+	assert.Equal(t, BreakerClosed, b.State("gw"))
+
+	b.Record("gw", true)
+	assert.Equal(t, BreakerOpen, b.State("gw"))
+
+	ok, reason := b.Allow("gw")
+	assert.False(t, ok)
+	assert.Equal(t, SkippedReasonCircuitOpen, reason)
+
+	// other gateways aren't affected
+	ok, _ = b.Allow("other")
+	assert.True(t, ok)
+
+	// after the backoff, a single trial probe is let through
+	*now = now.Add(time.Minute)
+	ok, _ = b.Allow("gw")
+	assert.True(t, ok)
+	assert.Equal(t, BreakerHalfOpen, b.State("gw"))
+
+	ok, reason = b.Allow("gw")
+	assert.False(t, ok)
+	assert.Equal(t, SkippedReasonCircuitHalfOpen, reason)
+
+	// a failed trial doubles the backoff
+	b.Record("gw", true)
+	assert.Equal(t, BreakerOpen, b.State("gw"))
+
+	*now = now.Add(time.Minute)
+	ok, _ = b.Allow("gw")
+	assert.False(t, ok)
+
+	*now = now.Add(time.Minute)
+	ok, _ = b.Allow("gw")
+	assert.True(t, ok)
+
+	// up to the maximum
+	b.Record("gw", true)
+	*now = now.Add(3 * time.Minute)
+	ok, _ = b.Allow("gw")
+	assert.True(t, ok)
+
+	// a successful trial closes the breaker
+	b.Record("gw", false)
+	assert.Equal(t, BreakerClosed, b.State("gw"))
+	ok, _ = b.Allow("gw")
+	assert.True(t, ok)
+
+	// and resets the backoff
+	b.Record("gw", true)
+	b.Record("gw", true)
+	*now = now.Add(time.Minute)
+	ok, _ = b.Allow("gw")
+	assert.True(t, ok)
+}
+
+func TestBreakers_abandonedTrial(t *testing.T) {
+	b, now := newTestBreakers()
+
+	b.Record("gw", true)
+	b.Record("gw", true)
+
+	*now = now.Add(time.Minute)
+	ok, _ := b.Allow("gw")
+	assert.True(t, ok)
+
+	// the trial is never recorded, e.g., because its worker stopped
+	*now = now.Add(time.Minute)
+	ok, _ = b.Allow("gw")
+	assert.True(t, ok)
+}
+
+func TestBreakers_disabled(t *testing.T) {
+	b := NewBreakers(&BreakerConfig{})
+
+	for range 10 {
+		b.Record("gw", true)
+	}
+
+	ok, _ := b.Allow("gw")
+	assert.True(t, ok)
+}
+
+func TestBreakers_concurrent(t *testing.T) {
+	b, now := newTestBreakers()
+
+	b.Record("gw", true)
+	b.Record("gw", true)
+	*now = now.Add(time.Minute)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for range 10 {
+		wg.Go(func() {
+			if ok, _ := b.Allow("gw"); ok {
+				mu.Lock()
+				allowed += 1
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	// only a single worker sends the trial probe
+	assert.Equal(t, 1, allowed)
+}
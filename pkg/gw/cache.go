@@ -0,0 +1,212 @@
+package gw
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg"
+)
+
+// CacheState is the state of the gateway's cache that a probe is expected to
+// find.
+type CacheState string
+
+const (
+	// CacheCold is expected by the first attempt of the first request of a
+	// content path to a gateway: the content wasn't requested from the
+	// gateway before and has to be fetched.
+	CacheCold CacheState = "cold"
+	// CachePrimed is expected by the first attempts of all other requests of
+	// the same content path to the gateway, e.g., in other formats or over
+	// other protocols. The gateway already fetched the content, but the
+	// response of the request itself wasn't cached before.
+	CachePrimed CacheState = "primed"
+	// CacheWarm is expected by all later attempts, which follow a successful
+	// attempt of the same request.
+	CacheWarm CacheState = "warm"
+)
+
+// ExpectedCacheState returns the cache state the request is expected to find.
+func (r *Request) ExpectedCacheState() CacheState {
+	switch {
+	case r.Attempt > 0:
+		return CacheWarm
+	case r.Primed:
+		return CachePrimed
+	default:
+		return CacheCold
+	}
+}
+
+// CacheExpectationMet reports whether the probe found the cache in its
+// expected state. A cold probe misses its expectation if the pre-check or the
+// cache status reported a hit, a warm probe if the cache status reported a
+// miss. It returns nil if neither tells the cache state, and for primed
+// probes, which may or may not be served from the cache.
+func (r *Result) CacheExpectationMet() *bool {
+	hit := cacheHit(r.CacheStatus())
+
+	switch r.Request.ExpectedCacheState() {
+	case CacheWarm:
+		return hit
+	case CachePrimed:
+		return nil
+	}
+
+	switch {
+	case r.CachePrecheckHit != nil && *r.CachePrecheckHit:
+		return ptr.From(false)
+	case hit != nil:
+		return ptr.From(!*hit)
+	case r.CachePrecheckHit != nil:
+		return ptr.From(true)
+	default:
+		return nil
+	}
+}
+
+// ColdProbes remembers which content paths were probed on which gateways, so
+// that only the first probe of a content path on a gateway is expected to find
+// its cache cold, even if the content path is probed again in a later round.
+// Entries expire after the TTL, and the oldest entry is evicted if there are
+// more than the maximum number. It's safe for concurrent use.
+type ColdProbes struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]struct{}
+	order   []coldProbe // by first probe, oldest first
+}
+
+type coldProbe struct {
+	key string
+	at  time.Time
+}
+
+// NewColdProbes returns an empty set of cold probes that holds at most size
+// entries.
+func NewColdProbes(ttl time.Duration, size int) *ColdProbes {
+	return &ColdProbes{
+		ttl:     ttl,
+		size:    max(size, 1),
+		now:     time.Now,
+		entries: map[string]struct{}{},
+	}
+}
+
+// Claim returns true for the first probe of the content path on the gateway
+// within the TTL. CIDv0 and CIDv1 of the same content count as the same
+// content path.
+func (c *ColdProbes) Claim(gateway string, path pkg.ContentPath) bool {
+	key := gateway + " " + path.String()
+	if root := path.CID(); root.Defined() {
+		key = gateway + " " + string(root.Hash()) + path.SubPath
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for len(c.order) > 0 && now.Sub(c.order[0].at) >= c.ttl {
+		c.evict()
+	}
+
+	if _, found := c.entries[key]; found {
+		return false
+	}
+
+	if len(c.order) >= c.size {
+		c.evict()
+	}
+	c.entries[key] = struct{}{}
+	c.order = append(c.order, coldProbe{key: key, at: now})
+	return true
+}
+
+// evict removes the oldest entry. It must be called with mu held.
+func (c *ColdProbes) evict() {
+	delete(c.entries, c.order[0].key)
+	c.order = c.order[1:]
+}
+
+// precheck asks the gateway whether it has the content of the request cached
+// without fetching it. A cache that honours Cache-Control: only-if-cached
+// responds with 504 Gateway Timeout if it doesn't have the content, and
+// serves it otherwise. The body of the response is never read. It returns the
+// status code and whether the content is cached, or nils if the pre-check
+// failed. Gateways that ignore the directive are warmed up by the pre-check,
+// which their cache status usually gives away as a miss.
+func (p *Prober) precheck(ctx context.Context, req *Request, url string) (*int32, *bool) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	transport, err := p.newTransport(req)
+	if err != nil {
+		return nil, nil
+	}
+	defer transport.Close()
+
+	httpReq, err := p.newHTTPRequest(ctx, req, url)
+	if err != nil {
+		return nil, nil
+	}
+	httpReq.Header.Set("Cache-Control", "only-if-cached")
+
+	client := &http.Client{Transport: transport, Timeout: p.cfg.Timeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, nil
+	}
+	_ = resp.Body.Close()
+
+	status := int32(resp.StatusCode)
+	hit := resp.StatusCode < 400
+	if cached := cacheHit(cacheStatus(resp.Header)); cached != nil && !*cached {
+		hit = false
+	}
+
+	return &status, &hit
+}
+
+// cacheStatus returns the normalized cache status of the CDN in the response
+// headers.
+func cacheStatus(h http.Header) *string {
+	if h == nil {
+		return nil
+	}
+
+	// convert header type
+	hdr := make(map[string]any, len(h))
+	for k, v := range h {
+		if len(v) > 0 {
+			// drop multi-value header fields
+			hdr[strings.ToLower(k)] = v[0]
+		}
+	}
+
+	return pkg.ParseCacheStatus(hdr)
+}
+
+// cacheHit interprets a cache status. It returns nil if the status doesn't
+// tell whether the cache was hit, e.g., because there was none.
+func cacheHit(status *string) *bool {
+	if status == nil {
+		return nil
+	}
+
+	s := strings.ToUpper(*status)
+	switch {
+	case strings.Contains(s, "MISS"):
+		return ptr.From(false)
+	case strings.Contains(s, "HIT"):
+		return ptr.From(true)
+	default:
+		return nil
+	}
+}
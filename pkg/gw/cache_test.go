@@ -0,0 +1,137 @@
+package gw
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber_Probe_cacheExperiment(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	gateway.Cache = true
+
+	req := &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatRaw, CachePrecheck: true}
+
+	cold := prober.Probe(context.Background(), req)
+	require.NoError(t, cold.Err)
+	assert.Equal(t, ptr.From[int32](http.StatusGatewayTimeout), cold.CachePrecheckStatus)
+	assert.Equal(t, ptr.From(false), cold.CachePrecheckHit)
+	assert.Equal(t, ptr.From(true), cold.CacheExpectationMet())
+
+	m := cold.Model()
+	assert.Equal(t, 0, m.AttemptIndex)
+	assert.Equal(t, string(CacheCold), m.ExpectedCacheState)
+	assert.Equal(t, "MISS", *m.CacheStatus)
+	assert.Equal(t, ptr.From(true), m.CacheExpectationMet)
+
+	// the pre-check is only made for the first attempt
+	warmReq := *req
+	warmReq.Attempt = 1
+	warm := prober.Probe(context.Background(), &warmReq)
+	require.NoError(t, warm.Err)
+	assert.Nil(t, warm.CachePrecheckStatus)
+
+	m = warm.Model()
+	assert.Equal(t, 1, m.AttemptIndex)
+	assert.Equal(t, string(CacheWarm), m.ExpectedCacheState)
+	assert.Equal(t, ptr.From(true), m.CacheExpectationMet)
+
+	requests := gateway.Requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, "only-if-cached", requests[0].Header.Get("Cache-Control"))
+	assert.Empty(t, requests[1].Header.Get("Cache-Control"))
+	assert.Empty(t, requests[2].Header.Get("Cache-Control"))
+
+	// a cold probe of content that was cached before is contaminated
+	contaminated := prober.Probe(context.Background(), req)
+	require.NoError(t, contaminated.Err)
+	assert.Equal(t, ptr.From[int32](http.StatusOK), contaminated.CachePrecheckStatus)
+	assert.Equal(t, ptr.From(true), contaminated.CachePrecheckHit)
+	assert.Equal(t, ptr.From(false), contaminated.CacheExpectationMet())
+
+	// another variant of the same content path isn't pre-checked
+	primed := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatCAR, CachePrecheck: true, Primed: true})
+	require.NoError(t, primed.Err)
+	assert.Nil(t, primed.CachePrecheckStatus)
+	assert.Nil(t, primed.CacheExpectationMet())
+	assert.Equal(t, string(CachePrimed), primed.Model().ExpectedCacheState)
+	assert.Len(t, gateway.Requests(), 6)
+}
+
+func TestResult_CacheExpectationMet(t *testing.T) {
+	tests := []struct {
+		name        string
+		attempt     int
+		primed      bool
+		precheckHit *bool
+		cacheHeader string
+		expectedMet *bool
+	}{
+		{name: "cold unknown", attempt: 0},
+		{name: "cold miss", attempt: 0, cacheHeader: "MISS", expectedMet: ptr.From(true)},
+		{name: "cold hit", attempt: 0, cacheHeader: "HIT", expectedMet: ptr.From(false)},
+		{name: "cold precheck miss", attempt: 0, precheckHit: ptr.From(false), expectedMet: ptr.From(true)},
+		{name: "cold precheck hit", attempt: 0, precheckHit: ptr.From(true), cacheHeader: "MISS", expectedMet: ptr.From(false)},
+		{name: "cold precheck miss but hit", attempt: 0, precheckHit: ptr.From(false), cacheHeader: "HIT", expectedMet: ptr.From(false)},
+		{name: "warm unknown", attempt: 1},
+		{name: "warm hit", attempt: 1, cacheHeader: "TCP_HIT from cache", expectedMet: ptr.From(true)},
+		{name: "warm miss", attempt: 1, cacheHeader: "MISS", expectedMet: ptr.From(false)},
+		{name: "primed hit", primed: true, cacheHeader: "HIT"},
+		{name: "primed miss", primed: true, cacheHeader: "MISS"},
+		{name: "primed warm miss", attempt: 1, primed: true, cacheHeader: "MISS", expectedMet: ptr.From(false)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &Result{
+				Request:          &Request{Attempt: tt.attempt, Primed: tt.primed},
+				CachePrecheckHit: tt.precheckHit,
+				Headers:          http.Header{},
+			}
+			if tt.cacheHeader != "" {
+				result.Headers.Set("X-Cache", tt.cacheHeader)
+			}
+
+			assert.Equal(t, tt.expectedMet, result.CacheExpectationMet())
+		})
+	}
+}
+
+func TestColdProbes_Claim(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewColdProbes(time.Hour, 3)
+	c.now = func() time.Time { return now }
+
+	v0 := cid.MustParse("QmUvSqPqYsjeab2JgsNc4PjbAGnCzfn5xid6piJgYYzehH")
+	v1 := cid.NewCidV1(uint64(multicodec.DagPb), v0.Hash())
+
+	assert.True(t, c.Claim("gw", pkg.CIDPath(v0)))
+
+	// a later round probes the same content, in another cid version
+	now = now.Add(time.Minute)
+	assert.False(t, c.Claim("gw", pkg.CIDPath(v0)))
+	assert.False(t, c.Claim("gw", pkg.CIDPath(v1)))
+
+	// other gateways and paths are cold
+	assert.True(t, c.Claim("other", pkg.CIDPath(v0)))
+	assert.True(t, c.Claim("gw", pkg.ContentPath{Namespace: "ipfs", Root: v0.String(), SubPath: "/file"}))
+
+	// the oldest entry is evicted if there are too many
+	assert.True(t, c.Claim("third", pkg.CIDPath(v0)))
+	assert.True(t, c.Claim("gw", pkg.CIDPath(v0)))
+	assert.False(t, c.Claim("third", pkg.CIDPath(v0)))
+
+	// and all expire after the ttl
+	now = now.Add(time.Hour)
+	assert.True(t, c.Claim("third", pkg.CIDPath(v0)))
+	assert.Len(t, c.entries, 1)
+}
@@ -0,0 +1,469 @@
+package gw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"slices"
+	"strings"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/traversal"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/spaolacci/murmur3"
+)
+
+// DAGScope is the dag-scope parameter of a trustless gateway request. It
+// determines which blocks below the terminal element of a content path the
+// CAR response must contain.
+type DAGScope string
+
+const (
+	// DAGScopeAll includes the entire DAG of the terminal element.
+	DAGScopeAll DAGScope = "all"
+	// DAGScopeEntity includes the blocks that are needed to read the
+	// terminal element: a whole UnixFS file, the (sharded) directory listing,
+	// or a single block of any other codec.
+	DAGScopeEntity DAGScope = "entity"
+	// DAGScopeBlock only includes the terminal block.
+	DAGScopeBlock DAGScope = "block"
+)
+
+func (s DAGScope) orDefault() DAGScope {
+	if s == "" {
+		return DAGScopeAll
+	}
+	return s
+}
+
+// CARVerification is the outcome of verifying a CAR response against the
+// requested content path.
+type CARVerification struct {
+	// RootsValid reports whether the CAR roots contain the requested CID or
+	// one of the CIDs the gateway reported in X-Ipfs-Roots.
+	RootsValid bool
+
+	Blocks           int64 // all blocks in the CAR, including duplicates
+	InvalidBlocks    int64 // blocks whose data doesn't hash to their CID
+	DuplicateBlocks  int64 // blocks that were sent more than once
+	UnexpectedBlocks int64 // valid blocks that aren't needed for the content path and scope
+	OutOfRangeBlocks int64 // valid blocks of the entity that are outside the requested entity-bytes
+	MissingBlocks    int64 // blocks that are needed for the content path and scope but weren't sent
+
+	// Version is the CAR version of the response.
+	Version uint64
+
+	// DFSOrder reports whether the needed blocks arrived in the depth-first
+	// order in which the DAG is walked.
+	DFSOrder bool
+
+	// Complete reports whether the CAR was read to its end and contains
+	// valid data for every block of the content path and scope.
+	Complete bool
+
+	// Err is the reason why the CAR could not be read to its end or the
+	// content path could not be resolved.
+	Err error
+}
+
+// VerifyCAR reads the CAR from r, hashes every block against its CID, and
+// walks the DAG from the root of the content path to check that it contains
+// exactly the blocks for the requested dag-scope and entity-bytes. roots are
+// the CIDs the gateway reported in X-Ipfs-Roots. The first is used as the
+// root of /ipns paths.
+func VerifyCAR(r io.Reader, path pkg.ContentPath, params CARParams, roots []cid.Cid) *CARVerification {
+	v := &CARVerification{}
+
+	// verify the hashes ourselves, so that corrupt blocks are counted and
+	// don't abort reading the CAR
+	br, err := carv2.NewBlockReader(r, carv2.WithTrustedCAR(true))
+	if err != nil {
+		v.Err = fmt.Errorf("reading car header: %w", err)
+		return v
+	}
+	v.Version = br.Version
+
+	expected := append([]cid.Cid{path.CID()}, roots...)
+	for _, root := range br.Roots {
+		if root.Defined() && slices.ContainsFunc(expected, root.Equals) {
+			v.RootsValid = true
+			break
+		}
+	}
+
+	blocks := map[cid.Cid][]byte{}
+	var arrivals []cid.Cid
+	for {
+		blk, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			v.Err = fmt.Errorf("reading car block: %w", err)
+			break
+		}
+		v.Blocks += 1
+
+		c := blk.Cid()
+		if _, found := blocks[c]; found {
+			v.DuplicateBlocks += 1
+			continue
+		}
+
+		hashed, err := c.Prefix().Sum(blk.RawData())
+		if err != nil || !hashed.Equals(c) {
+			v.InvalidBlocks += 1
+			continue
+		}
+
+		blocks[c] = blk.RawData()
+		arrivals = append(arrivals, c)
+	}
+
+	root := path.CID()
+	if !root.Defined() && len(roots) > 0 {
+		root = roots[0]
+	} else if !root.Defined() && len(br.Roots) > 0 {
+		root = br.Roots[0]
+	}
+
+	if !root.Defined() {
+		v.Err = fmt.Errorf("no root to verify the car against")
+		return v
+	}
+
+	w := &dagWalker{blocks: blocks, visited: map[cid.Cid]bool{}}
+	if params.EntityBytes != "" {
+		w.entityBytes, err = parseEntityBytes(params.EntityBytes)
+		if err != nil {
+			v.Err = err
+			return v
+		}
+	}
+
+	terminal, err := w.resolve(root, path.SubPath)
+	if err != nil && v.Err == nil {
+		v.Err = err
+	}
+
+	if err == nil {
+		switch params.DAGScope.orDefault() {
+		case DAGScopeBlock:
+			w.visit(terminal)
+		case DAGScopeEntity:
+			w.entity(terminal)
+		default:
+			w.all(terminal)
+		}
+	}
+
+	v.MissingBlocks = w.missing
+
+	// blocks of the entity that are outside the requested byte range are
+	// counted apart from the blocks outside the scope
+	var entity map[cid.Cid]bool
+	if err == nil && w.entityBytes != nil && params.DAGScope == DAGScopeEntity {
+		full := &dagWalker{blocks: blocks, visited: map[cid.Cid]bool{}}
+		full.entity(terminal)
+		entity = full.visited
+	}
+
+	for c := range blocks {
+		if w.visited[c] {
+			continue
+		} else if entity[c] {
+			v.OutOfRangeBlocks += 1
+		} else {
+			v.UnexpectedBlocks += 1
+		}
+	}
+
+	needed := slices.DeleteFunc(arrivals, func(c cid.Cid) bool { return !w.visited[c] })
+	v.DFSOrder = slices.Equal(needed, w.order)
+
+	v.Complete = v.Err == nil && v.InvalidBlocks == 0 && v.MissingBlocks == 0
+
+	return v
+}
+
+// dagWalker walks a DAG over the blocks of a CAR and records which blocks were
+// needed and which of them were missing.
+type dagWalker struct {
+	blocks      map[cid.Cid][]byte
+	visited     map[cid.Cid]bool
+	order       []cid.Cid // needed blocks that were sent, in visit order
+	missing     int64
+	entityBytes *byteRange
+}
+
+type dagLink struct {
+	name string
+	cid  cid.Cid
+}
+
+// visit marks the block as needed and returns its data. It returns false if
+// the block was already visited or is missing.
+func (w *dagWalker) visit(c cid.Cid) ([]byte, bool) {
+	if w.visited[c] {
+		return nil, false
+	}
+	w.visited[c] = true
+
+	if c.Prefix().MhType == mh.IDENTITY {
+		// identity CIDs inline their data and don't need to be sent
+		dmh, err := mh.Decode(c.Hash())
+		if err != nil {
+			return nil, false
+		}
+		return dmh.Digest, true
+	}
+
+	data, found := w.blocks[c]
+	if !found {
+		w.missing += 1
+		return nil, false
+	}
+	w.order = append(w.order, c)
+
+	return data, true
+}
+
+// resolve follows the UnixFS path segments from the root and returns the CID
+// of the terminal element. All blocks on the way are marked as needed.
+func (w *dagWalker) resolve(root cid.Cid, subPath string) (cid.Cid, error) {
+	cur := root
+	for _, segment := range strings.Split(strings.Trim(subPath, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+
+		data, ok := w.visit(cur)
+		if !ok {
+			return cid.Undef, fmt.Errorf("block %s of path %s is missing", cur, subPath)
+		}
+
+		next, err := w.lookup(cur, data, segment)
+		if err != nil {
+			return cid.Undef, err
+		}
+		cur = next
+	}
+
+	return cur, nil
+}
+
+// lookup finds the link with the given name in a UnixFS directory.
+func (w *dagWalker) lookup(c cid.Cid, data []byte, name string) (cid.Cid, error) {
+	links, fsNode := decodeLinks(c, data)
+	if fsNode != nil && fsNode.Type() == unixfs.THAMTShard {
+		return w.lookupShard(c, fsNode, links, name, 0)
+	}
+
+	for _, l := range links {
+		if l.name == name {
+			return l.cid, nil
+		}
+	}
+	return cid.Undef, fmt.Errorf("no link named %q under %s", name, c)
+}
+
+// lookupShard finds the link with the given name in a sharded directory. Like
+// the HAMT, it follows the bucket that the name hashes to at the given depth,
+// so only the shards on the way to the name are needed.
+func (w *dagWalker) lookupShard(c cid.Cid, fsNode *unixfs.FSNode, links []dagLink, name string, depth int) (cid.Cid, error) {
+	bucket, err := hamtBucket(name, depth, fsNode.Fanout())
+	if err != nil {
+		return cid.Undef, fmt.Errorf("sharded directory %s: %w", c, err)
+	}
+
+	padLen := hamtPadLen(fsNode)
+	prefix := fmt.Sprintf("%0*X", padLen, bucket)
+	for _, l := range links {
+		if !strings.HasPrefix(l.name, prefix) {
+			continue
+		}
+
+		// a bucket holds either a single entry or a shard
+		if len(l.name) > padLen {
+			if l.name[padLen:] == name {
+				return l.cid, nil
+			}
+			break
+		}
+
+		shard, ok := w.visit(l.cid)
+		if !ok {
+			return cid.Undef, fmt.Errorf("shard %s of directory %s is missing", l.cid, c)
+		}
+
+		shardLinks, shardNode := decodeLinks(l.cid, shard)
+		if shardNode == nil || shardNode.Type() != unixfs.THAMTShard {
+			return cid.Undef, fmt.Errorf("shard %s of directory %s is not a hamt shard", l.cid, c)
+		}
+
+		return w.lookupShard(l.cid, shardNode, shardLinks, name, depth+1)
+	}
+
+	return cid.Undef, fmt.Errorf("no link named %q under %s", name, c)
+}
+
+// entity marks the blocks that are needed to read the element as needed.
+func (w *dagWalker) entity(c cid.Cid) {
+	data, ok := w.visit(c)
+	if !ok {
+		return
+	}
+
+	links, fsNode := decodeLinks(c, data)
+	if fsNode == nil {
+		// only UnixFS entities span multiple blocks
+		return
+	}
+
+	switch fsNode.Type() {
+	case unixfs.TDirectory:
+		return
+	case unixfs.THAMTShard:
+		padLen := hamtPadLen(fsNode)
+		for _, l := range links {
+			if len(l.name) == padLen {
+				w.entity(l.cid)
+			}
+		}
+	default:
+		if w.entityBytes == nil {
+			for _, l := range links {
+				w.all(l.cid)
+			}
+			return
+		}
+
+		from, to, ok := w.entityBytes.resolve(fsNode.FileSize())
+		if ok {
+			w.fileRange(fsNode, links, 0, from, to)
+		}
+	}
+}
+
+// fileRange marks the blocks of a UnixFS file that hold the bytes between from
+// and to (inclusive) as needed. offset is the position of the node within the
+// file.
+func (w *dagWalker) fileRange(fsNode *unixfs.FSNode, links []dagLink, offset, from, to uint64) {
+	pos := offset + uint64(len(fsNode.Data()))
+	for i, l := range links {
+		if i >= fsNode.NumChildren() {
+			break
+		}
+
+		size := fsNode.BlockSize(i)
+		if pos+size > from && pos <= to {
+			if data, ok := w.visit(l.cid); ok {
+				childLinks, childNode := decodeLinks(l.cid, data)
+				if childNode != nil {
+					w.fileRange(childNode, childLinks, pos, from, to)
+				}
+			}
+		}
+		pos += size
+	}
+}
+
+// all marks the entire DAG below c as needed.
+func (w *dagWalker) all(c cid.Cid) {
+	stack := []cid.Cid{c}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		data, ok := w.visit(cur)
+		if !ok {
+			continue
+		}
+
+		links, _ := decodeLinks(cur, data)
+		for i := len(links) - 1; i >= 0; i-- {
+			stack = append(stack, links[i].cid)
+		}
+	}
+}
+
+// decodeLinks returns the links of a block. For dag-pb blocks, it also
+// returns the UnixFS node if the block is one.
+func decodeLinks(c cid.Cid, data []byte) ([]dagLink, *unixfs.FSNode) {
+	switch c.Type() {
+	case cid.DagProtobuf:
+		nd, err := merkledag.DecodeProtobuf(data)
+		if err != nil {
+			return nil, nil
+		}
+
+		links := make([]dagLink, 0, len(nd.Links()))
+		for _, l := range nd.Links() {
+			links = append(links, dagLink{name: l.Name, cid: l.Cid})
+		}
+
+		fsNode, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return links, nil
+		}
+
+		return links, fsNode
+
+	case cid.DagCBOR, cid.DagJSON:
+		decode := dagcbor.Decode
+		if c.Type() == cid.DagJSON {
+			decode = dagjson.Decode
+		}
+
+		nd, err := ipld.Decode(data, decode)
+		if err != nil {
+			return nil, nil
+		}
+
+		ipldLinks, err := traversal.SelectLinks(nd)
+		if err != nil {
+			return nil, nil
+		}
+
+		links := make([]dagLink, 0, len(ipldLinks))
+		for _, l := range ipldLinks {
+			if cl, ok := l.(cidlink.Link); ok {
+				links = append(links, dagLink{cid: cl.Cid})
+			}
+		}
+
+		return links, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+// hamtBucket returns the index of the bucket that holds the name in a shard at
+// the given depth of a sharded directory. Every level consumes the next
+// log2(fanout) bits of the 64-bit murmur3 hash of the name.
+func hamtBucket(name string, depth int, fanout uint64) (int, error) {
+	width := bits.TrailingZeros64(fanout)
+	if fanout == 0 || fanout != 1<<width {
+		return 0, fmt.Errorf("fanout %d is not a power of two", fanout)
+	} else if (depth+1)*width > 64 {
+		return 0, fmt.Errorf("too deep")
+	}
+
+	h := murmur3.Sum64([]byte(name))
+	return int((h >> (64 - (depth+1)*width)) & (fanout - 1)), nil
+}
+
+// hamtPadLen returns the length of the hex prefix of the link names in a
+// sharded directory.
+func hamtPadLen(fsNode *unixfs.FSNode) int {
+	return len(fmt.Sprintf("%X", fsNode.Fanout()-1))
+}
@@ -0,0 +1,207 @@
+package gw
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// CARParams are the trustless gateway parameters of a CAR request. The zero
+// value is a plain ?format=car request that leaves all choices to the
+// gateway.
+type CARParams struct {
+	// DAGScope and EntityBytes are sent as query parameters.
+	DAGScope    DAGScope
+	EntityBytes string // from:to, e.g., "0:1023", "-1024:*"
+
+	// Version, Order, and Dups are negotiated in the Accept header.
+	Version string // "1" or "2"
+	Order   string // "dfs" or "unk"
+	Dups    string // "y" or "n"
+}
+
+// ParseCARParams parses the parameters from a query string, e.g.,
+// "dag-scope=entity&entity-bytes=0:1023&order=dfs&dups=n".
+func ParseCARParams(s string) (CARParams, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return CARParams{}, fmt.Errorf("parsing car params %q: %w", s, err)
+	}
+
+	var p CARParams
+	for key := range values {
+		value := values.Get(key)
+		switch key {
+		case "dag-scope":
+			p.DAGScope = DAGScope(value)
+			if p.DAGScope != DAGScopeAll && p.DAGScope != DAGScopeEntity && p.DAGScope != DAGScopeBlock {
+				return CARParams{}, fmt.Errorf("invalid dag-scope %q", value)
+			}
+		case "entity-bytes":
+			if _, err := parseEntityBytes(value); err != nil {
+				return CARParams{}, err
+			}
+			p.EntityBytes = value
+		case "version":
+			if value != "1" && value != "2" {
+				return CARParams{}, fmt.Errorf("invalid car version %q", value)
+			}
+			p.Version = value
+		case "order":
+			if value != "dfs" && value != "unk" {
+				return CARParams{}, fmt.Errorf("invalid car order %q", value)
+			}
+			p.Order = value
+		case "dups":
+			if value != "y" && value != "n" {
+				return CARParams{}, fmt.Errorf("invalid car dups %q", value)
+			}
+			p.Dups = value
+		default:
+			return CARParams{}, fmt.Errorf("unknown car param %q", key)
+		}
+	}
+
+	if p.EntityBytes != "" && p.DAGScope != DAGScopeEntity {
+		return CARParams{}, fmt.Errorf("entity-bytes requires dag-scope=entity")
+	}
+
+	return p, nil
+}
+
+// String returns the parameters in the format that ParseCARParams accepts.
+func (p CARParams) String() string {
+	var parts []string
+	for _, kv := range [][2]string{
+		{"dag-scope", string(p.DAGScope)},
+		{"entity-bytes", p.EntityBytes},
+		{"version", p.Version},
+		{"order", p.Order},
+		{"dups", p.Dups},
+	} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+kv[1])
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// query returns the query parameters that are appended to ?format=car.
+func (p CARParams) query() string {
+	var query string
+	if p.DAGScope != "" {
+		query += "&dag-scope=" + string(p.DAGScope)
+	}
+	if p.EntityBytes != "" {
+		query += "&entity-bytes=" + p.EntityBytes
+	}
+	return query
+}
+
+// Accept returns the Accept header of the request.
+func (p CARParams) Accept() string {
+	accept := "application/vnd.ipld.car"
+	if p.Version != "" {
+		accept += "; version=" + p.Version
+	}
+	if p.Order != "" {
+		accept += "; order=" + p.Order
+	}
+	if p.Dups != "" {
+		accept += "; dups=" + p.Dups
+	}
+	return accept
+}
+
+// byteRange is a parsed entity-bytes parameter. Negative values are offsets
+// from the end of the file. A nil to means the end of the file.
+type byteRange struct {
+	from int64
+	to   *int64
+}
+
+func parseEntityBytes(s string) (*byteRange, error) {
+	fromStr, toStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid entity-bytes %q: expected from:to", s)
+	}
+
+	from, err := strconv.ParseInt(fromStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entity-bytes %q: %w", s, err)
+	}
+
+	br := &byteRange{from: from}
+	if toStr == "*" {
+		return br, nil
+	}
+
+	to, err := strconv.ParseInt(toStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entity-bytes %q: %w", s, err)
+	}
+	br.to = &to
+
+	return br, nil
+}
+
+// resolve returns the inclusive absolute range within a file of the given
+// size. It returns false if the range is empty.
+func (r *byteRange) resolve(size uint64) (uint64, uint64, bool) {
+	if size == 0 {
+		return 0, 0, false
+	}
+
+	from := r.from
+	if from < 0 {
+		from = max(int64(size)+from, 0)
+	}
+
+	to := int64(size) - 1
+	if r.to != nil && *r.to >= 0 {
+		to = min(*r.to, to)
+	} else if r.to != nil {
+		to = int64(size) + *r.to
+	}
+
+	if from > to || from >= int64(size) {
+		return 0, 0, false
+	}
+
+	return uint64(from), uint64(to), true
+}
+
+// Violations returns the parameters that the gateway ignored or mishandled
+// according to the Content-Type of its response and the CAR verification.
+func (p CARParams) Violations(contentType string, v *CARVerification) []string {
+	var violations []string
+
+	_, ctParams, _ := mime.ParseMediaType(contentType)
+
+	if p.DAGScope != "" && p.DAGScope != DAGScopeAll && v.UnexpectedBlocks > 0 {
+		violations = append(violations, "dag-scope")
+	}
+
+	// entity-bytes only applies to the entity scope
+	if p.DAGScope == DAGScopeEntity && p.EntityBytes != "" && v.OutOfRangeBlocks > 0 {
+		violations = append(violations, "entity-bytes")
+	}
+
+	if p.Version != "" {
+		if (ctParams["version"] != "" && ctParams["version"] != p.Version) || (v.Version != 0 && strconv.FormatUint(v.Version, 10) != p.Version) {
+			violations = append(violations, "version")
+		}
+	}
+
+	if p.Order == "dfs" && (ctParams["order"] != "dfs" || !v.DFSOrder) {
+		violations = append(violations, "order")
+	}
+
+	if p.Dups == "n" && (ctParams["dups"] == "y" || v.DuplicateBlocks > 0) {
+		violations = append(violations, "dups")
+	}
+
+	return violations
+}
@@ -0,0 +1,440 @@
+package gw
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	chunker "github.com/ipfs/boxo/chunker"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	mdtest "github.com/ipfs/boxo/ipld/merkledag/test"
+	"github.com/ipfs/boxo/ipld/unixfs/hamt"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/balanced"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/helpers"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBlock struct {
+	cid  cid.Cid
+	data []byte
+}
+
+// testDAG is a directory with a file of four chunks and a single block file:
+//
+//	dir
+//	├── file (file, leaf, leaf, leaf, leaf)
+//	└── small (leaf)
+type testDAG struct {
+	dir, file, small cid.Cid
+	blocks           []testBlock // in depth-first order
+}
+
+func newTestDAG(t *testing.T) *testDAG {
+	t.Helper()
+
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+
+	importFile := func(data []byte) ipld.Node {
+		params := helpers.DagBuilderParams{
+			Dagserv:    dserv,
+			Maxlinks:   helpers.DefaultLinksPerBlock,
+			RawLeaves:  true,
+			CidBuilder: merkledag.V1CidPrefix(),
+		}
+		db, err := params.New(chunker.NewSizeSplitter(bytes.NewReader(data), 256))
+		require.NoError(t, err)
+
+		nd, err := balanced.Layout(db)
+		require.NoError(t, err)
+
+		return nd
+	}
+
+	file := importFile(pkg.ControlledBlob(0, "test", 0, 1024))
+	small := importFile([]byte("small"))
+
+	dir, err := uio.NewBasicDirectory(dserv, uio.WithCidBuilder(merkledag.V1CidPrefix()))
+	require.NoError(t, err)
+	require.NoError(t, dir.AddChild(ctx, "file", file))
+	require.NoError(t, dir.AddChild(ctx, "small", small))
+
+	dirNode, err := dir.GetNode()
+	require.NoError(t, err)
+	require.NoError(t, dserv.Add(ctx, dirNode))
+
+	dag := &testDAG{dir: dirNode.Cid(), file: file.Cid(), small: small.Cid()}
+
+	var collect func(c cid.Cid)
+	collect = func(c cid.Cid) {
+		nd, err := dserv.Get(ctx, c)
+		require.NoError(t, err)
+
+		dag.blocks = append(dag.blocks, testBlock{cid: c, data: nd.RawData()})
+		for _, l := range nd.Links() {
+			collect(l.Cid)
+		}
+	}
+	collect(dag.dir)
+
+	require.Len(t, dag.blocks, 7)
+
+	return dag
+}
+
+func writeTestCAR(t *testing.T, root cid.Cid, blocks []testBlock) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	car, err := storage.NewWritable(buf, []cid.Cid{root}, carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(true))
+	require.NoError(t, err)
+
+	for _, b := range blocks {
+		require.NoError(t, car.Put(context.Background(), b.cid.KeyString(), b.data))
+	}
+
+	return buf
+}
+
+func TestVerifyCAR(t *testing.T) {
+	dag := newTestDAG(t)
+
+	parsePath := func(s string) pkg.ContentPath {
+		p, err := pkg.ParseContentPath(s)
+		require.NoError(t, err)
+		return p
+	}
+
+	corrupt := testBlock{cid: dag.blocks[2].cid, data: []byte("corrupt")}
+	unrelated := testBlock{cid: dag.small, data: []byte("small")} // raw leaf of the small file
+
+	tests := []struct {
+		name   string
+		root   cid.Cid
+		path   string
+		params CARParams
+		blocks []testBlock
+		want   CARVerification
+	}{
+		{
+			name:   "complete",
+			path:   "/ipfs/" + dag.dir.String(),
+			blocks: dag.blocks,
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 7, Complete: true},
+		},
+		{
+			name:   "missing",
+			path:   "/ipfs/" + dag.dir.String(),
+			blocks: append(dag.blocks[:2:2], dag.blocks[3:]...),
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 6, MissingBlocks: 1},
+		},
+		{
+			name:   "corrupt",
+			path:   "/ipfs/" + dag.dir.String(),
+			blocks: append(append(dag.blocks[:2:2], corrupt), dag.blocks[3:]...),
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 7, InvalidBlocks: 1, MissingBlocks: 1},
+		},
+		{
+			name:   "duplicate",
+			path:   "/ipfs/" + dag.dir.String(),
+			blocks: append(dag.blocks[:2:2], dag.blocks[1:]...),
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 8, DuplicateBlocks: 1, Complete: true},
+		},
+		{
+			name:   "block scope",
+			path:   "/ipfs/" + dag.dir.String(),
+			params: CARParams{DAGScope: DAGScopeBlock},
+			blocks: dag.blocks,
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 7, UnexpectedBlocks: 6, Complete: true},
+		},
+		{
+			name:   "entity scope of a directory",
+			path:   "/ipfs/" + dag.dir.String(),
+			params: CARParams{DAGScope: DAGScopeEntity},
+			blocks: dag.blocks[:1],
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 1, Complete: true},
+		},
+		{
+			name:   "entity scope of a file in a path",
+			path:   "/ipfs/" + dag.dir.String() + "/file",
+			params: CARParams{DAGScope: DAGScopeEntity},
+			blocks: append(dag.blocks[:6:6], unrelated),
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 7, UnexpectedBlocks: 1, Complete: true},
+		},
+		{
+			name:   "entity bytes from the start",
+			path:   "/ipfs/" + dag.dir.String() + "/file",
+			params: CARParams{DAGScope: DAGScopeEntity, EntityBytes: "0:255"},
+			blocks: append(dag.blocks[:3:3], dag.blocks[3:]...),
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 7, UnexpectedBlocks: 1, OutOfRangeBlocks: 3, Complete: true},
+		},
+		{
+			name:   "entity bytes across blocks",
+			path:   "/ipfs/" + dag.dir.String() + "/file",
+			params: CARParams{DAGScope: DAGScopeEntity, EntityBytes: "300:600"},
+			blocks: []testBlock{dag.blocks[0], dag.blocks[1], dag.blocks[3], dag.blocks[4]},
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 4, Complete: true},
+		},
+		{
+			name:   "entity bytes from the end",
+			path:   "/ipfs/" + dag.dir.String() + "/file",
+			params: CARParams{DAGScope: DAGScopeEntity, EntityBytes: "-256:*"},
+			blocks: []testBlock{dag.blocks[0], dag.blocks[1]},
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 2, MissingBlocks: 1},
+		},
+		{
+			name:   "unordered",
+			path:   "/ipfs/" + dag.dir.String(),
+			blocks: []testBlock{dag.blocks[0], dag.blocks[1], dag.blocks[5], dag.blocks[4], dag.blocks[3], dag.blocks[2], dag.blocks[6]},
+			want:   CARVerification{Version: 1, RootsValid: true, Blocks: 7, Complete: true},
+		},
+		{
+			name:   "unknown path",
+			path:   "/ipfs/" + dag.dir.String() + "/unknown",
+			blocks: dag.blocks,
+			want:   CARVerification{Version: 1, DFSOrder: true, RootsValid: true, Blocks: 7, UnexpectedBlocks: 6},
+		},
+		{
+			name:   "wrong root",
+			root:   dag.small,
+			path:   "/ipfs/" + dag.dir.String(),
+			blocks: dag.blocks,
+			want:   CARVerification{Version: 1, DFSOrder: true, Blocks: 7, Complete: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := tt.root
+			if !root.Defined() {
+				root = dag.dir
+			}
+
+			got := VerifyCAR(writeTestCAR(t, root, tt.blocks), parsePath(tt.path), tt.params, nil)
+
+			if tt.want.Complete || tt.want.MissingBlocks > 0 {
+				assert.NoError(t, got.Err)
+			} else {
+				assert.Error(t, got.Err)
+			}
+			got.Err = nil
+
+			assert.Equal(t, tt.want, *got)
+		})
+	}
+}
+
+func TestVerifyCAR_ipns(t *testing.T) {
+	dag := newTestDAG(t)
+	path := pkg.ContentPath{Namespace: "ipns", Root: "example.com", SubPath: "/small"}
+
+	got := VerifyCAR(writeTestCAR(t, dag.dir, []testBlock{dag.blocks[0], dag.blocks[6]}), path, CARParams{}, []cid.Cid{dag.dir, dag.small})
+	require.NoError(t, got.Err)
+	assert.True(t, got.RootsValid)
+	assert.True(t, got.Complete)
+	assert.Zero(t, got.UnexpectedBlocks)
+}
+
+func TestVerifyCAR_sharded(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+
+	small := merkledag.NewRawNode([]byte("small"))
+	require.NoError(t, dserv.Add(ctx, small))
+
+	// a small fanout, so that the buckets overflow into nested shards
+	shard, err := hamt.NewShard(dserv, 8)
+	require.NoError(t, err)
+	shard.SetCidBuilder(merkledag.V1CidPrefix())
+
+	names := make([]string, 64)
+	for i := range names {
+		names[i] = fmt.Sprintf("entry-%d", i)
+		require.NoError(t, shard.Set(ctx, names[i], small))
+	}
+
+	root, err := shard.Node()
+	require.NoError(t, err)
+
+	var shards []testBlock
+	var collect func(c cid.Cid)
+	collect = func(c cid.Cid) {
+		if c.Equals(small.Cid()) {
+			return
+		}
+		nd, err := dserv.Get(ctx, c)
+		require.NoError(t, err)
+		shards = append(shards, testBlock{cid: c, data: nd.RawData()})
+		for _, l := range nd.Links() {
+			collect(l.Cid)
+		}
+	}
+	collect(root.Cid())
+	require.Greater(t, len(shards), 1)
+
+	nested := 0
+	for _, name := range names {
+		path := pkg.ContentPath{Namespace: "ipfs", Root: root.Cid().String(), SubPath: "/" + name}
+		params := CARParams{DAGScope: DAGScopeBlock}
+
+		got := VerifyCAR(writeTestCAR(t, root.Cid(), append(shards, testBlock{cid: small.Cid(), data: small.RawData()})), path, params, nil)
+		require.NoError(t, got.Err, name)
+		assert.True(t, got.Complete, name)
+
+		// only the shards on the way to the entry are needed
+		w := &dagWalker{blocks: map[cid.Cid][]byte{}, visited: map[cid.Cid]bool{}}
+		for _, b := range shards {
+			w.blocks[b.cid] = b.data
+		}
+		terminal, err := w.resolve(root.Cid(), path.SubPath)
+		require.NoError(t, err)
+		assert.Equal(t, small.Cid(), terminal)
+		assert.EqualValues(t, len(shards)-len(w.order), got.UnexpectedBlocks, name)
+
+		if len(w.order) < 2 {
+			continue
+		}
+		nested++
+
+		// a missing shard on the way is reported, not skipped
+		sent := slices.DeleteFunc(slices.Clone(shards), func(b testBlock) bool { return b.cid.Equals(w.order[1]) })
+		got = VerifyCAR(writeTestCAR(t, root.Cid(), sent), path, params, nil)
+		assert.ErrorContains(t, got.Err, "missing", name)
+		assert.EqualValues(t, 1, got.MissingBlocks, name)
+		assert.False(t, got.Complete, name)
+	}
+	assert.Positive(t, nested)
+}
+
+func TestVerifyCAR_truncated(t *testing.T) {
+	dag := newTestDAG(t)
+	path := pkg.CIDPath(dag.dir)
+
+	buf := writeTestCAR(t, dag.dir, dag.blocks)
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-10])
+
+	got := VerifyCAR(truncated, path, CARParams{}, nil)
+	assert.Error(t, got.Err)
+	assert.True(t, got.RootsValid)
+	assert.False(t, got.Complete)
+	assert.EqualValues(t, 6, got.Blocks)
+	assert.EqualValues(t, 1, got.MissingBlocks)
+
+	got = VerifyCAR(bytes.NewReader([]byte("not a car")), path, CARParams{}, nil)
+	assert.Error(t, got.Err)
+	assert.False(t, got.RootsValid)
+	assert.False(t, got.Complete)
+}
+
+func TestParseCARParams(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    CARParams
+		wantErr bool
+	}{
+		{in: "", want: CARParams{}},
+		{in: "dag-scope=block", want: CARParams{DAGScope: DAGScopeBlock}},
+		{
+			in:   "dups=n&order=dfs&version=1&entity-bytes=-1024:*&dag-scope=entity",
+			want: CARParams{DAGScope: DAGScopeEntity, EntityBytes: "-1024:*", Version: "1", Order: "dfs", Dups: "n"},
+		},
+		{in: "dag-scope=everything", wantErr: true},
+		{in: "entity-bytes=0:10", wantErr: true},
+		{in: "dag-scope=entity&entity-bytes=10", wantErr: true},
+		{in: "dag-scope=entity&entity-bytes=a:b", wantErr: true},
+		{in: "version=3", wantErr: true},
+		{in: "order=bfs", wantErr: true},
+		{in: "dups=maybe", wantErr: true},
+		{in: "format=car", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseCARParams(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// the string form parses to the same parameters
+			again, err := ParseCARParams(got.String())
+			require.NoError(t, err)
+			assert.Equal(t, got, again)
+		})
+	}
+}
+
+func TestCARParams_Accept(t *testing.T) {
+	assert.Equal(t, "application/vnd.ipld.car", CARParams{DAGScope: DAGScopeBlock}.Accept())
+	assert.Equal(t, "application/vnd.ipld.car; version=1; order=dfs; dups=n", CARParams{Version: "1", Order: "dfs", Dups: "n"}.Accept())
+}
+
+func TestCARParams_Violations(t *testing.T) {
+	honored := &CARVerification{Version: 1, DFSOrder: true}
+	ignored := &CARVerification{Version: 1, UnexpectedBlocks: 2, OutOfRangeBlocks: 1, DuplicateBlocks: 1}
+
+	tests := []struct {
+		name        string
+		params      CARParams
+		contentType string
+		v           *CARVerification
+		want        []string
+	}{
+		{
+			name:        "no params",
+			contentType: "application/vnd.ipld.car; version=1",
+			v:           ignored,
+		},
+		{
+			name:        "honored",
+			params:      CARParams{DAGScope: DAGScopeEntity, EntityBytes: "0:10", Version: "1", Order: "dfs", Dups: "n"},
+			contentType: "application/vnd.ipld.car; version=1; order=dfs; dups=n",
+			v:           honored,
+		},
+		{
+			name:        "ignored",
+			params:      CARParams{DAGScope: DAGScopeEntity, EntityBytes: "0:10", Version: "2", Order: "dfs", Dups: "n"},
+			contentType: "application/vnd.ipld.car; version=1",
+			v:           ignored,
+			want:        []string{"dag-scope", "entity-bytes", "version", "order", "dups"},
+		},
+		{
+			name:        "range ignored",
+			params:      CARParams{DAGScope: DAGScopeEntity, EntityBytes: "0:10"},
+			contentType: "application/vnd.ipld.car; version=1",
+			v:           &CARVerification{Version: 1, OutOfRangeBlocks: 3},
+			want:        []string{"entity-bytes"},
+		},
+		{
+			name:        "range without entity scope",
+			params:      CARParams{DAGScope: DAGScopeBlock, EntityBytes: "0:10"},
+			contentType: "application/vnd.ipld.car; version=1",
+			v:           &CARVerification{Version: 1, UnexpectedBlocks: 3},
+			want:        []string{"dag-scope"},
+		},
+		{
+			name:        "mislabeled",
+			params:      CARParams{Order: "dfs", Dups: "n"},
+			contentType: "application/vnd.ipld.car; version=1; order=dfs; dups=y",
+			v:           &CARVerification{Version: 1},
+			want:        []string{"order", "dups"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.params.Violations(tt.contentType, tt.v))
+		})
+	}
+}
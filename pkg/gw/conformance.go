@@ -0,0 +1,182 @@
+package gw
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+)
+
+// ConformanceCheck is the outcome of checking a response against the path and
+// trustless gateway specs.
+type ConformanceCheck struct {
+	Name   string
+	Passed bool
+	Detail string // the header value or status code that was checked
+}
+
+// The names of the conformance checks.
+const (
+	ConformanceEtag               = "etag"
+	ConformanceCacheControl       = "cache-control"
+	ConformanceContentTypeOptions = "x-content-type-options"
+	ConformanceContentDisposition = "content-disposition"
+	ConformanceVaryAccept         = "vary-accept"
+	ConformanceInvalidCID         = "400-invalid-cid"
+	ConformanceUnsupportedAccept  = "406-unsupported-accept"
+)
+
+// unsupportedMediaType is sent in the Accept header of the 406 check. It's
+// in the namespace of the IPLD media types, so gateways can't mistake it for
+// a browser request that falls back to the deserialized response.
+const unsupportedMediaType = "application/vnd.ipld.unsupported"
+
+// checkConformance checks the headers of a successful response to the request.
+// Checks that don't apply to the request, e.g., Content-Disposition for the
+// none format, are left out.
+func checkConformance(req *Request, h http.Header) []ConformanceCheck {
+	var checks []ConformanceCheck
+	check := func(name string, passed bool, detail string) {
+		checks = append(checks, ConformanceCheck{Name: name, Passed: passed, Detail: detail})
+	}
+
+	// the Etag is a quoted, strong or weak, entity tag that contains the CID
+	// of the terminal element. Etags of IPNS records are their hash. The
+	// terminal element is unknown for paths that need resolution if the
+	// gateway didn't send X-Ipfs-Roots.
+	etag := h.Get("Etag")
+	etagValid := isEntityTag(etag)
+	if etagValid && req.Format != db.GatewayProbeFormatIPNSRecord {
+		var terminal string
+		if roots := parseRoots(h.Get("X-Ipfs-Roots")); len(roots) > 0 {
+			terminal = roots[len(roots)-1].String()
+		} else if req.Path.ResolutionType() == pkg.ResolutionTypeNone {
+			terminal = req.Path.Root
+		}
+		etagValid = terminal == "" || strings.Contains(etag, terminal)
+	}
+	check(ConformanceEtag, etagValid, etag)
+
+	// content under /ipfs/ never changes
+	if req.Path.Namespace == "ipfs" {
+		cc := h.Get("Cache-Control")
+		check(ConformanceCacheControl, hasDirectives(cc, "public", "max-age=29030400", "immutable"), cc)
+	}
+
+	// verifiable responses must not be sniffed and rendered by browsers
+	if req.Format != db.GatewayProbeFormatNone {
+		xcto := h.Get("X-Content-Type-Options")
+		check(ConformanceContentTypeOptions, strings.EqualFold(strings.TrimSpace(xcto), "nosniff"), xcto)
+	}
+
+	switch req.Format {
+	case db.GatewayProbeFormatRaw, db.GatewayProbeFormatCAR, db.GatewayProbeFormatTAR, db.GatewayProbeFormatIPNSRecord:
+		cd := h.Get("Content-Disposition")
+		check(ConformanceContentDisposition, strings.HasPrefix(strings.ToLower(strings.TrimSpace(cd)), "attachment"), cd)
+	}
+
+	// the formats can also be requested with the Accept header, so caches
+	// must keep the responses apart
+	if req.Format != db.GatewayProbeFormatNone {
+		vary := h.Values("Vary")
+		check(ConformanceVaryAccept, hasDirectives(strings.Join(vary, ","), "accept"), strings.Join(vary, ", "))
+	}
+
+	return checks
+}
+
+// negativeConformance sends requests that the gateway must reject: an invalid
+// CID with 400 Bad Request, and an Accept header of an unsupported media type
+// with 406 Not Acceptable. Requests that fail without a response are left
+// out.
+func (p *Prober) negativeConformance(ctx context.Context, req *Request) []ConformanceCheck {
+	var checks []ConformanceCheck
+
+	// the invalid CID can't be encoded in a subdomain
+	invalidReq := *req
+	invalidReq.Format = db.GatewayProbeFormatNone
+	invalidReq.URLStyle = URLStylePath
+	invalidReq.Path = pkg.ContentPath{Namespace: "ipfs", Root: "not-a-cid"}
+	invalidURL, err := invalidReq.URL()
+	if err == nil {
+		if status := p.conformanceRequest(ctx, &invalidReq, invalidURL, ""); status != 0 {
+			checks = append(checks, statusCheck(ConformanceInvalidCID, status, http.StatusBadRequest))
+		}
+	}
+
+	unsupportedReq := *req
+	unsupportedReq.Format = db.GatewayProbeFormatNone
+	unsupportedURL, err := unsupportedReq.URL()
+	if err == nil {
+		if status := p.conformanceRequest(ctx, &unsupportedReq, unsupportedURL, unsupportedMediaType); status != 0 {
+			checks = append(checks, statusCheck(ConformanceUnsupportedAccept, status, http.StatusNotAcceptable))
+		}
+	}
+
+	return checks
+}
+
+// conformanceRequest sends a GET request to the URL on a fresh connection and
+// returns the status code of the response, or 0 if it failed. The body is
+// never read.
+func (p *Prober) conformanceRequest(ctx context.Context, req *Request, url string, accept string) int {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	transport, err := p.newTransport(req)
+	if err != nil {
+		return 0
+	}
+	defer transport.Close()
+
+	httpReq, err := p.newHTTPRequest(ctx, req, url)
+	if err != nil {
+		return 0
+	}
+	if accept != "" {
+		httpReq.Header.Set("Accept", accept)
+	}
+
+	client := &http.Client{Transport: transport, Timeout: p.cfg.Timeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return 0
+	}
+	_ = resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func statusCheck(name string, status int, expected int) ConformanceCheck {
+	return ConformanceCheck{
+		Name:   name,
+		Passed: status == expected,
+		Detail: strconv.Itoa(status),
+	}
+}
+
+// isEntityTag reports whether s is a strong ("...") or weak (W/"...") entity
+// tag (RFC 9110, section 8.8.3).
+func isEntityTag(s string) bool {
+	s = strings.TrimPrefix(s, "W/")
+	return len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) && !strings.Contains(s[1:len(s)-1], `"`)
+}
+
+// hasDirectives reports whether the comma-separated header value contains all
+// directives, ignoring case.
+func hasDirectives(value string, directives ...string) bool {
+	present := map[string]bool{}
+	for _, d := range strings.Split(value, ",") {
+		present[strings.ToLower(strings.TrimSpace(d))] = true
+	}
+
+	for _, d := range directives {
+		if !present[d] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package gw
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber_Probe_conformance(t *testing.T) {
+	tests := []struct {
+		format db.GatewayProbeFormat
+		checks []string
+	}{
+		{format: db.GatewayProbeFormatNone, checks: []string{ConformanceEtag, ConformanceCacheControl}},
+		{format: db.GatewayProbeFormatRaw, checks: []string{ConformanceEtag, ConformanceCacheControl, ConformanceContentTypeOptions, ConformanceContentDisposition, ConformanceVaryAccept}},
+		{format: db.GatewayProbeFormatCAR, checks: []string{ConformanceEtag, ConformanceCacheControl, ConformanceContentTypeOptions, ConformanceContentDisposition, ConformanceVaryAccept}},
+		{format: db.GatewayProbeFormatDAGJSON, checks: []string{ConformanceEtag, ConformanceCacheControl, ConformanceContentTypeOptions, ConformanceVaryAccept}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			prober, gateway, path := newTestProber(t)
+
+			result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: tt.format})
+			require.NoError(t, result.Err)
+
+			var names []string
+			for _, c := range result.Conformance {
+				names = append(names, c.Name)
+				assert.True(t, c.Passed, c.Name)
+			}
+			assert.Equal(t, tt.checks, names)
+
+			gateway.NonConformant = true
+
+			result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: tt.format})
+			require.NoError(t, result.Err)
+			require.Len(t, result.Conformance, len(tt.checks))
+			for _, c := range result.Conformance {
+				assert.False(t, c.Passed, c.Name)
+			}
+
+			m := result.Model()
+			assert.Equal(t, tt.checks, m.ConformanceName)
+			assert.Equal(t, make([]bool, len(tt.checks)), m.ConformancePassed)
+		})
+	}
+}
+
+func TestProber_Probe_negativeConformance(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	req := &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone, NegativeChecks: true}
+
+	// the cold attempt doesn't check
+	result := prober.Probe(context.Background(), req)
+	require.NoError(t, result.Err)
+	assert.Len(t, gateway.Requests(), 1)
+	assert.Len(t, result.Conformance, 2)
+
+	req.Attempt = 1
+	result = prober.Probe(context.Background(), req)
+	require.NoError(t, result.Err)
+	require.Len(t, result.Conformance, 4)
+	assert.Equal(t, ConformanceCheck{Name: ConformanceInvalidCID, Passed: true, Detail: "400"}, result.Conformance[0])
+	assert.Equal(t, ConformanceCheck{Name: ConformanceUnsupportedAccept, Passed: true, Detail: "406"}, result.Conformance[1])
+
+	requests := gateway.Requests()
+	require.Len(t, requests, 4)
+	assert.Equal(t, "/ipfs/not-a-cid", requests[1].URL.Path)
+	assert.Equal(t, unsupportedMediaType, requests[2].Header.Get("Accept"))
+
+	gateway.NonConformant = true
+
+	result = prober.Probe(context.Background(), req)
+	require.NoError(t, result.Err)
+	require.Len(t, result.Conformance, 4)
+	assert.Equal(t, ConformanceCheck{Name: ConformanceInvalidCID, Passed: false, Detail: "404"}, result.Conformance[0])
+	assert.Equal(t, ConformanceCheck{Name: ConformanceUnsupportedAccept, Passed: false, Detail: "200"}, result.Conformance[1])
+}
+
+func TestCheckConformance_etag(t *testing.T) {
+	path, err := pkg.ParseContentPath("/ipfs/bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy")
+	require.NoError(t, err)
+	ipnsPath, err := pkg.ParseContentPath("/ipns/example.com")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		path   pkg.ContentPath
+		format db.GatewayProbeFormat
+		etag   string
+		roots  string
+		passed bool
+	}{
+		{name: "strong", path: path, etag: `"` + path.Root + `"`, passed: true},
+		{name: "weak", path: path, etag: `W/"` + path.Root + `"`, passed: true},
+		{name: "format suffix", path: path, format: db.GatewayProbeFormatRaw, etag: `"` + path.Root + `.raw"`, passed: true},
+		{name: "unquoted", path: path, etag: path.Root},
+		{name: "missing", path: path},
+		{name: "other cid", path: path, etag: `"bafkqaaa"`},
+		{name: "resolved root", path: ipnsPath, etag: `"` + path.Root + `"`, roots: path.Root, passed: true},
+		{name: "unknown root", path: ipnsPath, etag: `"bafkqaaa"`, passed: true},
+		{name: "ipns record", path: ipnsPath, format: db.GatewayProbeFormatIPNSRecord, etag: `"a1b2c3"`, roots: path.Root, passed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format := tt.format
+			if format == "" {
+				format = db.GatewayProbeFormatNone
+			}
+
+			h := http.Header{}
+			if tt.etag != "" {
+				h.Set("Etag", tt.etag)
+			}
+			if tt.roots != "" {
+				h.Set("X-Ipfs-Roots", tt.roots)
+			}
+
+			checks := checkConformance(&Request{Path: tt.path, Format: format}, h)
+			require.NotEmpty(t, checks)
+			assert.Equal(t, ConformanceCheck{Name: ConformanceEtag, Passed: tt.passed, Detail: tt.etag}, checks[0])
+		})
+	}
+}
+
+func TestHasDirectives(t *testing.T) {
+	assert.True(t, hasDirectives("public, max-age=29030400, immutable", "public", "max-age=29030400", "immutable"))
+	assert.True(t, hasDirectives("Immutable,Public,max-age=29030400", "public", "max-age=29030400", "immutable"))
+	assert.False(t, hasDirectives("public, max-age=3600, immutable", "public", "max-age=29030400", "immutable"))
+	assert.False(t, hasDirectives("", "accept"))
+	assert.True(t, hasDirectives("Accept-Encoding, Accept", "accept"))
+}
@@ -0,0 +1,133 @@
+package gw
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+)
+
+// Formats are all response formats a gateway can be probed with.
+var Formats = []db.GatewayProbeFormat{
+	db.GatewayProbeFormatNone,
+	db.GatewayProbeFormatRaw,
+	db.GatewayProbeFormatCAR,
+	db.GatewayProbeFormatTAR,
+	db.GatewayProbeFormatDAGJSON,
+	db.GatewayProbeFormatDAGCBOR,
+	db.GatewayProbeFormatIPNSRecord,
+}
+
+// accepts are the Accept headers of the formats. CAR requests add their
+// parameters to theirs.
+var accepts = map[db.GatewayProbeFormat]string{
+	db.GatewayProbeFormatRaw:        "application/vnd.ipld.raw",
+	db.GatewayProbeFormatCAR:        "application/vnd.ipld.car",
+	db.GatewayProbeFormatTAR:        "application/x-tar",
+	db.GatewayProbeFormatDAGJSON:    "application/vnd.ipld.dag-json",
+	db.GatewayProbeFormatDAGCBOR:    "application/vnd.ipld.dag-cbor",
+	db.GatewayProbeFormatIPNSRecord: "application/vnd.ipfs.ipns-record",
+}
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(s string) (db.GatewayProbeFormat, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q (must be one of %v)", s, Formats)
+}
+
+// FormatApplies reports whether the format can be requested for the path.
+// IPNS records only exist for /ipns/<key> paths.
+func FormatApplies(format db.GatewayProbeFormat, path pkg.ContentPath) bool {
+	if format != db.GatewayProbeFormatIPNSRecord {
+		return true
+	}
+	return path.ResolutionType() == pkg.ResolutionTypeIPNS && path.SubPath == ""
+}
+
+// validateBody checks that the response body is valid for the requested
+// format. roots are the CIDs the gateway reported in X-Ipfs-Roots. CAR
+// responses are verified separately by VerifyCAR.
+func validateBody(format db.GatewayProbeFormat, body []byte, path pkg.ContentPath, roots []cid.Cid) error {
+	switch format {
+	case db.GatewayProbeFormatRaw:
+		// the terminal element of a path is the last root
+		c := path.CID()
+		if path.SubPath != "" || !c.Defined() {
+			c = cid.Undef
+			if len(roots) > 0 {
+				c = roots[len(roots)-1]
+			}
+		}
+		if !c.Defined() {
+			return fmt.Errorf("no cid to verify the block against")
+		}
+
+		hashed, err := c.Prefix().Sum(body)
+		if err != nil {
+			return fmt.Errorf("hashing block: %w", err)
+		} else if !hashed.Equals(c) {
+			return fmt.Errorf("block doesn't hash to %s", c)
+		}
+
+	case db.GatewayProbeFormatDAGJSON:
+		if _, err := ipld.Decode(body, dagjson.Decode); err != nil {
+			return fmt.Errorf("decoding dag-json: %w", err)
+		}
+
+	case db.GatewayProbeFormatDAGCBOR:
+		if _, err := ipld.Decode(body, dagcbor.Decode); err != nil {
+			return fmt.Errorf("decoding dag-cbor: %w", err)
+		}
+
+	case db.GatewayProbeFormatTAR:
+		tr := tar.NewReader(bytes.NewReader(body))
+		entries := 0
+		for {
+			_, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading tar header: %w", err)
+			}
+			entries += 1
+
+			if _, err := io.Copy(io.Discard, tr); err != nil {
+				return fmt.Errorf("reading tar entry: %w", err)
+			}
+		}
+
+		if entries == 0 {
+			return fmt.Errorf("empty tar archive")
+		}
+
+	case db.GatewayProbeFormatIPNSRecord:
+		name, err := ipns.NameFromString(path.Root)
+		if err != nil {
+			return fmt.Errorf("parsing ipns name: %w", err)
+		}
+
+		rec, err := ipns.UnmarshalRecord(body)
+		if err != nil {
+			return fmt.Errorf("unmarshalling ipns record: %w", err)
+		}
+
+		if err := ipns.ValidateWithName(rec, name); err != nil {
+			return fmt.Errorf("validating ipns record: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package gwtest
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// DNS is a fake DNS server that resolves every A query to 127.0.0.1, so that
+// the wildcard host names of subdomain gateways (e.g.,
+// <cid>.ipfs.gateway.test) reach a Gateway. It answers over UDP and
+// DNS-over-HTTPS.
+type DNS struct {
+	addr   string
+	dohURL string
+
+	mu      sync.Mutex
+	queries []string
+}
+
+// NewDNS starts a fake DNS server that is shut down when the test finishes.
+func NewDNS(t testing.TB) *DNS {
+	t.Helper()
+
+	return NewDNSAt(t, "127.0.0.1")
+}
+
+// NewDNSAt starts a fake DNS server that listens on the given IP address for
+// both UDP and DNS-over-HTTPS, e.g., on another loopback address than the
+// Gateway, so that connections to the server can be told apart from those to
+// the gateway. It skips the test if the address can't be listened on.
+func NewDNSAt(t testing.TB, ip string) *DNS {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Skipf("listening on udp %s: %s", ip, err)
+	}
+
+	d := &DNS{addr: conn.LocalAddr().String()}
+
+	srv := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(d.serveDNS)}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+
+	l, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Skipf("listening on tcp %s: %s", ip, err)
+	}
+
+	doh := httptest.NewUnstartedServer(http.HandlerFunc(d.serveDoH))
+	_ = doh.Listener.Close()
+	doh.Listener = l
+	doh.Start()
+	t.Cleanup(doh.Close)
+	d.dohURL = doh.URL + "/dns-query"
+
+	return d
+}
+
+func (d *DNS) serveDNS(w dns.ResponseWriter, r *dns.Msg) {
+	_ = w.WriteMsg(d.answer(r))
+}
+
+func (d *DNS) serveDoH(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := new(dns.Msg)
+	if err := query.Unpack(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	packed, err := d.answer(query).Pack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/dns-message")
+	_, _ = w.Write(packed)
+}
+
+func (d *DNS) answer(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	for _, q := range r.Question {
+		d.mu.Lock()
+		d.queries = append(d.queries, strings.TrimSuffix(q.Name, "."))
+		d.mu.Unlock()
+
+		if q.Qtype != dns.TypeA {
+			continue
+		}
+
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(127, 0, 0, 1),
+		})
+	}
+
+	return m
+}
+
+// Addr returns the UDP address of the server.
+func (d *DNS) Addr() string {
+	return d.addr
+}
+
+// DoHURL returns the DNS-over-HTTPS URL of the server. It's served over plain
+// HTTP.
+func (d *DNS) DoHURL() string {
+	return d.dohURL
+}
+
+// Queries returns the names of all queries the server has received.
+func (d *DNS) Queries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queries := make([]string, len(d.queries))
+	copy(queries, d.queries)
+
+	return queries
+}
@@ -0,0 +1,629 @@
+// Package gwtest provides a fake trustless IPFS gateway for testing gateway
+// probes offline.
+package gwtest
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// formatMediaTypes are the media types of the formats that can also be
+// requested with the Accept header instead of the format query parameter.
+var formatMediaTypes = map[string]string{
+	"raw":         "application/vnd.ipld.raw",
+	"car":         "application/vnd.ipld.car",
+	"tar":         "application/x-tar",
+	"dag-json":    "application/vnd.ipld.dag-json",
+	"dag-cbor":    "application/vnd.ipld.dag-cbor",
+	"ipns-record": "application/vnd.ipfs.ipns-record",
+}
+
+// Gateway is a fake trustless gateway that serves raw blocks that were added
+// with Add. It serves /ipfs/<cid> and /ipns/<name> paths in the none, raw,
+// car, tar, dag-json, dag-cbor, and ipns-record formats. Sub paths aren't
+// supported. Requests to subdomain hosts (e.g., <cid>.ipfs.gateway.test) are
+// served like path requests; NewDNS resolves such hosts.
+//
+// The exported fields change the behavior of the gateway to simulate
+// misbehaving gateways. They must not be changed while a request is in
+// flight.
+type Gateway struct {
+	*httptest.Server
+
+	// Redirects is the number of redirects the gateway responds with before
+	// it serves the content.
+	Redirects int
+
+	// SubdomainRedirect makes the gateway redirect path requests to its
+	// subdomain, e.g., /ipfs/<cid> to <cidv1>.ipfs.<host>/.
+	SubdomainRedirect bool
+
+	// StatusCode, if set, is returned instead of the content.
+	StatusCode int
+
+	// BodyDelay delays the response body after the headers were sent.
+	BodyDelay time.Duration
+
+	// NoContentLength makes the gateway stream GET responses without a
+	// Content-Length header. HEAD responses still announce it.
+	NoContentLength bool
+
+	// StallAfter, if set, pauses the response body for StallDuration after
+	// the given number of bytes.
+	StallAfter    int
+	StallDuration time.Duration
+
+	// TruncateAfter, if set, closes the connection after the given number of
+	// body bytes although the Content-Length header announced the full body.
+	TruncateAfter int
+
+	// InvalidCAR makes the gateway respond to CAR requests with bytes that
+	// can't be parsed as a CAR.
+	InvalidCAR bool
+
+	// CorruptCAR makes the gateway send block data in CAR responses that
+	// doesn't match the CID of the block.
+	CorruptCAR bool
+
+	// IgnoreCARParams makes the gateway ignore the order and dups parameters
+	// of the Accept header. It responds with order=unk and dups=y and sends
+	// every block twice.
+	IgnoreCARParams bool
+
+	// CorruptBody makes the gateway drop the last byte of the response body
+	// of all formats but car.
+	CorruptBody bool
+
+	// Cache makes the gateway behave like it has a cache: the X-Cache header
+	// is MISS for the first request of a content path in a format and HIT
+	// for all later ones. Requests with Cache-Control: only-if-cached are
+	// answered with 504 Gateway Timeout if nothing is cached.
+	Cache bool
+
+	// NonConformant makes the gateway omit the Etag, Cache-Control,
+	// X-Content-Type-Options, Content-Disposition, and Vary headers of the
+	// path and trustless gateway specs, and serve invalid CIDs and
+	// unsupported Accept headers like content that wasn't found.
+	NonConformant bool
+
+	mu       sync.Mutex
+	blocks   map[string][]byte  // keyed by multihash
+	names    map[string]cid.Cid // ipns names and dnslink domains
+	records  map[string][]byte  // ipns records by name
+	cached   map[string]bool    // content path and format
+	requests []*http.Request
+}
+
+// New starts a plain HTTP/1.1 fake gateway that is closed when the test
+// finishes.
+func New(t testing.TB) *Gateway {
+	t.Helper()
+
+	g := newGateway()
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serveHTTP))
+	t.Cleanup(g.Close)
+
+	return g
+}
+
+// NewTLS starts a fake gateway that serves HTTP/1.1 and HTTP/2 over TLS and
+// HTTP/3 over QUIC on the same port. Clients must trust the certificate in
+// TLSClientConfig.
+func NewTLS(t testing.TB) *Gateway {
+	t.Helper()
+
+	g := newGateway()
+	g.Server = httptest.NewUnstartedServer(http.HandlerFunc(g.serveHTTP))
+	g.EnableHTTP2 = true
+	g.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	g.StartTLS()
+	t.Cleanup(g.Close)
+
+	addr := g.Listener.Addr().(*net.TCPAddr)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: addr.IP, Port: addr.Port})
+	if err != nil {
+		t.Fatalf("listening on udp port %d: %s", addr.Port, err)
+	}
+
+	h3 := &http3.Server{
+		Handler:   http.HandlerFunc(g.serveHTTP),
+		TLSConfig: http3.ConfigureTLSConfig(g.TLS.Clone()),
+	}
+	go func() { _ = h3.Serve(conn) }()
+
+	t.Cleanup(func() {
+		_ = h3.Close()
+		_ = conn.Close()
+	})
+
+	return g
+}
+
+func newGateway() *Gateway {
+	return &Gateway{
+		blocks:  map[string][]byte{},
+		names:   map[string]cid.Cid{},
+		records: map[string][]byte{},
+		cached:  map[string]bool{},
+	}
+}
+
+// TLSClientConfig returns a client configuration that trusts the certificate
+// of a gateway that was started with NewTLS.
+func (g *Gateway) TLSClientConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(g.Certificate())
+
+	return &tls.Config{RootCAs: pool}
+}
+
+// Add stores the data as a raw block and returns its CIDv1.
+func (g *Gateway) Add(data []byte) cid.Cid {
+	hash, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		panic(err) // sha2-256 is always supported
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.blocks[string(hash)] = data
+
+	return cid.NewCidV1(cid.Raw, hash)
+}
+
+// Publish makes the given CID available under /ipns/<name>.
+func (g *Gateway) Publish(name string, c cid.Cid) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.names[name] = c
+}
+
+// PublishRecord creates a signed IPNS record for the given CID with a new key
+// and makes the CID available under /ipns/<name>.
+func (g *Gateway) PublishRecord(c cid.Cid) (ipns.Name, error) {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return ipns.Name{}, fmt.Errorf("generating key: %w", err)
+	}
+
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return ipns.Name{}, fmt.Errorf("deriving peer id: %w", err)
+	}
+	name := ipns.NameFromPeer(pid)
+
+	rec, err := ipns.NewRecord(sk, path.FromCid(c), 1, time.Now().Add(time.Hour), time.Minute)
+	if err != nil {
+		return ipns.Name{}, fmt.Errorf("creating ipns record: %w", err)
+	}
+
+	data, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return ipns.Name{}, fmt.Errorf("marshalling ipns record: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.names[name.String()] = c
+	g.records[name.String()] = data
+
+	return name, nil
+}
+
+// Requests returns all requests the gateway has received, including
+// redirected ones.
+func (g *Gateway) Requests() []*http.Request {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	requests := make([]*http.Request, len(g.requests))
+	copy(requests, g.requests)
+
+	return requests
+}
+
+func (g *Gateway) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	g.requests = append(g.requests, r.Clone(context.Background()))
+	g.mu.Unlock()
+
+	q := r.URL.Query()
+	if hop, _ := strconv.Atoi(q.Get("hop")); hop < g.Redirects {
+		q.Set("hop", strconv.Itoa(hop+1))
+		http.Redirect(w, r, r.URL.Path+"?"+q.Encode(), http.StatusFound)
+		return
+	}
+
+	urlPath := r.URL.Path
+	if ns, root, ok := subdomain(r.Host); ok {
+		urlPath = "/" + ns + "/" + root + strings.TrimSuffix(r.URL.Path, "/")
+	} else if g.SubdomainRedirect {
+		target, err := subdomainURL(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	c, err := g.resolve(urlPath)
+	if errors.Is(err, errInvalidCID) && !g.NonConformant {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	g.mu.Lock()
+	data, found := g.blocks[string(c.Hash())]
+	g.mu.Unlock()
+
+	if !found {
+		http.Error(w, "block not found", http.StatusNotFound)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		for f, mediaType := range formatMediaTypes {
+			if strings.Contains(r.Header.Get("Accept"), mediaType) {
+				format = f
+			}
+		}
+	}
+
+	// other IPLD media types are explicit requests for a format that isn't
+	// supported rather than browser requests
+	if format == "" && strings.HasPrefix(r.Header.Get("Accept"), "application/vnd.ipld.") && !g.NonConformant {
+		http.Error(w, "unsupported media type", http.StatusNotAcceptable)
+		return
+	}
+
+	if g.Cache {
+		key := urlPath + "?format=" + format
+
+		g.mu.Lock()
+		hit := g.cached[key]
+		if !strings.Contains(r.Header.Get("Cache-Control"), "only-if-cached") {
+			g.cached[key] = true
+		}
+		g.mu.Unlock()
+
+		if !hit && strings.Contains(r.Header.Get("Cache-Control"), "only-if-cached") {
+			http.Error(w, "not cached", http.StatusGatewayTimeout)
+			return
+		} else if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	}
+
+	var body []byte
+	switch format {
+	case "":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		body = data
+	case "raw":
+		w.Header().Set("Content-Type", "application/vnd.ipld.raw")
+		body = data
+	case "car":
+		w.Header().Set("Content-Type", g.carContentType(r.Header.Get("Accept")))
+		body, err = g.car(r.Context(), c, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "tar":
+		w.Header().Set("Content-Type", "application/x-tar")
+		body, err = tarFile(c.String(), data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "dag-json", "dag-cbor":
+		// raw blocks are converted to a bytes node
+		encode := dagjson.Encode
+		if format == "dag-cbor" {
+			encode = dagcbor.Encode
+		}
+		w.Header().Set("Content-Type", formatMediaTypes[format])
+		body, err = ipld.Encode(basicnode.NewBytes(data), encode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "ipns-record":
+		g.mu.Lock()
+		record, found := g.records[strings.TrimPrefix(urlPath, "/ipns/")]
+		g.mu.Unlock()
+
+		if !found {
+			http.Error(w, "ipns record not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", formatMediaTypes[format])
+		body = record
+	default:
+		http.Error(w, fmt.Sprintf("unsupported format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	if g.CorruptBody && format != "car" && len(body) > 0 {
+		body = body[:len(body)-1]
+	}
+
+	w.Header().Set("X-Ipfs-Path", urlPath)
+	w.Header().Set("X-Ipfs-Roots", c.String())
+	if !g.NonConformant {
+		g.setSpecHeaders(w.Header(), urlPath, c, format)
+	}
+	if !g.NoContentLength || r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	if g.StatusCode != 0 {
+		http.Error(w, http.StatusText(g.StatusCode), g.StatusCode)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if g.BodyDelay > 0 {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(g.BodyDelay):
+		}
+	}
+
+	if g.TruncateAfter > 0 && g.TruncateAfter < len(body) {
+		// the server closes the connection because fewer bytes than announced
+		// in the Content-Length header were written.
+		body = body[:g.TruncateAfter]
+	}
+
+	if g.StallAfter > 0 && g.StallAfter < len(body) {
+		_, _ = w.Write(body[:g.StallAfter])
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(g.StallDuration):
+		}
+
+		body = body[g.StallAfter:]
+	}
+
+	_, _ = w.Write(body)
+}
+
+// setSpecHeaders sets the headers the path and trustless gateway specs
+// require for a response in the format.
+func (g *Gateway) setSpecHeaders(h http.Header, urlPath string, c cid.Cid, format string) {
+	etag := c.String()
+	if format != "" {
+		etag += "." + format
+	}
+	h.Set("Etag", `"`+etag+`"`)
+
+	if strings.HasPrefix(urlPath, "/ipfs/") {
+		h.Set("Cache-Control", "public, max-age=29030400, immutable")
+	}
+
+	if format == "" {
+		return
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Vary", "Accept")
+
+	ext := map[string]string{"raw": "bin", "car": "car", "tar": "tar", "ipns-record": "ipns-record"}[format]
+	if ext != "" {
+		h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, c, ext))
+	}
+}
+
+// errInvalidCID is returned by resolve for /ipfs/ paths with an invalid CID.
+var errInvalidCID = errors.New("invalid cid")
+
+func (g *Gateway) resolve(path string) (cid.Cid, error) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(parts) < 2 {
+		return cid.Undef, fmt.Errorf("invalid path %q", path)
+	} else if len(parts) == 3 && parts[2] != "" {
+		return cid.Undef, fmt.Errorf("sub paths aren't supported")
+	}
+
+	switch parts[0] {
+	case "ipfs":
+		c, err := cid.Decode(parts[1])
+		if err != nil {
+			return cid.Undef, fmt.Errorf("%w: %s", errInvalidCID, err)
+		}
+		return c, nil
+	case "ipns":
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		c, found := g.names[parts[1]]
+		if !found {
+			return cid.Undef, fmt.Errorf("name %q not found", parts[1])
+		}
+		return c, nil
+	default:
+		return cid.Undef, fmt.Errorf("unsupported namespace %q", parts[0])
+	}
+}
+
+func (g *Gateway) car(ctx context.Context, c cid.Cid, data []byte) ([]byte, error) {
+	if g.InvalidCAR {
+		return []byte("this is not a CAR"), nil
+	}
+
+	if g.CorruptCAR {
+		data = append([]byte("corrupt"), data...)
+	}
+
+	var buf bytes.Buffer
+	car, err := storage.NewWritable(&buf, []cid.Cid{c}, carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(true))
+	if err != nil {
+		return nil, fmt.Errorf("creating car: %w", err)
+	}
+
+	puts := 1
+	if g.IgnoreCARParams {
+		puts = 2
+	}
+
+	for range puts {
+		if err := car.Put(ctx, c.KeyString(), data); err != nil {
+			return nil, fmt.Errorf("writing block to car: %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// carContentType negotiates the CAR parameters of the response like a
+// trustless gateway that only supports CARv1: the requested order is
+// honored, unknown orders default to dfs, and duplicates are never sent.
+func (g *Gateway) carContentType(accept string) string {
+	if g.IgnoreCARParams {
+		return "application/vnd.ipld.car; version=1; order=unk; dups=y"
+	}
+
+	order := "dfs"
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil || mediaType != "application/vnd.ipld.car" {
+			continue
+		}
+		if params["order"] == "unk" {
+			order = "unk"
+		}
+	}
+
+	return "application/vnd.ipld.car; version=1; order=" + order + "; dups=n"
+}
+
+// subdomain returns the namespace and root of a subdomain gateway host, e.g.,
+// "ipfs" and the CID of <cid>.ipfs.gateway.test.
+func subdomain(hostport string) (string, string, bool) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+
+	labels := strings.SplitN(host, ".", 3)
+	if len(labels) < 3 || (labels[1] != "ipfs" && labels[1] != "ipns") {
+		return "", "", false
+	}
+
+	root := labels[0]
+	if labels[1] == "ipns" && strings.Contains(root, "-") {
+		// DNSLink domains encode dots as dashes and dashes as double dashes
+		root = strings.ReplaceAll(root, "--", "\x00")
+		root = strings.ReplaceAll(root, "-", ".")
+		root = strings.ReplaceAll(root, "\x00", "-")
+	}
+
+	return labels[1], root, true
+}
+
+// subdomainURL returns the URL of a path request on the subdomain gateway of
+// the same host.
+func subdomainURL(r *http.Request) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 {
+		return "", fmt.Errorf("invalid path %q", r.URL.Path)
+	}
+
+	label := parts[1]
+	switch parts[0] {
+	case "ipfs":
+		c, err := cid.Decode(parts[1])
+		if err != nil {
+			return "", fmt.Errorf("parsing cid: %w", err)
+		}
+		label = cid.NewCidV1(c.Type(), c.Hash()).String()
+	case "ipns":
+		label = strings.ReplaceAll(strings.ReplaceAll(label, "-", "--"), ".", "-")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	target := scheme + "://" + label + "." + parts[0] + "." + r.Host + "/"
+	if len(parts) == 3 {
+		target += parts[2]
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	return target, nil
+}
+
+// tarFile returns a tar archive with a single file.
+func tarFile(name string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, fmt.Errorf("writing tar header: %w", err)
+	}
+
+	if _, err := tw.Write(data); err != nil {
+		return nil, fmt.Errorf("writing tar file: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("closing tar archive: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,60 @@
+package gw
+
+import (
+	"fmt"
+	"net"
+)
+
+// IPFamily is the IP version a probe is restricted to. The zero value allows
+// both, and the dialer falls back from one to the other (happy eyeballs).
+type IPFamily string
+
+const (
+	IPFamily4 IPFamily = "4"
+	IPFamily6 IPFamily = "6"
+)
+
+// IPFamilies are all IP families a probe can be restricted to.
+var IPFamilies = []IPFamily{IPFamily4, IPFamily6}
+
+// ParseIPFamily parses the IP family as it's given on the command line. The
+// empty string doesn't restrict the family.
+func ParseIPFamily(s string) (IPFamily, error) {
+	if s == "" {
+		return "", nil
+	}
+	for _, f := range IPFamilies {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown ip family %q (supported: %v)", s, IPFamilies)
+}
+
+// network returns the network for the dialer or resolver, e.g., "tcp" turns
+// into "tcp6" for IPFamily6.
+func (f IPFamily) network(network string) string {
+	return network + string(f)
+}
+
+// ipFamilyOf returns the family of the IP address, or "" if it isn't one.
+func ipFamilyOf(ip string) IPFamily {
+	addr := net.ParseIP(ip)
+	switch {
+	case addr == nil:
+		return ""
+	case addr.To4() != nil:
+		return IPFamily4
+	default:
+		return IPFamily6
+	}
+}
+
+// hostOf strips the port from a host:port address.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,50 @@
+package gw
+
+import (
+	"context"
+	"testing"
+
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber_Probe_ipFamily(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone})
+	require.NoError(t, result.Err)
+
+	m := result.Model()
+	assert.Equal(t, ptr.From("127.0.0.1"), m.RemoteIP)
+	assert.Equal(t, ptr.From("4"), m.IPFamily)
+	assert.Nil(t, m.ForcedIPFamily)
+	assert.Equal(t, ptr.From(false), m.ConnReused)
+	assert.Equal(t, int32(1), m.ConnAttempts)
+
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone, IPFamily: IPFamily4})
+	require.NoError(t, result.Err)
+	assert.Equal(t, ptr.From("4"), result.Model().ForcedIPFamily)
+
+	// the gateway only listens on an IPv4 address
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone, IPFamily: IPFamily6})
+	require.Error(t, result.Err)
+
+	m = result.Model()
+	assert.Equal(t, ptr.From("6"), m.ForcedIPFamily)
+	assert.Nil(t, m.RemoteIP)
+	assert.Nil(t, m.IPFamily)
+	assert.Nil(t, m.ConnReused)
+}
+
+func TestParseIPFamily(t *testing.T) {
+	for _, s := range []string{"", "4", "6"} {
+		f, err := ParseIPFamily(s)
+		require.NoError(t, err)
+		assert.Equal(t, s, string(f))
+	}
+
+	_, err := ParseIPFamily("ipv4")
+	assert.Error(t, err)
+}
@@ -0,0 +1,983 @@
+// Package gw implements the HTTP gateway probe. A Prober requests a content
+// path from an IPFS gateway, traces the timings of the request, and validates
+// the response.
+package gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// ProberConfig configures a Prober.
+type ProberConfig struct {
+	// Timeout is the maximum duration of a single probe including the
+	// download of the response body.
+	Timeout time.Duration
+
+	// MaxBytes is the number of response bytes after which the download is
+	// stopped.
+	MaxBytes int64
+
+	// UserAgent identifies tiros to gateway operators.
+	UserAgent string
+
+	// TLSClientConfig is used for TLS and QUIC handshakes. If nil, the
+	// default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// Resolver resolves the gateway host names. If nil, the system resolver
+	// is used.
+	Resolver *Resolver
+
+	// StallThreshold is the minimum gap in the arrival of body bytes that
+	// counts as a stall. Zero doesn't count stalls.
+	StallThreshold time.Duration
+}
+
+// DefaultProberConfig returns the default configuration of a Prober.
+func DefaultProberConfig() *ProberConfig {
+	return &ProberConfig{
+		Timeout:        30 * time.Second,
+		MaxBytes:       10 * 1024 * 1024,
+		UserAgent:      "Tiros",
+		StallThreshold: time.Second,
+	}
+}
+
+// Request is a single content path that should be requested from a gateway.
+type Request struct {
+	// Gateway is the host of the gateway (e.g., "ipfs.io") or its base URL
+	// (e.g., "http://127.0.0.1:8080"). Hosts default to https.
+	Gateway string
+	Path    pkg.ContentPath
+	Format  db.GatewayProbeFormat
+
+	// CAR holds the trustless gateway parameters of CAR requests. Parameters
+	// that are empty are omitted, and the gateway picks its defaults.
+	CAR CARParams
+
+	// Protocol is the HTTP version the probe is restricted to. It defaults
+	// to HTTP/1.1.
+	Protocol Protocol
+
+	// URLStyle is how the content path is encoded in the URL. It defaults to
+	// path style.
+	URLStyle URLStyle
+
+	// IPFamily restricts the probe to IPv4 or IPv6. By default, both are
+	// allowed.
+	IPFamily IPFamily
+
+	// AuthKey is an optional shared secret that is sent in the Tiros-Auth
+	// header so that trusted gateways can distinguish tiros probes from
+	// arbitrary clients (e.g., to bypass rate limits or bot protection).
+	// AuthScheme can send it as a bearer token instead.
+	AuthKey    string
+	AuthScheme AuthScheme
+
+	// Headers are additional request headers. They can't override the
+	// headers of the probe itself (e.g., Accept).
+	Headers map[string]string
+
+	// Attempt is the index of the probe of the same request in the cache
+	// experiment. The first attempt is expected to find the gateway's cache
+	// cold, all later ones warm.
+	Attempt int
+
+	// Primed marks a request whose content path was already requested from
+	// the gateway in another variant (e.g., format or protocol). Its first
+	// attempt is expected to find the cache primed rather than cold, and
+	// isn't pre-checked.
+	Primed bool
+
+	// CachePrecheck makes the first attempt ask the gateway with
+	// Cache-Control: only-if-cached whether the content is already cached
+	// before it's probed.
+	CachePrecheck bool
+
+	// SizePrecheck learns the expected size of the response body before the
+	// probe. It's only made for warm attempts, so that it doesn't warm up
+	// the cache for the cold one.
+	SizePrecheck SizePrecheck
+
+	// NegativeChecks makes the warm attempts of the none format check that
+	// the gateway rejects an invalid CID with 400 and an unsupported Accept
+	// header with 406 before the probe.
+	NegativeChecks bool
+}
+
+// URL returns the URL that is requested from the gateway.
+func (r *Request) URL() (string, error) {
+	base := r.Gateway
+	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		base = "https://" + base
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	var query string
+	switch {
+	case r.Format == db.GatewayProbeFormatNone:
+	case r.Format == db.GatewayProbeFormatCAR:
+		query = "?format=car" + r.CAR.query()
+	case accepts[r.Format] != "":
+		query = "?format=" + string(r.Format)
+	default:
+		return "", fmt.Errorf("unknown gateway probe format: %s", r.Format)
+	}
+
+	if r.URLStyle.orDefault() == URLStylePath {
+		return base + r.Path.String() + query, nil
+	}
+
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("parsing gateway url: %w", err)
+	} else if net.ParseIP(u.Hostname()) != nil {
+		return "", fmt.Errorf("subdomain requests need a gateway host name, not an ip address")
+	}
+
+	label, err := subdomainLabel(r.Path)
+	if err != nil {
+		return "", err
+	}
+
+	subPath := r.Path.SubPath
+	if subPath == "" {
+		subPath = "/"
+	}
+
+	return u.Scheme + "://" + label + "." + r.Path.Namespace + "." + u.Host + subPath + query, nil
+}
+
+// Result is the outcome of a single gateway probe. If Err is set, the probe
+// failed, but all measurements up to the failure are still populated.
+type Result struct {
+	Request *Request
+	URL     string
+
+	// NegotiatedProtocol is the protocol of the final response (e.g.,
+	// "HTTP/2.0"), and ALPN is the application protocol that was negotiated
+	// in the last TLS handshake (e.g., "h2"). ALPN is empty for plain HTTP.
+	NegotiatedProtocol string
+	ALPN               string
+
+	RequestStart time.Time
+	DNSDuration  time.Duration
+	ConnDuration time.Duration
+	TLSDuration  time.Duration
+	TTFB         time.Duration
+	DownloadEnd  time.Time
+
+	// Resolver is the name of the resolver that resolved the gateway, and
+	// ResolvedAddrs are the addresses of its last lookup.
+	Resolver      string
+	ResolvedAddrs []string
+
+	BytesReceived int64
+	StatusCode    int
+	Headers       http.Header
+
+	// Timeline is the arrival of the body bytes. It's only set if the body
+	// was read.
+	Timeline *Timeline
+
+	// Truncated reports whether the download was stopped at the maximum
+	// number of bytes before the body ended. The body isn't validated then.
+	Truncated bool
+
+	// ExpectedSize is the size of the complete body, and ExpectedSizeSource
+	// where it was learned: the Content-Length of the response, or the size
+	// pre-check.
+	ExpectedSize       *int64
+	ExpectedSizeSource string
+
+	// Hops are all requests of the probe in order. The first hop is the
+	// initial request, and the last hop is the request that was redirected
+	// to FinalURL. The DNS, connection, TLS, and TTFB durations above are the
+	// ones of the last hop that measured them.
+	Hops          []Hop
+	RedirectCount int
+	FinalURL      string
+
+	// CARValidated is only set for successful CAR requests and reports whether
+	// the CAR roots contain the requested CID.
+	CARValidated *bool
+
+	// CAR is only set for successful CAR requests and holds the result of
+	// verifying the blocks that were received.
+	CAR *CARVerification
+
+	// FormatValid is only set for successful requests of formats other than
+	// none and reports whether the body is valid for the format, e.g.,
+	// whether a raw block hashes to its CID or an IPNS record's signature
+	// checks out. CAR responses are valid if they are complete and their
+	// roots are valid.
+	FormatValid *bool
+	FormatErr   error
+
+	// Accept is the Accept header that was sent.
+	Accept string
+
+	// TLS is the connection and certificate of the last TLS handshake. It's
+	// nil for plain HTTP.
+	TLS *TLSInfo
+
+	// ParamViolations are the CAR parameters of the request that the gateway
+	// ignored or mishandled (e.g., "dag-scope", "order"). Only set for
+	// successful CAR requests.
+	ParamViolations []string
+
+	// CachePrecheckStatus and CachePrecheckHit are the outcome of the
+	// only-if-cached pre-check. They're only set if the pre-check was made
+	// and got a response.
+	CachePrecheckStatus *int32
+	CachePrecheckHit    *bool
+
+	// Conformance are the outcomes of checking the response against the path
+	// and trustless gateway specs. The headers are only checked for 200
+	// responses.
+	Conformance []ConformanceCheck
+
+	// SkippedReason is set if the probe wasn't made, e.g., because the
+	// circuit breaker of the gateway is open.
+	SkippedReason string
+
+	Err error
+}
+
+// Hop is a single request of a probe's redirect chain. Durations that weren't
+// measured, e.g., because the connection of the previous hop was reused, are 0.
+type Hop struct {
+	URL          string
+	StatusCode   int
+	Start        time.Time
+	DNSDuration  time.Duration
+	ConnDuration time.Duration // since the first dial attempt
+	TLSDuration  time.Duration
+	TTFB         time.Duration // since Start
+
+	// RemoteIP is the address of the connection the request was sent on,
+	// and ConnReused reports whether that connection was used before.
+	// ConnAttempts counts the dial attempts, e.g., 2 if the dialer fell back
+	// from IPv6 to IPv4.
+	RemoteIP     string
+	ConnReused   bool
+	ConnAttempts int
+}
+
+// Host returns the host name of the hop's URL without the port.
+func (h *Hop) Host() string {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// IPFamily returns the IP family of the hop's connection, or "" if no
+// connection was established.
+func (h *Hop) IPFamily() IPFamily {
+	return ipFamilyOf(h.RemoteIP)
+}
+
+// Prober probes gateways. It's safe for concurrent use.
+type Prober struct {
+	cfg *ProberConfig
+}
+
+func NewProber(cfg *ProberConfig) *Prober {
+	if cfg == nil {
+		cfg = DefaultProberConfig()
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = SystemResolver()
+	}
+	return &Prober{cfg: cfg}
+}
+
+// Skipped returns the result of a probe that wasn't made for the given
+// reason. It is stored like any other probe, so that gateway outages can be
+// derived from the data.
+func Skipped(req *Request, reason string) *Result {
+	now := time.Now()
+	result := &Result{
+		Request:       req,
+		RequestStart:  now,
+		DownloadEnd:   now,
+		SkippedReason: reason,
+	}
+	result.URL, _ = req.URL()
+	return result
+}
+
+// GatewayFailed reports whether the probe failed because of the gateway
+// rather than the content: the request didn't get a response, or the gateway
+// responded with a server error or rate limited the probe. 502 Bad Gateway
+// and 504 Gateway Timeout mean that the gateway couldn't retrieve the content
+// from the network, which says nothing about its health.
+func (r *Result) GatewayFailed() bool {
+	if r.SkippedReason != "" || r.Err == nil {
+		return false
+	}
+
+	switch r.StatusCode {
+	case 0, http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return false
+	default:
+		return r.StatusCode >= 500
+	}
+}
+
+// Probe requests the content path from the gateway. Every probe uses a fresh
+// connection, so that DNS, connection, and TLS timings are measured every
+// time.
+func (p *Prober) Probe(ctx context.Context, req *Request) *Result {
+	result := &Result{
+		Request:      req,
+		RequestStart: time.Now(),
+		Resolver:     p.cfg.Resolver.String(),
+	}
+
+	url, err := req.URL()
+	if err != nil {
+		result.Err = err
+		result.DownloadEnd = time.Now()
+		return result
+	}
+	result.URL = url
+
+	if req.CachePrecheck && req.ExpectedCacheState() == CacheCold {
+		result.CachePrecheckStatus, result.CachePrecheckHit = p.precheck(ctx, req, url)
+		result.RequestStart = time.Now()
+	}
+
+	if req.SizePrecheck != "" && req.ExpectedCacheState() == CacheWarm {
+		result.ExpectedSize, result.ExpectedSizeSource = p.sizePrecheck(ctx, req, url)
+		result.RequestStart = time.Now()
+	}
+
+	if req.NegativeChecks && req.Format == db.GatewayProbeFormatNone && req.ExpectedCacheState() == CacheWarm {
+		result.Conformance = p.negativeConformance(ctx, req)
+		result.RequestStart = time.Now()
+	}
+
+	// Create request context with timeout
+	reqCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	// The trace is shared by all requests of the redirect chain. Every
+	// request starts a new hop, and the timings are attributed to it.
+	// Callbacks of dials that outlive the probe are ignored.
+	var (
+		mu                            sync.Mutex
+		done                          bool
+		dnsStart, connStart, tlsStart time.Time
+	)
+
+	withHop := func(fn func(h *Hop)) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !done && len(result.Hops) > 0 {
+			fn(&result.Hops[len(result.Hops)-1])
+		}
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(_ httptrace.DNSStartInfo) {
+			withHop(func(*Hop) { dnsStart = time.Now() })
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			withHop(func(h *Hop) {
+				h.DNSDuration = time.Since(dnsStart)
+				result.DNSDuration = h.DNSDuration
+				result.ResolvedAddrs = result.ResolvedAddrs[:0]
+				for _, addr := range info.Addrs {
+					result.ResolvedAddrs = append(result.ResolvedAddrs, addr.String())
+				}
+			})
+		},
+		ConnectStart: func(_, _ string) {
+			withHop(func(h *Hop) {
+				// happy eyeballs dials several addresses
+				if h.ConnAttempts == 0 {
+					connStart = time.Now()
+				}
+				h.ConnAttempts += 1
+			})
+		},
+		ConnectDone: func(_, addr string, err error) {
+			withHop(func(h *Hop) {
+				// ignore attempts that are canceled after another one succeeded
+				if h.RemoteIP != "" {
+					return
+				}
+				h.ConnDuration = time.Since(connStart)
+				result.ConnDuration = h.ConnDuration
+				if err == nil {
+					h.RemoteIP = hostOf(addr)
+				}
+			})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			withHop(func(h *Hop) {
+				h.ConnReused = info.Reused
+				if info.Conn != nil {
+					h.RemoteIP = hostOf(info.Conn.RemoteAddr().String())
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			withHop(func(*Hop) { tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			withHop(func(h *Hop) {
+				h.TLSDuration = time.Since(tlsStart)
+				result.TLSDuration = h.TLSDuration
+				result.ALPN = state.NegotiatedProtocol
+				if info := newTLSInfo(h.Host(), state, err); info != nil {
+					result.TLS = info
+				}
+			})
+		},
+		GotFirstResponseByte: func() {
+			withHop(func(h *Hop) {
+				h.TTFB = time.Since(h.Start)
+				result.TTFB = time.Since(result.RequestStart)
+			})
+		},
+	}
+
+	reqCtx = httptrace.WithClientTrace(reqCtx, trace)
+
+	transport, err := p.newTransport(req)
+	if err != nil {
+		result.Err = err
+		result.DownloadEnd = time.Now()
+		return result
+	}
+	defer transport.Close()
+
+	client := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			mu.Lock()
+			result.Hops = append(result.Hops, Hop{URL: r.URL.String(), Start: time.Now()})
+			mu.Unlock()
+
+			resp, err := transport.RoundTrip(r)
+			if resp != nil {
+				withHop(func(h *Hop) { h.StatusCode = resp.StatusCode })
+			}
+
+			return resp, err
+		}),
+		Timeout: p.cfg.Timeout,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			result.RedirectCount = len(via)
+			// Allow up to 10 redirects (Go's default)
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+
+	httpReq, err := p.newHTTPRequest(reqCtx, req, url)
+	if err != nil {
+		result.Err = err
+		result.DownloadEnd = time.Now()
+		return result
+	}
+	result.Accept = httpReq.Header.Get("Accept")
+
+	resp, err := client.Do(httpReq)
+
+	mu.Lock()
+	done = true
+	mu.Unlock()
+
+	if err != nil {
+		result.Err = fmt.Errorf("executing request: %w", err)
+		result.DownloadEnd = time.Now()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Headers = resp.Header
+	result.NegotiatedProtocol = resp.Proto
+
+	// Store final URL (may differ from initial if redirected)
+	if resp.Request != nil && resp.Request.URL != nil {
+		result.FinalURL = resp.Request.URL.String()
+	}
+
+	if resp.StatusCode >= 400 {
+		result.Err = fmt.Errorf("HTTP %d", resp.StatusCode)
+		result.DownloadEnd = time.Now()
+		return result
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		result.Conformance = append(result.Conformance, checkConformance(req, resp.Header)...)
+	}
+
+	if cl := result.ContentLength(); cl != nil {
+		result.ExpectedSize, result.ExpectedSizeSource = cl, SizeSourceContentLength
+	}
+
+	// Read response body up to MaxBytes. Only responses of explicit formats
+	// are buffered because they're validated afterward.
+	var (
+		buf bytes.Buffer
+		dst = io.Discard
+	)
+	if req.Format != db.GatewayProbeFormatNone {
+		dst = &buf
+	}
+
+	body := newTimelineReader(io.LimitReader(resp.Body, p.cfg.MaxBytes))
+	bytesRead, err := io.Copy(dst, body)
+	result.BytesReceived = bytesRead
+	result.DownloadEnd = time.Now()
+	result.Timeline = &Timeline{Samples: body.samples, StallThreshold: p.cfg.StallThreshold}
+
+	if err != nil {
+		result.Err = fmt.Errorf("reading response: %w", err)
+		return result
+	}
+
+	// the limit cut the body off if it announced more bytes or still has
+	// some
+	if bytesRead == p.cfg.MaxBytes {
+		if result.ExpectedSize != nil {
+			result.Truncated = *result.ExpectedSize > bytesRead
+		} else {
+			n, _ := resp.Body.Read(make([]byte, 1))
+			result.Truncated = n > 0
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return result
+	}
+
+	roots := parseRoots(resp.Header.Get("X-Ipfs-Roots"))
+	switch {
+	case req.Format == db.GatewayProbeFormatNone:
+	case req.Format == db.GatewayProbeFormatCAR:
+		result.CAR = VerifyCAR(&buf, req.Path, req.CAR, roots)
+		result.CARValidated = ptr.From(result.CAR.RootsValid)
+		result.ParamViolations = req.CAR.Violations(resp.Header.Get("Content-Type"), result.CAR)
+		result.FormatValid = ptr.From(result.CAR.RootsValid && result.CAR.Complete)
+
+		// a truncated CAR is incomplete, and its roots are unknown if the
+		// header was cut off, too
+		if result.Truncated {
+			result.FormatValid = nil
+			if result.CAR.Version == 0 {
+				result.CARValidated = nil
+			}
+		}
+	case result.Truncated:
+		// the bodies of other formats can only be validated as a whole
+	default:
+		result.FormatErr = validateBody(req.Format, buf.Bytes(), req.Path, roots)
+		result.FormatValid = ptr.From(result.FormatErr == nil)
+	}
+
+	return result
+}
+
+// newHTTPRequest returns the GET request of the probe with all its headers.
+func (p *Prober) newHTTPRequest(ctx context.Context, req *Request, url string) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	for key, value := range req.Headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	// Identify tiros to gateway operators so they can correlate traffic in logs.
+	if p.cfg.UserAgent != "" {
+		httpReq.Header.Set("User-Agent", p.cfg.UserAgent)
+	}
+
+	if req.AuthKey != "" {
+		switch req.AuthScheme.orDefault() {
+		case AuthSchemeTiros:
+			httpReq.Header.Set("Tiros-Auth", req.AuthKey)
+		case AuthSchemeBearer:
+			httpReq.Header.Set("Authorization", "Bearer "+req.AuthKey)
+		}
+	}
+
+	accept := accepts[req.Format]
+	if req.Format == db.GatewayProbeFormatCAR {
+		accept = req.CAR.Accept()
+	}
+
+	if accept != "" {
+		httpReq.Header.Set("Accept", accept)
+	}
+
+	return httpReq, nil
+}
+
+// newTransport returns a fresh transport that only speaks the protocol and
+// dials the IP family of the request, so that every probe dials a new
+// connection.
+func (p *Prober) newTransport(req *Request) (transport, error) {
+	protocol := req.Protocol
+
+	tlsConfig := &tls.Config{}
+	if p.cfg.TLSClientConfig != nil {
+		tlsConfig = p.cfg.TLSClientConfig.Clone()
+	}
+
+	if protocol == ProtocolHTTP3 {
+		return &http3.Transport{
+			TLSClientConfig: tlsConfig,
+			QUICConfig: &quic.Config{
+				HandshakeIdleTimeout: 15 * time.Second,
+			},
+			Dial: p.dialQUIC(req.IPFamily),
+		}, nil
+	}
+
+	protocols := new(http.Protocols)
+	switch protocol.orDefault() {
+	case ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+		// offer http/1.1 explicitly, so that the negotiated ALPN is recorded
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	case ProtocolHTTP2:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unknown protocol: %s", protocol)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   15 * time.Second,
+		KeepAlive: 15 * time.Second,
+	}
+
+	return &httpTransport{&http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return p.cfg.Resolver.dialContext(ctx, dialer, req.IPFamily, network, addr)
+		},
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+		Protocols:             protocols,
+	}}, nil
+}
+
+// dialQUIC returns a dialer for http3.Transport that resolves the host with
+// the configured resolver, restricted to the given IP family, and reports the
+// lookup, connection, and handshake to the client trace.
+func (p *Prober) dialQUIC(family IPFamily) func(context.Context, string, *tls.Config, *quic.Config) (*quic.Conn, error) {
+	return func(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		ips, err := p.cfg.Resolver.resolve(ctx, family, host)
+		if err != nil {
+			return nil, err
+		} else if len(ips) == 0 {
+			return nil, fmt.Errorf("no addresses for %s", host)
+		}
+		resolved := net.JoinHostPort(ips[0].Unmap().String(), port)
+
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.ConnectStart != nil {
+			trace.ConnectStart("udp", resolved)
+		}
+		if trace != nil && trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+
+		conn, err := quic.DialAddrEarly(ctx, resolved, tlsConfig, quicConfig)
+
+		var state tls.ConnectionState
+		if conn != nil {
+			state = conn.ConnectionState().TLS
+		}
+		if trace != nil && trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(state, err)
+		}
+		if trace != nil && trace.ConnectDone != nil {
+			trace.ConnectDone("udp", resolved, err)
+		}
+
+		return conn, err
+	}
+}
+
+type transport interface {
+	http.RoundTripper
+	io.Closer
+}
+
+// httpTransport closes the idle connections of an http.Transport on Close.
+type httpTransport struct {
+	*http.Transport
+}
+
+func (t *httpTransport) Close() error {
+	t.CloseIdleConnections()
+	return nil
+}
+
+// parseRoots parses the comma-separated CIDs of the X-Ipfs-Roots header.
+func parseRoots(ipfsRoots string) []cid.Cid {
+	var roots []cid.Cid
+	for _, s := range strings.Split(ipfsRoots, ",") {
+		if c, err := cid.Decode(strings.TrimSpace(s)); err == nil {
+			roots = append(roots, c)
+		}
+	}
+	return roots
+}
+
+// DownloadDuration is the time from the start of the request until the
+// download finished or was aborted.
+func (r *Result) DownloadDuration() time.Duration {
+	return r.DownloadEnd.Sub(r.RequestStart)
+}
+
+// DownloadSpeedMbps returns the download speed in megabits per second or nil
+// if nothing was downloaded.
+func (r *Result) DownloadSpeedMbps() *float64 {
+	durationS := r.DownloadDuration().Seconds()
+	if r.BytesReceived <= 0 || durationS <= 0 {
+		return nil
+	}
+
+	speedBps := float64(r.BytesReceived) / durationS
+	return ptr.From((speedBps * 8) / (1024 * 1024))
+}
+
+// ContentLength returns the value of the Content-Length header if present.
+func (r *Result) ContentLength() *int64 {
+	cl := r.Headers.Get("Content-Length")
+	if cl == "" {
+		return nil
+	}
+
+	val, err := strconv.ParseInt(cl, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	return &val
+}
+
+// SubdomainHop returns the first hop to a subdomain gateway host, e.g.,
+// <cidv1>.ipfs.dweb.link, or nil if the probe never reached one. For path
+// style requests, it is the hop after the gateway redirected to its subdomain.
+func (r *Result) SubdomainHop() *Hop {
+	for i, hop := range r.Hops {
+		u, err := url.Parse(hop.URL)
+		if err == nil && isSubdomainHost(u.Hostname()) {
+			return &r.Hops[i]
+		}
+	}
+	return nil
+}
+
+// CacheStatus returns the normalized cache status of the gateway's CDN.
+func (r *Result) CacheStatus() *string {
+	return cacheStatus(r.Headers)
+}
+
+// Model converts the result into a database model. The caller is expected to
+// fill in the run and CID metadata.
+func (r *Result) Model() *db.GatewayProbeModel {
+	m := &db.GatewayProbeModel{
+		Gateway:           r.Request.Gateway,
+		ContentPath:       r.Request.Path.String(),
+		ResolutionType:    string(r.Request.Path.ResolutionType()),
+		Format:            string(r.Request.Format),
+		Protocol:          string(r.Request.Protocol.orDefault()),
+		URLStyle:          string(r.Request.URLStyle.orDefault()),
+		NegotiatedProto:   r.NegotiatedProtocol,
+		ALPN:              r.ALPN,
+		RequestStart:      r.RequestStart,
+		DNSDurationS:      toPtr(r.DNSDuration.Seconds()),
+		ConnDurationS:     toPtr(r.ConnDuration.Seconds()),
+		TLSDurationS:      toPtr(r.TLSDuration.Seconds()),
+		TTFBS:             toPtr(r.TTFB.Seconds()),
+		DownloadDurationS: r.DownloadDuration().Seconds(),
+		BytesReceived:     r.BytesReceived,
+		ContentLength:     r.ContentLength(),
+		DownloadSpeedMbps: r.DownloadSpeedMbps(),
+		StatusCode:        r.StatusCode,
+		CacheStatus:       r.CacheStatus(),
+		CARValidated:      r.CARValidated,
+		FormatValid:       r.FormatValid,
+		DAGScope:          string(r.Request.CAR.DAGScope),
+		EntityBytes:       r.Request.CAR.EntityBytes,
+		Accept:            r.Accept,
+		ParamViolations:   r.ParamViolations,
+		RedirectCount:     r.RedirectCount,
+		FinalURL:          toPtr(r.FinalURL),
+		SkippedReason:     toPtr(r.SkippedReason),
+		CreatedAt:         time.Now(),
+	}
+
+	for _, hop := range r.Hops {
+		m.RedirectChainURL = append(m.RedirectChainURL, hop.URL)
+		m.RedirectChainStatusCode = append(m.RedirectChainStatusCode, int32(hop.StatusCode))
+		m.RedirectChainStartS = append(m.RedirectChainStartS, hop.Start.Sub(r.RequestStart).Seconds())
+		m.RedirectChainDNSDurationS = append(m.RedirectChainDNSDurationS, hop.DNSDuration.Seconds())
+		m.RedirectChainConnDurationS = append(m.RedirectChainConnDurationS, hop.ConnDuration.Seconds())
+		m.RedirectChainTLSDurationS = append(m.RedirectChainTLSDurationS, hop.TLSDuration.Seconds())
+		m.RedirectChainTTFBS = append(m.RedirectChainTTFBS, hop.TTFB.Seconds())
+		m.RedirectChainRemoteIP = append(m.RedirectChainRemoteIP, hop.RemoteIP)
+		m.RedirectChainConnReused = append(m.RedirectChainConnReused, hop.ConnReused)
+		m.RedirectChainConnAttempts = append(m.RedirectChainConnAttempts, int32(hop.ConnAttempts))
+	}
+
+	for _, c := range r.Conformance {
+		m.ConformanceName = append(m.ConformanceName, c.Name)
+		m.ConformancePassed = append(m.ConformancePassed, c.Passed)
+		m.ConformanceDetail = append(m.ConformanceDetail, c.Detail)
+	}
+
+	m.Truncated = r.Truncated
+	m.ExpectedSize = r.ExpectedSize
+	m.ExpectedSizeSource = toPtr(r.ExpectedSizeSource)
+
+	m.DNSResolver = toPtr(r.Resolver)
+	m.ResolvedAddrs = r.ResolvedAddrs
+
+	m.ForcedIPFamily = toPtr(string(r.Request.IPFamily))
+	if len(r.Hops) > 0 {
+		hop := r.Hops[len(r.Hops)-1]
+		m.ConnAttempts = int32(hop.ConnAttempts)
+		if hop.RemoteIP != "" {
+			m.RemoteIP = &hop.RemoteIP
+			m.IPFamily = toPtr(string(hop.IPFamily()))
+			m.ConnReused = &hop.ConnReused
+		}
+	}
+
+	if hop := r.SubdomainHop(); hop != nil {
+		m.SubdomainRedirect = r.Request.URLStyle.orDefault() == URLStylePath
+		m.SubdomainDNSDurationS = toPtr(hop.DNSDuration.Seconds())
+		m.SubdomainTLSDurationS = toPtr(hop.TLSDuration.Seconds())
+	}
+
+	if r.Timeline != nil {
+		total := r.BytesReceived
+		if r.ExpectedSize != nil {
+			total = *r.ExpectedSize
+		}
+		m.TimeTo25PctS = secondsPtr(r.Timeline.TimeTo(r.RequestStart, 0.25, total))
+		m.TimeTo50PctS = secondsPtr(r.Timeline.TimeTo(r.RequestStart, 0.5, total))
+		m.TimeTo100PctS = secondsPtr(r.Timeline.TimeTo(r.RequestStart, 1, total))
+		m.TransferSpeedMbps = r.Timeline.TransferSpeedMbps()
+
+		longest, stalls := r.Timeline.Stalls()
+		m.LongestStallS = ptr.From(longest.Seconds())
+		m.StallCount = int32(stalls)
+	}
+
+	if r.CAR != nil {
+		m.CARBlocks = &r.CAR.Blocks
+		m.CARInvalidBlocks = &r.CAR.InvalidBlocks
+		m.CARDuplicateBlocks = &r.CAR.DuplicateBlocks
+		m.CARUnexpectedBlocks = &r.CAR.UnexpectedBlocks
+		m.CAROutOfRangeBlocks = &r.CAR.OutOfRangeBlocks
+		m.CARMissingBlocks = &r.CAR.MissingBlocks
+		m.CARComplete = &r.CAR.Complete
+		m.CARVersion = ptr.From(int64(r.CAR.Version))
+		m.CARDFSOrder = &r.CAR.DFSOrder
+		if r.CAR.Err != nil {
+			m.CARError = ptr.From(r.CAR.Err.Error())
+		}
+	}
+
+	if v := r.Headers.Get("X-Ipfs-Path"); v != "" {
+		m.IPFSPath = &v
+	}
+
+	if v := r.Headers.Get("X-Ipfs-Roots"); v != "" {
+		m.IPFSRoots = &v
+		m.ResolvedRoot = pkg.ParseResolvedRoot(v)
+	}
+
+	if v := r.Headers.Get("Content-Type"); v != "" {
+		m.ContentType = &v
+		if _, params, err := mime.ParseMediaType(v); err == nil && len(params) > 0 {
+			m.ContentTypeParams = params
+		}
+	}
+
+	if r.FormatErr != nil {
+		m.FormatError = ptr.From(r.FormatErr.Error())
+	}
+
+	m.AttemptIndex = r.Request.Attempt
+	m.ExpectedCacheState = string(r.Request.ExpectedCacheState())
+	m.CachePrecheckStatus = r.CachePrecheckStatus
+	m.CachePrecheckHit = r.CachePrecheckHit
+	m.CacheExpectationMet = r.CacheExpectationMet()
+
+	if r.Err != nil {
+		m.Error = ptr.From(r.Err.Error())
+	}
+
+	return m
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// secondsPtr converts an optional duration to seconds.
+func secondsPtr(d *time.Duration) *float64 {
+	if d == nil {
+		return nil
+	}
+	return ptr.From(d.Seconds())
+}
+
+// toPtr returns nil for zero values, so that unmeasured durations (e.g., the
+// DNS lookup of an IP address) are stored as NULL.
+func toPtr[T comparable](t T) *T {
+	if t == *new(T) {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,479 @@
+package gw
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/probe-lab/tiros/pkg/gw/gwtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProber(t *testing.T) (*Prober, *gwtest.Gateway, pkg.ContentPath) {
+	t.Helper()
+
+	gateway := gwtest.New(t)
+	c := gateway.Add(bytes.Repeat([]byte("tiros"), 1000))
+
+	return NewProber(&ProberConfig{
+		Timeout:   5 * time.Second,
+		MaxBytes:  1 << 20,
+		UserAgent: "Tiros",
+	}), gateway, pkg.CIDPath(c)
+}
+
+func TestProber_Probe(t *testing.T) {
+	tests := []struct {
+		format      db.GatewayProbeFormat
+		contentType string
+		validated   *bool
+	}{
+		{format: db.GatewayProbeFormatNone, contentType: "application/octet-stream"},
+		{format: db.GatewayProbeFormatRaw, contentType: "application/vnd.ipld.raw"},
+		{format: db.GatewayProbeFormatCAR, contentType: "application/vnd.ipld.car; version=1; order=dfs; dups=n", validated: ptr.From(true)},
+		{format: db.GatewayProbeFormatTAR, contentType: "application/x-tar"},
+		{format: db.GatewayProbeFormatDAGJSON, contentType: "application/vnd.ipld.dag-json"},
+		{format: db.GatewayProbeFormatDAGCBOR, contentType: "application/vnd.ipld.dag-cbor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			prober, gateway, path := newTestProber(t)
+
+			result := prober.Probe(context.Background(), &Request{
+				Gateway: gateway.URL,
+				Path:    path,
+				Format:  tt.format,
+				AuthKey: "secret",
+			})
+			require.NoError(t, result.Err)
+
+			assert.Equal(t, 200, result.StatusCode)
+			assert.Equal(t, tt.validated, result.CARValidated)
+			if tt.format == db.GatewayProbeFormatNone {
+				assert.Nil(t, result.FormatValid)
+			} else {
+				assert.Equal(t, ptr.From(true), result.FormatValid)
+			}
+			if tt.format == db.GatewayProbeFormatCAR {
+				require.NotNil(t, result.CAR)
+				assert.True(t, result.CAR.Complete)
+				assert.EqualValues(t, 1, result.CAR.Blocks)
+			}
+			assert.Positive(t, result.TTFB)
+			assert.Equal(t, *result.ContentLength(), result.BytesReceived)
+			assert.NotNil(t, result.DownloadSpeedMbps())
+
+			requests := gateway.Requests()
+			require.Len(t, requests, 1)
+			assert.Equal(t, "Tiros", requests[0].Header.Get("User-Agent"))
+			assert.Equal(t, "secret", requests[0].Header.Get("Tiros-Auth"))
+			assert.Equal(t, accepts[tt.format], requests[0].Header.Get("Accept"))
+
+			m := result.Model()
+			assert.Equal(t, path.String(), m.ContentPath)
+			assert.Equal(t, string(tt.format), m.Format)
+			assert.Equal(t, tt.contentType, *m.ContentType)
+			assert.Equal(t, path.String(), *m.IPFSPath)
+			assert.Equal(t, path.Root, *m.ResolvedRoot)
+			assert.Nil(t, m.Error)
+		})
+	}
+}
+
+func TestProber_Probe_ipns(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	gateway.Publish("example.com", path.CID())
+
+	result := prober.Probe(context.Background(), &Request{
+		Gateway: gateway.URL,
+		Path:    pkg.ContentPath{Namespace: "ipns", Root: "example.com"},
+		Format:  db.GatewayProbeFormatCAR,
+	})
+	require.NoError(t, result.Err)
+
+	// the CAR root is validated against the resolved root in X-Ipfs-Roots
+	assert.Equal(t, ptr.From(true), result.CARValidated)
+
+	m := result.Model()
+	assert.Equal(t, string(pkg.ResolutionTypeDNSLink), m.ResolutionType)
+	assert.Equal(t, path.Root, *m.ResolvedRoot)
+}
+
+func TestProber_Probe_ipnsRecord(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+
+	name, err := gateway.PublishRecord(path.CID())
+	require.NoError(t, err)
+
+	ipnsPath := pkg.ContentPath{Namespace: "ipns", Root: name.String()}
+	require.True(t, FormatApplies(db.GatewayProbeFormatIPNSRecord, ipnsPath))
+	require.False(t, FormatApplies(db.GatewayProbeFormatIPNSRecord, path))
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: ipnsPath, Format: db.GatewayProbeFormatIPNSRecord})
+	require.NoError(t, result.Err)
+	assert.Equal(t, ptr.From(true), result.FormatValid)
+	assert.Equal(t, "application/vnd.ipfs.ipns-record", gateway.Requests()[0].Header.Get("Accept"))
+
+	gateway.CorruptBody = true
+
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: ipnsPath, Format: db.GatewayProbeFormatIPNSRecord})
+	require.NoError(t, result.Err)
+	assert.Equal(t, ptr.From(false), result.FormatValid)
+	assert.NotNil(t, result.Model().FormatError)
+}
+
+func TestProber_Probe_corruptBody(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	gateway.CorruptBody = true
+
+	for _, format := range []db.GatewayProbeFormat{db.GatewayProbeFormatRaw, db.GatewayProbeFormatDAGJSON, db.GatewayProbeFormatDAGCBOR} {
+		result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: format})
+		require.NoError(t, result.Err)
+		assert.Equal(t, ptr.From(false), result.FormatValid, format)
+		assert.Error(t, result.FormatErr, format)
+		assert.Equal(t, ptr.From(false), result.Model().FormatValid, format)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range Formats {
+		got, err := ParseFormat(string(format))
+		require.NoError(t, err)
+		assert.Equal(t, format, got)
+	}
+
+	_, err := ParseFormat("zip")
+	assert.Error(t, err)
+}
+
+func TestRequest_URL(t *testing.T) {
+	emptyDir, err := pkg.ParseContentPath("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn/sub/path")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		req     Request
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "path",
+			req:  Request{Gateway: "ipfs.io", Path: emptyDir, Format: db.GatewayProbeFormatRaw},
+			want: "https://ipfs.io/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn/sub/path?format=raw",
+		},
+		{
+			name: "subdomain",
+			req:  Request{Gateway: "https://dweb.link/", Path: emptyDir, Format: db.GatewayProbeFormatRaw, URLStyle: URLStyleSubdomain},
+			want: "https://bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354.ipfs.dweb.link/sub/path?format=raw",
+		},
+		{
+			name: "subdomain dnslink",
+			req:  Request{Gateway: "dweb.link", Path: pkg.ContentPath{Namespace: "ipns", Root: "en.wikipedia-on-ipfs.org"}, Format: db.GatewayProbeFormatNone, URLStyle: URLStyleSubdomain},
+			want: "https://en-wikipedia--on--ipfs-org.ipns.dweb.link/",
+		},
+		{
+			name:    "subdomain of an ip address",
+			req:     Request{Gateway: "http://127.0.0.1:8080", Path: emptyDir, Format: db.GatewayProbeFormatNone, URLStyle: URLStyleSubdomain},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.req.URL()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestProber_Probe_subdomain(t *testing.T) {
+	resolver := gwtest.NewDNS(t)
+	prober, gateway, path := newTestProber(t)
+	prober.cfg.Resolver, _ = ParseResolver("udp://"+resolver.Addr(), false)
+
+	// the fake DNS server resolves every host to the gateway's address
+	gatewayURL := strings.Replace(gateway.URL, "127.0.0.1", "gateway.test", 1)
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gatewayURL, Path: path, Format: db.GatewayProbeFormatRaw, URLStyle: URLStyleSubdomain})
+	require.NoError(t, result.Err)
+	assert.Equal(t, ptr.From(true), result.FormatValid)
+	assert.Equal(t, 0, result.RedirectCount)
+
+	label, err := subdomainLabel(path)
+	require.NoError(t, err)
+	assert.Contains(t, resolver.Queries(), label+".ipfs.gateway.test")
+
+	m := result.Model()
+	assert.Equal(t, "subdomain", m.URLStyle)
+	assert.False(t, m.SubdomainRedirect)
+	assert.NotNil(t, m.SubdomainDNSDurationS)
+
+	// path requests that the gateway redirects to its subdomain
+	gateway.SubdomainRedirect = true
+
+	result = prober.Probe(context.Background(), &Request{Gateway: gatewayURL, Path: path, Format: db.GatewayProbeFormatRaw})
+	require.NoError(t, result.Err)
+	assert.Equal(t, 1, result.RedirectCount)
+	require.NotNil(t, result.SubdomainHop())
+	assert.Equal(t, &result.Hops[1], result.SubdomainHop())
+
+	m = result.Model()
+	assert.Equal(t, "path", m.URLStyle)
+	assert.True(t, m.SubdomainRedirect)
+	assert.NotNil(t, m.SubdomainDNSDurationS)
+	assert.Equal(t, ptr.From(true), m.FormatValid)
+}
+
+func TestProber_Probe_redirects(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	gateway.Redirects = 3
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone})
+	require.NoError(t, result.Err)
+
+	assert.Equal(t, 3, result.RedirectCount)
+	assert.Contains(t, result.FinalURL, "hop=3")
+
+	// the initial request, three redirects
+	require.Len(t, result.Hops, 4)
+	for i, hop := range result.Hops[:3] {
+		assert.Equal(t, 302, hop.StatusCode)
+		assert.Positive(t, hop.TTFB)
+		assert.True(t, i == 0 || hop.Start.After(result.Hops[i-1].Start))
+	}
+	assert.Equal(t, 200, result.Hops[3].StatusCode)
+	assert.Equal(t, result.FinalURL, result.Hops[3].URL)
+
+	// only the first hop dials, the others reuse the connection
+	assert.Positive(t, result.Hops[0].ConnDuration)
+	assert.Zero(t, result.Hops[3].ConnDuration)
+	for i, hop := range result.Hops {
+		assert.Equal(t, "127.0.0.1", hop.RemoteIP)
+		assert.Equal(t, i > 0, hop.ConnReused)
+	}
+
+	m := result.Model()
+	assert.Len(t, m.RedirectChainURL, 4)
+	assert.Equal(t, []int32{302, 302, 302, 200}, m.RedirectChainStatusCode)
+	assert.Len(t, m.RedirectChainTTFBS, 4)
+	assert.Equal(t, []bool{false, true, true, true}, m.RedirectChainConnReused)
+	assert.Equal(t, []int32{1, 0, 0, 0}, m.RedirectChainConnAttempts)
+	assert.Equal(t, ptr.From(true), m.ConnReused)
+	assert.Zero(t, m.ConnAttempts)
+
+	gateway.Redirects = 11
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone})
+	assert.ErrorContains(t, result.Err, "stopped after 10 redirects")
+}
+
+func TestProber_Probe_truncated(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	gateway.TruncateAfter = 100
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatRaw})
+	assert.ErrorContains(t, result.Err, "reading response")
+	assert.Equal(t, int64(100), result.BytesReceived)
+	assert.Equal(t, int64(5000), *result.ContentLength())
+
+	// the gateway cut the body off, not the download limit
+	assert.False(t, result.Truncated)
+}
+
+func TestProber_Probe_maxBytes(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	prober.cfg.MaxBytes = 100
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatRaw})
+	require.NoError(t, result.Err)
+	assert.Equal(t, int64(100), result.BytesReceived)
+}
+
+func TestProber_Probe_slowBody(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	prober.cfg.Timeout = 100 * time.Millisecond
+	gateway.BodyDelay = time.Second
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatRaw})
+	assert.Error(t, result.Err)
+	assert.Equal(t, 200, result.StatusCode)
+	assert.Positive(t, result.TTFB)
+	assert.Zero(t, result.BytesReceived)
+	assert.Nil(t, result.DownloadSpeedMbps())
+}
+
+func TestProber_Probe_invalidCAR(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	gateway.InvalidCAR = true
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatCAR})
+	require.NoError(t, result.Err)
+	assert.Equal(t, ptr.From(false), result.CARValidated)
+	assert.False(t, result.CAR.Complete)
+	assert.False(t, *result.Model().CARComplete)
+
+	gateway.InvalidCAR = false
+	gateway.CorruptCAR = true
+
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatCAR})
+	require.NoError(t, result.Err)
+	assert.Equal(t, ptr.From(true), result.CARValidated)
+	assert.EqualValues(t, 1, result.CAR.InvalidBlocks)
+	assert.EqualValues(t, 1, result.CAR.MissingBlocks)
+	assert.False(t, result.CAR.Complete)
+}
+
+func TestProber_Probe_carParams(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+
+	req := &Request{
+		Gateway: gateway.URL,
+		Path:    path,
+		Format:  db.GatewayProbeFormatCAR,
+		CAR:     CARParams{DAGScope: DAGScopeEntity, EntityBytes: "0:99", Order: "dfs", Dups: "n"},
+	}
+
+	result := prober.Probe(context.Background(), req)
+	require.NoError(t, result.Err)
+	assert.Empty(t, result.ParamViolations)
+
+	requests := gateway.Requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "car", requests[0].URL.Query().Get("format"))
+	assert.Equal(t, "entity", requests[0].URL.Query().Get("dag-scope"))
+	assert.Equal(t, "0:99", requests[0].URL.Query().Get("entity-bytes"))
+	assert.Equal(t, "application/vnd.ipld.car; order=dfs; dups=n", requests[0].Header.Get("Accept"))
+
+	m := result.Model()
+	assert.Equal(t, "0:99", m.EntityBytes)
+	assert.Equal(t, "application/vnd.ipld.car; order=dfs; dups=n", m.Accept)
+	assert.Equal(t, map[string]string{"version": "1", "order": "dfs", "dups": "n"}, m.ContentTypeParams)
+	assert.Equal(t, ptr.From(int64(1)), m.CARVersion)
+	assert.Equal(t, ptr.From(true), m.CARDFSOrder)
+
+	gateway.IgnoreCARParams = true
+
+	result = prober.Probe(context.Background(), req)
+	require.NoError(t, result.Err)
+	assert.Equal(t, []string{"order", "dups"}, result.ParamViolations)
+	assert.EqualValues(t, 1, result.CAR.DuplicateBlocks)
+	assert.Equal(t, []string{"order", "dups"}, result.Model().ParamViolations)
+}
+
+func TestProber_Probe_statusCode(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	gateway.StatusCode = 504
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone})
+	assert.EqualError(t, result.Err, "HTTP 504")
+	assert.False(t, result.GatewayFailed())
+	assert.Equal(t, 504, result.StatusCode)
+	assert.Equal(t, "HTTP 504", *result.Model().Error)
+
+	gateway.StatusCode = 500
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone})
+	assert.EqualError(t, result.Err, "HTTP 500")
+	assert.True(t, result.GatewayFailed())
+
+	// blocks the gateway doesn't have are not found
+	gateway.StatusCode = 0
+	unknown, err := path.CID().Prefix().Sum([]byte("unknown"))
+	require.NoError(t, err)
+
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: pkg.CIDPath(unknown), Format: db.GatewayProbeFormatNone})
+	assert.EqualError(t, result.Err, "HTTP 404")
+	assert.False(t, result.GatewayFailed())
+}
+
+func TestProber_Probe_unretrievableKeepsBreakerClosed(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	breakers := NewBreakers(&BreakerConfig{Threshold: 3, InitialBackoff: time.Minute, MaxBackoff: time.Hour})
+
+	// the gateway couldn't retrieve the content, e.g., of sniffed CIDs
+	for _, status := range []int{504, 502, 504, 504} {
+		gateway.StatusCode = status
+		result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone})
+		require.Error(t, result.Err)
+		breakers.Record(gateway.URL, result.GatewayFailed())
+	}
+	assert.Equal(t, BreakerClosed, breakers.State(gateway.URL))
+
+	// but it's down
+	gateway.StatusCode = 503
+	for range 3 {
+		result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone})
+		breakers.Record(gateway.URL, result.GatewayFailed())
+	}
+	assert.Equal(t, BreakerOpen, breakers.State(gateway.URL))
+}
+
+func TestSkipped(t *testing.T) {
+	_, gateway, path := newTestProber(t)
+
+	result := Skipped(&Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatCAR}, SkippedReasonCircuitOpen)
+	assert.False(t, result.GatewayFailed())
+	assert.Empty(t, gateway.Requests())
+
+	m := result.Model()
+	assert.Equal(t, SkippedReasonCircuitOpen, *m.SkippedReason)
+	assert.Equal(t, string(db.GatewayProbeFormatCAR), m.Format)
+	assert.Equal(t, result.URL, gateway.URL+path.String()+"?format=car")
+	assert.Nil(t, m.Error)
+}
+
+func TestProber_Probe_protocols(t *testing.T) {
+	tests := []struct {
+		protocol Protocol
+		proto    string
+		alpn     string
+	}{
+		{protocol: ProtocolHTTP1, proto: "HTTP/1.1", alpn: "http/1.1"},
+		{protocol: ProtocolHTTP2, proto: "HTTP/2.0", alpn: "h2"},
+		{protocol: ProtocolHTTP3, proto: "HTTP/3.0", alpn: "h3"},
+	}
+
+	gateway := gwtest.NewTLS(t)
+	path := pkg.CIDPath(gateway.Add(bytes.Repeat([]byte("tiros"), 1000)))
+
+	prober := NewProber(&ProberConfig{
+		Timeout:         5 * time.Second,
+		MaxBytes:        1 << 20,
+		TLSClientConfig: gateway.TLSClientConfig(),
+	})
+
+	for _, tt := range tests {
+		t.Run(string(tt.protocol), func(t *testing.T) {
+			result := prober.Probe(context.Background(), &Request{
+				Gateway:  gateway.URL,
+				Path:     path,
+				Format:   db.GatewayProbeFormatCAR,
+				CAR:      CARParams{DAGScope: DAGScopeEntity},
+				Protocol: tt.protocol,
+			})
+			require.NoError(t, result.Err)
+
+			assert.Equal(t, tt.proto, result.NegotiatedProtocol)
+			assert.Equal(t, tt.alpn, result.ALPN)
+			assert.Positive(t, result.TLSDuration)
+			assert.Equal(t, ptr.From(true), result.CARValidated)
+			assert.Equal(t, "127.0.0.1", result.Hops[0].RemoteIP)
+
+			m := result.Model()
+			assert.Equal(t, string(tt.protocol), m.Protocol)
+			assert.Equal(t, tt.proto, m.NegotiatedProto)
+			assert.Equal(t, tt.alpn, m.ALPN)
+			assert.Equal(t, "entity", m.DAGScope)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package gw
+
+import "fmt"
+
+// Protocol is the HTTP version a probe is made with.
+type Protocol string
+
+const (
+	ProtocolHTTP1 Protocol = "http1"
+	ProtocolHTTP2 Protocol = "http2"
+	ProtocolHTTP3 Protocol = "http3"
+)
+
+// Protocols are all supported protocols.
+var Protocols = []Protocol{ProtocolHTTP1, ProtocolHTTP2, ProtocolHTTP3}
+
+// ParseProtocol parses the name of a protocol as it's given on the command
+// line.
+func ParseProtocol(s string) (Protocol, error) {
+	for _, p := range Protocols {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown protocol %q (supported: %v)", s, Protocols)
+}
+
+func (p Protocol) orDefault() Protocol {
+	if p == "" {
+		return ProtocolHTTP1
+	}
+	return p
+}
@@ -0,0 +1,248 @@
+package gw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+	"go.yaml.in/yaml/v3"
+)
+
+// AuthScheme is how the auth key of a gateway is sent.
+type AuthScheme string
+
+const (
+	// AuthSchemeTiros sends the key in the Tiros-Auth header.
+	AuthSchemeTiros AuthScheme = "tiros"
+	// AuthSchemeBearer sends the key as a bearer token in the Authorization
+	// header.
+	AuthSchemeBearer AuthScheme = "bearer"
+	// AuthSchemeNone never sends the key.
+	AuthSchemeNone AuthScheme = "none"
+)
+
+// AuthSchemes are all supported auth schemes.
+var AuthSchemes = []AuthScheme{AuthSchemeTiros, AuthSchemeBearer, AuthSchemeNone}
+
+func (s AuthScheme) orDefault() AuthScheme {
+	if s == "" {
+		return AuthSchemeTiros
+	}
+	return s
+}
+
+// Capabilities are the features a gateway can declare to support. Requests
+// that need a capability the gateway doesn't declare are expected to fail.
+var Capabilities = []string{
+	// formats
+	string(db.GatewayProbeFormatRaw),
+	string(db.GatewayProbeFormatCAR),
+	string(db.GatewayProbeFormatTAR),
+	string(db.GatewayProbeFormatDAGJSON),
+	string(db.GatewayProbeFormatDAGCBOR),
+	string(db.GatewayProbeFormatIPNSRecord),
+	// trustless CAR parameters
+	"dag-scope",
+	"entity-bytes",
+	// protocols and URL styles
+	string(ProtocolHTTP2),
+	string(ProtocolHTTP3),
+	string(URLStyleSubdomain),
+	// resolution of /ipns paths
+	string(pkg.ResolutionTypeIPNS),
+	string(pkg.ResolutionTypeDNSLink),
+}
+
+// Gateway is an entry of the gateway registry. Only Host is required, all
+// other fields restrict or annotate how the gateway is probed.
+type Gateway struct {
+	// Host is the host of the gateway (e.g., "ipfs.io") or its base URL.
+	Host string `json:"host" yaml:"host"`
+
+	// URLStyle, if set, is the only URL style the gateway is probed with.
+	URLStyle URLStyle `json:"url_style,omitempty" yaml:"url_style,omitempty"`
+
+	// Formats, if set, are the only formats the gateway is probed with.
+	Formats []db.GatewayProbeFormat `json:"formats,omitempty" yaml:"formats,omitempty"`
+
+	// AuthScheme is how the auth key of the gateway is sent. It defaults to
+	// the Tiros-Auth header.
+	AuthScheme AuthScheme `json:"auth_scheme,omitempty" yaml:"auth_scheme,omitempty"`
+
+	// Headers are sent with every request to the gateway.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+
+	// Weight is the share of iterations in which the gateway is probed,
+	// between 0 and 1. It defaults to 1.
+	Weight *float64 `json:"weight,omitempty" yaml:"weight,omitempty"`
+
+	// Tags annotate the probes of the gateway (e.g., operator, cdn).
+	Tags map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+
+	// Capabilities are the expected capabilities of the gateway. See
+	// Capabilities for the supported values.
+	Capabilities []string `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
+}
+
+// Validate checks that all fields of the entry have supported values.
+func (g *Gateway) Validate() error {
+	if g.Host == "" {
+		return fmt.Errorf("gateway without host")
+	}
+
+	if g.URLStyle != "" {
+		if _, err := ParseURLStyle(string(g.URLStyle)); err != nil {
+			return fmt.Errorf("gateway %s: %w", g.Host, err)
+		}
+	}
+
+	for _, format := range g.Formats {
+		if _, err := ParseFormat(string(format)); err != nil {
+			return fmt.Errorf("gateway %s: %w", g.Host, err)
+		}
+	}
+
+	if g.AuthScheme != "" && !slices.Contains(AuthSchemes, g.AuthScheme) {
+		return fmt.Errorf("gateway %s: unknown auth scheme %q (supported: %v)", g.Host, g.AuthScheme, AuthSchemes)
+	}
+
+	if g.Weight != nil && (*g.Weight < 0 || *g.Weight > 1) {
+		return fmt.Errorf("gateway %s: weight %v is not between 0 and 1", g.Host, *g.Weight)
+	}
+
+	for _, capability := range g.Capabilities {
+		if !slices.Contains(Capabilities, capability) {
+			return fmt.Errorf("gateway %s: unknown capability %q (supported: %v)", g.Host, capability, Capabilities)
+		}
+	}
+
+	return nil
+}
+
+// ProbeWeight returns the share of iterations in which the gateway is probed.
+func (g *Gateway) ProbeWeight() float64 {
+	if g.Weight == nil {
+		return 1
+	}
+	return *g.Weight
+}
+
+// Supports reports whether the gateway is probed with the format and URL
+// style of the request.
+func (g *Gateway) Supports(req *Request) bool {
+	if g.URLStyle != "" && g.URLStyle != req.URLStyle.orDefault() {
+		return false
+	}
+	return len(g.Formats) == 0 || slices.Contains(g.Formats, req.Format)
+}
+
+// Expects reports whether the gateway declares all capabilities the request
+// needs. It returns nil if the gateway declares no capabilities.
+func (g *Gateway) Expects(req *Request) *bool {
+	if len(g.Capabilities) == 0 {
+		return nil
+	}
+
+	var needed []string
+	if req.Format != db.GatewayProbeFormatNone {
+		needed = append(needed, string(req.Format))
+	}
+	if req.CAR.DAGScope != "" {
+		needed = append(needed, "dag-scope")
+	}
+	if req.CAR.EntityBytes != "" {
+		needed = append(needed, "entity-bytes")
+	}
+	if protocol := req.Protocol.orDefault(); protocol != ProtocolHTTP1 {
+		needed = append(needed, string(protocol))
+	}
+	if style := req.URLStyle.orDefault(); style != URLStylePath {
+		needed = append(needed, string(style))
+	}
+	if rt := req.Path.ResolutionType(); rt == pkg.ResolutionTypeIPNS || rt == pkg.ResolutionTypeDNSLink {
+		needed = append(needed, string(rt))
+	}
+
+	expected := true
+	for _, capability := range needed {
+		if !slices.Contains(g.Capabilities, capability) {
+			expected = false
+			break
+		}
+	}
+
+	return &expected
+}
+
+// Apply sets the gateway specific fields of the request.
+func (g *Gateway) Apply(req *Request) {
+	req.Gateway = g.Host
+	req.AuthScheme = g.AuthScheme
+	req.Headers = g.Headers
+}
+
+// GatewayFromModel converts a row of the gateways table into a registry entry.
+func GatewayFromModel(m *db.GatewayModel) (*Gateway, error) {
+	g := &Gateway{
+		Host:         m.Domain,
+		URLStyle:     URLStyle(m.URLStyle),
+		AuthScheme:   AuthScheme(m.AuthScheme),
+		Headers:      m.Headers,
+		Weight:       &m.Weight,
+		Tags:         m.Tags,
+		Capabilities: m.Capabilities,
+	}
+
+	for _, format := range m.Formats {
+		g.Formats = append(g.Formats, db.GatewayProbeFormat(format))
+	}
+
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// LoadRegistry reads the gateway registry from a YAML or JSON file. The file
+// is a list of Gateway entries. Files ending in .json are parsed as JSON,
+// all others as YAML.
+func LoadRegistry(path string) ([]*Gateway, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading gateway registry: %w", err)
+	}
+
+	var gateways []*Gateway
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&gateways)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&gateways)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing gateway registry %s: %w", path, err)
+	}
+
+	hosts := make(map[string]bool, len(gateways))
+	for _, g := range gateways {
+		if err := g.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid gateway registry %s: %w", path, err)
+		}
+
+		if hosts[g.Host] {
+			return nil, fmt.Errorf("invalid gateway registry %s: duplicate gateway %s", path, g.Host)
+		}
+		hosts[g.Host] = true
+	}
+
+	return gateways, nil
+}
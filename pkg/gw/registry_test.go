@@ -0,0 +1,185 @@
+package gw
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRegistry(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	return path
+}
+
+func TestLoadRegistry(t *testing.T) {
+	yamlPath := writeRegistry(t, "gateways.yaml", `
+- host: ipfs.io
+- host: dweb.link
+  url_style: subdomain
+  formats: [car, raw]
+  auth_scheme: bearer
+  headers:
+    X-Probe: tiros
+  weight: 0.5
+  tags:
+    operator: pl
+  capabilities: [car, raw, subdomain]
+`)
+
+	jsonPath := writeRegistry(t, "gateways.json", `[
+  {"host": "ipfs.io"},
+  {
+    "host": "dweb.link",
+    "url_style": "subdomain",
+    "formats": ["car", "raw"],
+    "auth_scheme": "bearer",
+    "headers": {"X-Probe": "tiros"},
+    "weight": 0.5,
+    "tags": {"operator": "pl"},
+    "capabilities": ["car", "raw", "subdomain"]
+  }
+]`)
+
+	want := []*Gateway{
+		{Host: "ipfs.io"},
+		{
+			Host:         "dweb.link",
+			URLStyle:     URLStyleSubdomain,
+			Formats:      []db.GatewayProbeFormat{db.GatewayProbeFormatCAR, db.GatewayProbeFormatRaw},
+			AuthScheme:   AuthSchemeBearer,
+			Headers:      map[string]string{"X-Probe": "tiros"},
+			Weight:       ptr.From(0.5),
+			Tags:         map[string]string{"operator": "pl"},
+			Capabilities: []string{"car", "raw", "subdomain"},
+		},
+	}
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			gateways, err := LoadRegistry(path)
+			require.NoError(t, err)
+			assert.Equal(t, want, gateways)
+		})
+	}
+}
+
+func TestLoadRegistry_invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":      "- host: ipfs.io\n  hots: dweb.link\n",
+		"missing host":       "- url_style: path\n",
+		"unknown url style":  "- host: ipfs.io\n  url_style: query\n",
+		"unknown format":     "- host: ipfs.io\n  formats: [zip]\n",
+		"unknown auth":       "- host: ipfs.io\n  auth_scheme: basic\n",
+		"weight too large":   "- host: ipfs.io\n  weight: 2\n",
+		"unknown capability": "- host: ipfs.io\n  capabilities: [teleport]\n",
+		"duplicate host":     "- host: ipfs.io\n- host: ipfs.io\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadRegistry(writeRegistry(t, "gateways.yaml", content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGateway_Supports(t *testing.T) {
+	g := &Gateway{
+		Host:     "ipfs.io",
+		URLStyle: URLStyleSubdomain,
+		Formats:  []db.GatewayProbeFormat{db.GatewayProbeFormatCAR},
+	}
+
+	assert.True(t, g.Supports(&Request{Format: db.GatewayProbeFormatCAR, URLStyle: URLStyleSubdomain}))
+	assert.False(t, g.Supports(&Request{Format: db.GatewayProbeFormatCAR}))
+	assert.False(t, g.Supports(&Request{Format: db.GatewayProbeFormatRaw, URLStyle: URLStyleSubdomain}))
+	assert.True(t, (&Gateway{Host: "ipfs.io"}).Supports(&Request{Format: db.GatewayProbeFormatTAR}))
+}
+
+func TestGateway_Expects(t *testing.T) {
+	c := cid.MustParse("bafkqaaa")
+
+	g := &Gateway{
+		Host:         "ipfs.io",
+		Capabilities: []string{"car", "dag-scope", "http2"},
+	}
+
+	assert.Nil(t, (&Gateway{Host: "ipfs.io"}).Expects(&Request{Path: pkg.CIDPath(c)}))
+	assert.Equal(t, ptr.From(true), g.Expects(&Request{Path: pkg.CIDPath(c), Format: db.GatewayProbeFormatNone}))
+	assert.Equal(t, ptr.From(true), g.Expects(&Request{Path: pkg.CIDPath(c), Format: db.GatewayProbeFormatCAR, CAR: CARParams{DAGScope: DAGScopeBlock}, Protocol: ProtocolHTTP2}))
+	assert.Equal(t, ptr.From(false), g.Expects(&Request{Path: pkg.CIDPath(c), Format: db.GatewayProbeFormatRaw}))
+	assert.Equal(t, ptr.From(false), g.Expects(&Request{Path: pkg.CIDPath(c), Format: db.GatewayProbeFormatCAR, CAR: CARParams{DAGScope: DAGScopeEntity, EntityBytes: "0:10"}}))
+	assert.Equal(t, ptr.From(false), g.Expects(&Request{Path: pkg.CIDPath(c), Format: db.GatewayProbeFormatNone, Protocol: ProtocolHTTP3}))
+	assert.Equal(t, ptr.From(false), g.Expects(&Request{Path: pkg.CIDPath(c), Format: db.GatewayProbeFormatNone, URLStyle: URLStyleSubdomain}))
+}
+
+func TestGateway_ProbeWeight(t *testing.T) {
+	assert.Equal(t, 1.0, (&Gateway{}).ProbeWeight())
+	assert.Equal(t, 0.25, (&Gateway{Weight: ptr.From(0.25)}).ProbeWeight())
+	assert.Equal(t, 0.0, (&Gateway{Weight: ptr.From(0.0)}).ProbeWeight())
+}
+
+func TestGatewayFromModel(t *testing.T) {
+	g, err := GatewayFromModel(&db.GatewayModel{
+		Domain:     "ipfs.io",
+		Formats:    []string{"car"},
+		AuthScheme: "none",
+		Weight:     1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []db.GatewayProbeFormat{db.GatewayProbeFormatCAR}, g.Formats)
+	assert.Equal(t, AuthSchemeNone, g.AuthScheme)
+
+	_, err = GatewayFromModel(&db.GatewayModel{Domain: "ipfs.io", URLStyle: "query", Weight: 1})
+	assert.Error(t, err)
+}
+
+func TestProber_Probe_registry(t *testing.T) {
+	tests := []struct {
+		scheme AuthScheme
+		tiros  string
+		bearer string
+	}{
+		{scheme: "", tiros: "secret"},
+		{scheme: AuthSchemeTiros, tiros: "secret"},
+		{scheme: AuthSchemeBearer, bearer: "Bearer secret"},
+		{scheme: AuthSchemeNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.scheme), func(t *testing.T) {
+			prober, gateway, path := newTestProber(t)
+
+			g := &Gateway{
+				Host:       gateway.URL,
+				AuthScheme: tt.scheme,
+				Headers:    map[string]string{"X-Probe": "tiros", "User-Agent": "other"},
+			}
+
+			req := &Request{Path: path, Format: db.GatewayProbeFormatNone, AuthKey: "secret"}
+			g.Apply(req)
+
+			result := prober.Probe(context.Background(), req)
+			require.NoError(t, result.Err)
+
+			requests := gateway.Requests()
+			require.Len(t, requests, 1)
+			assert.Equal(t, "tiros", requests[0].Header.Get("X-Probe"))
+			assert.Equal(t, "Tiros", requests[0].Header.Get("User-Agent"))
+			assert.Equal(t, tt.tiros, requests[0].Header.Get("Tiros-Auth"))
+			assert.Equal(t, tt.bearer, requests[0].Header.Get("Authorization"))
+		})
+	}
+}
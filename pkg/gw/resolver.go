@@ -0,0 +1,396 @@
+package gw
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/errgroup"
+)
+
+// Resolver resolves the host names of gateways. The system resolver is
+// subject to the caching of the OS (e.g., of a local stub resolver), so its
+// DNS durations are often close to zero after the first probe. The UDP and
+// DNS-over-HTTPS resolvers send their queries to a fixed server for every
+// probe, unless they cache answers for their TTL.
+type Resolver struct {
+	name string
+
+	// system is set for the system resolver, exchange for all others.
+	system   *net.Resolver
+	exchange func(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
+
+	cache   bool
+	mu      sync.Mutex
+	entries map[string]resolverEntry
+}
+
+type resolverEntry struct {
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+// SystemResolver returns the resolver of the OS.
+func SystemResolver() *Resolver {
+	return &Resolver{name: "system", system: net.DefaultResolver}
+}
+
+// ParseResolver parses a resolver as it's given on the command line: "system",
+// the address of a UDP resolver (e.g., "udp://1.1.1.1:53" or "1.1.1.1"), or
+// the URL of a DNS-over-HTTPS resolver (e.g.,
+// "https://cloudflare-dns.com/dns-query"). If cache is set, the UDP and
+// DNS-over-HTTPS resolvers cache answers for their TTL. Otherwise, every
+// lookup is sent to the server.
+func ParseResolver(s string, cache bool) (*Resolver, error) {
+	switch {
+	case s == "" || s == "system":
+		return SystemResolver(), nil
+
+	case strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://"):
+		client := &http.Client{Timeout: 10 * time.Second}
+		return newResolver(s, cache, func(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+			return exchangeDoH(ctx, client, s, m)
+		}), nil
+
+	default:
+		addr := strings.TrimPrefix(s, "udp://")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), "53")
+		}
+		if _, err := netip.ParseAddrPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid resolver %q: %w", s, err)
+		}
+
+		udp := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+		tcp := &dns.Client{Net: "tcp", Timeout: 5 * time.Second}
+		return newResolver("udp://"+addr, cache, func(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+			resp, _, err := udp.ExchangeContext(ctx, m, addr)
+			if err == nil && resp.Truncated {
+				resp, _, err = tcp.ExchangeContext(ctx, m, addr)
+			}
+			return resp, err
+		}), nil
+	}
+}
+
+func newResolver(name string, cache bool, exchange func(ctx context.Context, m *dns.Msg) (*dns.Msg, error)) *Resolver {
+	return &Resolver{
+		name:     name,
+		exchange: exchange,
+		cache:    cache,
+		entries:  map[string]resolverEntry{},
+	}
+}
+
+// String returns the name of the resolver that is stored with every probe,
+// e.g., "system" or "udp://1.1.1.1:53".
+func (r *Resolver) String() string {
+	return r.name
+}
+
+// LookupNetIP returns the addresses of the host for the network "ip", "ip4",
+// or "ip6".
+func (r *Resolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if r.system != nil {
+		return r.system.LookupNetIP(ctx, network, host)
+	}
+
+	key := network + "/" + host
+	if r.cache {
+		r.mu.Lock()
+		entry, found := r.entries[key]
+		r.mu.Unlock()
+		if found && time.Now().Before(entry.expires) {
+			return entry.addrs, nil
+		}
+	}
+
+	var qtypes []uint16
+	switch network {
+	case "ip4":
+		qtypes = []uint16{dns.TypeA}
+	case "ip6":
+		qtypes = []uint16{dns.TypeAAAA}
+	default:
+		qtypes = []uint16{dns.TypeA, dns.TypeAAAA}
+	}
+
+	// the queries are sent concurrently like the system resolver does
+	answers := make([][]dns.RR, len(qtypes))
+	errg, ectx := errgroup.WithContext(ctx)
+	for i, qtype := range qtypes {
+		errg.Go(func() error {
+			m := new(dns.Msg)
+			m.SetQuestion(dns.Fqdn(host), qtype)
+
+			resp, err := r.exchange(ectx, m)
+			if err != nil {
+				return &net.DNSError{Err: err.Error(), Name: host, Server: r.name}
+			} else if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+				return &net.DNSError{Err: dns.RcodeToString[resp.Rcode], Name: host, Server: r.name}
+			}
+			answers[i] = resp.Answer
+			return nil
+		})
+	}
+	if err := errg.Wait(); err != nil {
+		return nil, err
+	}
+
+	var addrs []netip.Addr
+	var ttl uint32
+	for _, answer := range answers {
+		for _, rr := range answer {
+			var ip net.IP
+			switch rr := rr.(type) {
+			case *dns.A:
+				ip = rr.A
+			case *dns.AAAA:
+				ip = rr.AAAA
+			default:
+				continue
+			}
+
+			addr, ok := netip.AddrFromSlice(ip)
+			if !ok {
+				continue
+			}
+			addrs = append(addrs, addr.Unmap())
+
+			if len(addrs) == 1 || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.name, IsNotFound: true}
+	}
+
+	if r.cache && ttl > 0 {
+		r.mu.Lock()
+		r.entries[key] = resolverEntry{addrs: addrs, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+		r.mu.Unlock()
+	}
+
+	return addrs, nil
+}
+
+// resolve looks up the addresses of the host in the IP family and reports the
+// lookup to the client trace like the dialer of the system resolver does.
+// IP addresses are returned as is.
+func (r *Resolver) resolve(ctx context.Context, family IPFamily, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+
+	lookupCtx, cancel := withoutValues(ctx)
+	addrs, err := r.LookupNetIP(lookupCtx, family.network("ip"), host)
+	cancel()
+
+	if trace != nil && trace.DNSDone != nil {
+		info := httptrace.DNSDoneInfo{Err: err}
+		for _, addr := range addrs {
+			info.Addrs = append(info.Addrs, net.IPAddr{IP: addr.AsSlice(), Zone: addr.Zone()})
+		}
+		trace.DNSDone(info)
+	}
+
+	return addrs, err
+}
+
+// withoutValues returns a context with the deadline and cancellation of ctx
+// but none of its values. Lookups run on it, so that the client trace of the
+// probe, which net/http keeps on the dial context, doesn't observe the
+// connections to the DNS server (e.g., the UDP sockets, or the nested HTTP
+// request of DNS-over-HTTPS) as ones to the gateway.
+func withoutValues(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached, cancel := context.WithCancel(context.Background())
+	if deadline, ok := ctx.Deadline(); ok {
+		detached, cancel = context.WithDeadline(context.Background(), deadline)
+	}
+
+	stop := context.AfterFunc(ctx, cancel)
+	return detached, func() {
+		stop()
+		cancel()
+	}
+}
+
+// dialContext dials the address with the dialer like dialer.DialContext. The
+// host is resolved with the resolver, and its addresses are dialed like the
+// dialer does with the system resolver, which leaves both to the dialer.
+func (r *Resolver) dialContext(ctx context.Context, dialer *net.Dialer, family IPFamily, network, addr string) (net.Conn, error) {
+	if r.system != nil {
+		return dialer.DialContext(ctx, family.network(network), addr)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := r.resolve(ctx, family, host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialParallel(ctx, dialer, family.network(network), port, ips)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// dialParallel races the IPv6 addresses against the IPv4 addresses (Happy
+// Eyeballs, RFC 6555): the IPv6 addresses are dialed one after the other,
+// and the IPv4 addresses start after the fallback delay of the dialer, or
+// as soon as all IPv6 addresses failed. The first connection wins.
+func dialParallel(ctx context.Context, dialer *net.Dialer, network, port string, ips []netip.Addr) (net.Conn, error) {
+	var primaries, fallbacks []netip.Addr
+	for _, ip := range ips {
+		if ip.Unmap().Is4() {
+			fallbacks = append(fallbacks, ip)
+		} else {
+			primaries = append(primaries, ip)
+		}
+	}
+
+	if len(primaries) == 0 || len(fallbacks) == 0 || dialer.FallbackDelay < 0 {
+		return dialSerial(ctx, dialer, network, port, ips)
+	}
+
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+
+	race := func(ctx context.Context, primary bool, ips []netip.Addr) {
+		conn, err := dialSerial(ctx, dialer, network, port, ips)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: primary}:
+		case <-returned:
+			if conn != nil {
+				_ = conn.Close()
+			}
+		}
+	}
+
+	primaryCtx, primaryCancel := context.WithCancel(ctx)
+	defer primaryCancel()
+	go race(primaryCtx, true, primaries)
+
+	delay := dialer.FallbackDelay
+	if delay == 0 {
+		delay = 300 * time.Millisecond
+	}
+	fallbackTimer := time.NewTimer(delay)
+	defer fallbackTimer.Stop()
+
+	fallbackCtx, fallbackCancel := context.WithCancel(ctx)
+	defer fallbackCancel()
+
+	var errs []error
+	for pending := 2; pending > 0; {
+		select {
+		case <-fallbackTimer.C:
+			go race(fallbackCtx, false, fallbacks)
+
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			pending -= 1
+
+			// don't wait for the delay if all IPv6 addresses failed
+			if res.primary && fallbackTimer.Stop() {
+				go race(fallbackCtx, false, fallbacks)
+			}
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// dialSerial dials the addresses one after the other until a connection is
+// established.
+func dialSerial(ctx context.Context, dialer *net.Dialer, network, port string, ips []netip.Addr) (net.Conn, error) {
+	var errs []error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, errors.New("no addresses")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// exchangeDoH sends the query to the DNS-over-HTTPS resolver at the URL
+// (RFC 8484).
+func exchangeDoH(ctx context.Context, client *http.Client, url string, m *dns.Msg) (*dns.Msg, error) {
+	// the ID is always 0 so that answers can be cached by HTTP caches
+	m.Id = 0
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("pack query: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("read answer: %w", err)
+	}
+
+	answer := new(dns.Msg)
+	if err := answer.Unpack(body); err != nil {
+		return nil, fmt.Errorf("unpack answer: %w", err)
+	}
+
+	return answer, nil
+}
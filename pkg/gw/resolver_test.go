@@ -0,0 +1,199 @@
+package gw
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/probe-lab/tiros/pkg/gw/gwtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber_Probe_resolvers(t *testing.T) {
+	dns := gwtest.NewDNS(t)
+
+	for _, spec := range []string{"udp://" + dns.Addr(), dns.DoHURL()} {
+		t.Run(spec, func(t *testing.T) {
+			prober, gateway, path := newTestProber(t)
+
+			var err error
+			prober.cfg.Resolver, err = ParseResolver(spec, false)
+			require.NoError(t, err)
+
+			gatewayURL := strings.Replace(gateway.URL, "127.0.0.1", "gateway.test", 1)
+
+			result := prober.Probe(context.Background(), &Request{Gateway: gatewayURL, Path: path, Format: db.GatewayProbeFormatNone})
+			require.NoError(t, result.Err)
+
+			m := result.Model()
+			assert.Equal(t, spec, *m.DNSResolver)
+			assert.Equal(t, []string{"127.0.0.1"}, m.ResolvedAddrs)
+			assert.Positive(t, *m.DNSDurationS)
+			assert.Equal(t, "127.0.0.1", *m.RemoteIP)
+		})
+	}
+}
+
+func TestProber_Probe_resolverTraffic(t *testing.T) {
+	// the connections to the server mustn't be attributed to the gateway
+	dns := gwtest.NewDNSAt(t, "127.0.0.2")
+
+	for _, spec := range []string{"udp://" + dns.Addr(), dns.DoHURL()} {
+		t.Run(spec, func(t *testing.T) {
+			prober, gateway, path := newTestProber(t)
+
+			var err error
+			prober.cfg.Resolver, err = ParseResolver(spec, false)
+			require.NoError(t, err)
+
+			gatewayURL := strings.Replace(gateway.URL, "127.0.0.1", "gateway.test", 1)
+
+			// the second probe reuses the connection to the DoH server
+			for range 2 {
+				result := prober.Probe(context.Background(), &Request{Gateway: gatewayURL, Path: path, Format: db.GatewayProbeFormatNone})
+				require.NoError(t, result.Err)
+				require.Len(t, result.Hops, 1)
+
+				hop := result.Hops[0]
+				assert.Equal(t, 1, hop.ConnAttempts)
+				assert.Equal(t, "127.0.0.1", hop.RemoteIP)
+				assert.Positive(t, hop.ConnDuration)
+				assert.Equal(t, hop.ConnDuration, result.ConnDuration)
+			}
+		})
+	}
+}
+
+func TestResolver_LookupNetIP_cache(t *testing.T) {
+	dns := gwtest.NewDNS(t)
+
+	for _, cache := range []bool{false, true} {
+		resolver, err := ParseResolver("udp://"+dns.Addr(), cache)
+		require.NoError(t, err)
+
+		host := "cache.test"
+		if cache {
+			host = "cached.test"
+		}
+
+		for range 2 {
+			addrs, err := resolver.LookupNetIP(context.Background(), "ip4", host)
+			require.NoError(t, err)
+			assert.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, addrs)
+		}
+	}
+
+	var uncached, cached int
+	for _, q := range dns.Queries() {
+		switch q {
+		case "cache.test":
+			uncached += 1
+		case "cached.test":
+			cached += 1
+		}
+	}
+	assert.Equal(t, 2, uncached)
+	assert.Equal(t, 1, cached)
+}
+
+func TestDialParallel(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	ips := []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")}
+
+	t.Run("fallback", func(t *testing.T) {
+		// the IPv6 dial hangs until it's cancelled
+		dialer := &net.Dialer{
+			FallbackDelay: 50 * time.Millisecond,
+			ControlContext: func(ctx context.Context, network, address string, c syscall.RawConn) error {
+				if network == "tcp6" {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				return nil
+			},
+		}
+
+		start := time.Now()
+		conn, err := dialParallel(context.Background(), dialer, "tcp", port, ips)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		assert.Equal(t, ln.Addr().String(), conn.RemoteAddr().String())
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("primary failed", func(t *testing.T) {
+		// the IPv4 addresses don't wait for the delay if IPv6 fails
+		dialer := &net.Dialer{
+			FallbackDelay: time.Hour,
+			ControlContext: func(ctx context.Context, network, address string, c syscall.RawConn) error {
+				if network == "tcp6" {
+					return syscall.ECONNREFUSED
+				}
+				return nil
+			},
+		}
+
+		conn, err := dialParallel(context.Background(), dialer, "tcp", port, ips)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		assert.Equal(t, ln.Addr().String(), conn.RemoteAddr().String())
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		dialer := &net.Dialer{
+			ControlContext: func(ctx context.Context, network, address string, c syscall.RawConn) error {
+				return syscall.ECONNREFUSED
+			},
+		}
+
+		_, err := dialParallel(context.Background(), dialer, "tcp", port, ips)
+		assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+	})
+}
+
+func TestParseResolver(t *testing.T) {
+	tests := []struct {
+		spec string
+		name string
+	}{
+		{spec: "", name: "system"},
+		{spec: "system", name: "system"},
+		{spec: "1.1.1.1", name: "udp://1.1.1.1:53"},
+		{spec: "udp://9.9.9.9:5353", name: "udp://9.9.9.9:5353"},
+		{spec: "2606:4700:4700::1111", name: "udp://[2606:4700:4700::1111]:53"},
+		{spec: "https://cloudflare-dns.com/dns-query", name: "https://cloudflare-dns.com/dns-query"},
+	}
+
+	for _, tt := range tests {
+		resolver, err := ParseResolver(tt.spec, false)
+		require.NoError(t, err)
+		assert.Equal(t, tt.name, resolver.String())
+	}
+
+	_, err := ParseResolver("dns.google", false)
+	assert.Error(t, err)
+}
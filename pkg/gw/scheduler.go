@@ -0,0 +1,310 @@
+package gw
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+// Job is a single probe in the queue of the Scheduler: an attempt of a
+// request for a content path in a format from a gateway.
+type Job struct {
+	Request Request
+
+	// Run executes the job. An error stops the scheduler.
+	Run func(ctx context.Context, job *Job) error
+
+	enqueuedAt time.Time
+}
+
+// SchedulerConfig configures the Scheduler.
+type SchedulerConfig struct {
+	// Concurrency is the maximum number of jobs that run at the same time.
+	Concurrency int
+
+	// GatewayConcurrency is the maximum number of jobs per gateway that run at
+	// the same time. 0 means no limit besides Concurrency.
+	GatewayConcurrency int
+
+	// GatewayQueueSize is the number of queued jobs per gateway at which
+	// Full reports the gateway as full. 0 means no limit.
+	GatewayQueueSize int
+
+	// Rate is the target number of jobs started per second. 0 means no limit.
+	Rate float64
+}
+
+// DefaultSchedulerConfig returns the default configuration of the Scheduler.
+func DefaultSchedulerConfig() *SchedulerConfig {
+	return &SchedulerConfig{
+		Concurrency:        10,
+		GatewayConcurrency: 1,
+		GatewayQueueSize:   50,
+		Rate:               0,
+	}
+}
+
+// Scheduler runs the jobs of a queue in order, but skips over jobs of
+// gateways that are at their concurrency limit, so that a slow gateway
+// doesn't delay the probes of all others.
+type Scheduler struct {
+	cfg     *SchedulerConfig
+	limiter *rate.Limiter
+
+	mu      sync.Mutex
+	queue   []*Job
+	queued  map[string]int // per gateway, without zero entries
+	active  map[string]int // per gateway, without zero entries
+	running int
+	drained bool // whether a gateway ran out of queued jobs since WaitCapacity returned
+	closed  bool
+	changed chan struct{} // closed and replaced on every change
+
+	lagHistogram metric.Float64Histogram
+}
+
+// NewScheduler returns a scheduler with an empty queue. Jobs are only run
+// after Run was called.
+func NewScheduler(cfg *SchedulerConfig) (*Scheduler, error) {
+	if cfg.Concurrency <= 0 {
+		return nil, fmt.Errorf("concurrency must be positive, got %d", cfg.Concurrency)
+	} else if cfg.GatewayConcurrency < 0 {
+		return nil, fmt.Errorf("gateway concurrency must not be negative, got %d", cfg.GatewayConcurrency)
+	} else if cfg.Rate < 0 {
+		return nil, fmt.Errorf("rate must not be negative, got %v", cfg.Rate)
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if cfg.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(cfg.Rate), 1)
+	}
+
+	meter := otel.GetMeterProvider().Meter("tiros")
+
+	lagHistogram, err := meter.Float64Histogram(
+		"gateway_probe_queue_lag",
+		metric.WithDescription("Seconds a gateway probe waited in the queue before it started"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating gateway_probe_queue_lag histogram: %w", err)
+	}
+
+	s := &Scheduler{
+		cfg:          cfg,
+		limiter:      limiter,
+		queued:       map[string]int{},
+		active:       map[string]int{},
+		changed:      make(chan struct{}),
+		lagHistogram: lagHistogram,
+	}
+
+	_, err = meter.Int64ObservableGauge(
+		"gateway_probe_queue_depth",
+		metric.WithDescription("Number of gateway probes waiting in the queue"),
+		metric.WithInt64Callback(func(ctx context.Context, observer metric.Int64Observer) error {
+			observer.Observe(int64(s.Len()))
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating gateway_probe_queue_depth gauge: %w", err)
+	}
+
+	return s, nil
+}
+
+// Push appends the job to the end of the queue.
+func (s *Scheduler) Push(job *Job) {
+	s.push(job, false)
+}
+
+// PushFront puts the job at the front of the queue, e.g., a follow-up
+// attempt that should run right after the previous one.
+func (s *Scheduler) PushFront(job *Job) {
+	s.push(job, true)
+}
+
+func (s *Scheduler) push(job *Job, front bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.enqueuedAt = time.Now()
+	if front {
+		s.queue = slices.Insert(s.queue, 0, job)
+	} else {
+		s.queue = append(s.queue, job)
+	}
+	s.queued[job.Request.Gateway] += 1
+	s.notify()
+}
+
+// Close tells the scheduler that no more jobs are pushed except by running
+// jobs. Run returns once the queue is drained.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.notify()
+}
+
+// Len returns the number of queued jobs.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.queue)
+}
+
+// Full reports whether the gateway has reached its queue size, i.e., it
+// can't keep up with the jobs it's given.
+func (s *Scheduler) Full(gateway string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cfg.GatewayQueueSize > 0 && s.queued[gateway] >= s.cfg.GatewayQueueSize
+}
+
+// WaitCapacity blocks until the workers are about to run out of jobs: fewer
+// queued jobs than Concurrency could start right away, and the queue is empty
+// or a gateway ran out of queued jobs since WaitCapacity last returned. Jobs
+// of gateways at their concurrency limit don't count as startable, so that a
+// producer isn't held back by a slow gateway.
+func (s *Scheduler) WaitCapacity(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		startable := 0
+		for _, job := range s.queue {
+			if s.canStart(job) {
+				startable += 1
+			}
+		}
+		ready := startable < s.cfg.Concurrency && (len(s.queue) == 0 || s.drained)
+		if ready {
+			s.drained = false
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Run runs the queued jobs until the scheduler is closed and all jobs are
+// done, a job fails, or the context is canceled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	for range s.cfg.Concurrency {
+		g.Go(func() error {
+			for {
+				job, err := s.next(gctx)
+				if err != nil || job == nil {
+					return err
+				}
+
+				err = s.run(gctx, job)
+				if err != nil {
+					return err
+				}
+			}
+		})
+	}
+	return g.Wait()
+}
+
+func (s *Scheduler) run(ctx context.Context, job *Job) error {
+	defer s.done(job)
+
+	if err := s.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	s.lagHistogram.Record(ctx, time.Since(job.enqueuedAt).Seconds(), metric.WithAttributes(
+		attribute.String("gateway", job.Request.Gateway),
+	))
+
+	return job.Run(ctx, job)
+}
+
+// next removes the first job from the queue whose gateway is below its
+// concurrency limit. It returns nil if the scheduler is closed and there's
+// nothing left to do.
+func (s *Scheduler) next(ctx context.Context) (*Job, error) {
+	for {
+		s.mu.Lock()
+		for i, job := range s.queue {
+			if !s.canStart(job) {
+				continue
+			}
+
+			s.queue = slices.Delete(s.queue, i, i+1)
+			s.queued[job.Request.Gateway] -= 1
+			if s.queued[job.Request.Gateway] == 0 {
+				delete(s.queued, job.Request.Gateway)
+				s.drained = true
+			}
+			s.active[job.Request.Gateway] += 1
+			s.running += 1
+			s.notify()
+			s.mu.Unlock()
+
+			return job, nil
+		}
+
+		// running jobs may still push follow-up jobs
+		if s.closed && len(s.queue) == 0 && s.running == 0 {
+			s.mu.Unlock()
+			return nil, nil
+		}
+
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (s *Scheduler) done(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active[job.Request.Gateway] -= 1
+	if s.active[job.Request.Gateway] == 0 {
+		delete(s.active, job.Request.Gateway)
+	}
+	s.running -= 1
+	s.notify()
+}
+
+// canStart must be called with mu held.
+func (s *Scheduler) canStart(job *Job) bool {
+	return s.cfg.GatewayConcurrency == 0 || s.active[job.Request.Gateway] < s.cfg.GatewayConcurrency
+}
+
+// notify wakes up all goroutines that wait for a change. It must be called
+// with mu held.
+func (s *Scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
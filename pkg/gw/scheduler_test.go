@@ -0,0 +1,192 @@
+package gw
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_slowGateway(t *testing.T) {
+	s, err := NewScheduler(&SchedulerConfig{Concurrency: 2, GatewayConcurrency: 1})
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	var fast []int
+	slowActive, slowMax := 0, 0
+
+	for i := range 3 {
+		s.Push(&Job{
+			Request: Request{Gateway: "slow"},
+			Run: func(ctx context.Context, job *Job) error {
+				mu.Lock()
+				slowActive += 1
+				slowMax = max(slowMax, slowActive)
+				mu.Unlock()
+
+				<-release
+
+				mu.Lock()
+				slowActive -= 1
+				mu.Unlock()
+				return nil
+			},
+		})
+		s.Push(&Job{
+			Request: Request{Gateway: "fast"},
+			Run: func(ctx context.Context, job *Job) error {
+				mu.Lock()
+				fast = append(fast, i)
+				mu.Unlock()
+				return nil
+			},
+		})
+	}
+	s.Close()
+
+	done := make(chan error)
+	go func() { done <- s.Run(context.Background()) }()
+
+	// all probes of the fast gateway finish while the slow one hangs
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(fast) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []int{0, 1, 2}, fast)
+	assert.Equal(t, 2, s.Len())
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, 1, slowMax)
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestScheduler_followUp(t *testing.T) {
+	s, err := NewScheduler(&SchedulerConfig{Concurrency: 1, GatewayConcurrency: 1})
+	require.NoError(t, err)
+
+	var order []string
+	var run func(ctx context.Context, job *Job) error
+	run = func(ctx context.Context, job *Job) error {
+		order = append(order, string(job.Request.Format)+string(rune('0'+job.Request.Attempt)))
+		if job.Request.Attempt == 0 {
+			followUp := *job
+			followUp.Request.Attempt += 1
+			s.PushFront(&followUp)
+		}
+		return nil
+	}
+
+	s.Push(&Job{Request: Request{Gateway: "gw", Format: "a"}, Run: run})
+	s.Push(&Job{Request: Request{Gateway: "gw", Format: "b"}, Run: run})
+	s.Close()
+
+	require.NoError(t, s.Run(context.Background()))
+	assert.Equal(t, []string{"a0", "a1", "b0", "b1"}, order)
+}
+
+func TestScheduler_error(t *testing.T) {
+	s, err := NewScheduler(&SchedulerConfig{Concurrency: 2})
+	require.NoError(t, err)
+
+	errFailed := errors.New("failed")
+	s.Push(&Job{Request: Request{Gateway: "gw"}, Run: func(ctx context.Context, job *Job) error { return errFailed }})
+
+	// no Close, Run must return anyway
+	assert.ErrorIs(t, s.Run(context.Background()), errFailed)
+}
+
+func TestScheduler_Full(t *testing.T) {
+	s, err := NewScheduler(&SchedulerConfig{Concurrency: 1, GatewayQueueSize: 2})
+	require.NoError(t, err)
+
+	noop := func(ctx context.Context, job *Job) error { return nil }
+
+	s.Push(&Job{Request: Request{Gateway: "gw"}, Run: noop})
+	assert.False(t, s.Full("gw"))
+	s.Push(&Job{Request: Request{Gateway: "gw"}, Run: noop})
+	assert.True(t, s.Full("gw"))
+	assert.False(t, s.Full("other"))
+
+	s.Close()
+	require.NoError(t, s.Run(context.Background()))
+	assert.False(t, s.Full("gw"))
+}
+
+func TestScheduler_WaitCapacity(t *testing.T) {
+	s, err := NewScheduler(&SchedulerConfig{Concurrency: 1, GatewayConcurrency: 1})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// an empty queue has capacity
+	require.NoError(t, s.WaitCapacity(ctx))
+
+	s.Push(&Job{Request: Request{Gateway: "gw"}, Run: func(ctx context.Context, job *Job) error { return nil }})
+	assert.ErrorIs(t, s.WaitCapacity(ctx), context.DeadlineExceeded)
+}
+
+func TestScheduler_WaitCapacity_drained(t *testing.T) {
+	s, err := NewScheduler(&SchedulerConfig{Concurrency: 2, GatewayConcurrency: 1})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	go func() { _ = s.Run(ctx) }()
+	defer s.Close()
+
+	// a gateway that isn't probed again, e.g., because it was removed
+	ran := make(chan struct{})
+	s.Push(&Job{Request: Request{Gateway: "removed"}, Run: func(ctx context.Context, job *Job) error { close(ran); return nil }})
+	<-ran
+	require.NoError(t, s.WaitCapacity(ctx))
+
+	release := make(chan struct{})
+	slow := func(ctx context.Context, job *Job) error { <-release; return nil }
+	s.Push(&Job{Request: Request{Gateway: "slow"}, Run: slow})
+	s.Push(&Job{Request: Request{Gateway: "slow"}, Run: slow})
+
+	// the removed gateway has no queued jobs, but it doesn't count as drained
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.WaitCapacity(timeoutCtx), context.DeadlineExceeded)
+
+	// once the last job of the slow gateway starts, it has run out of jobs
+	release <- struct{}{}
+	require.NoError(t, s.WaitCapacity(ctx))
+	close(release)
+}
+
+func TestScheduler_rate(t *testing.T) {
+	s, err := NewScheduler(&SchedulerConfig{Concurrency: 4, Rate: 50})
+	require.NoError(t, err)
+
+	for range 6 {
+		s.Push(&Job{Request: Request{Gateway: "gw"}, Run: func(ctx context.Context, job *Job) error { return nil }})
+	}
+	s.Close()
+
+	start := time.Now()
+	require.NoError(t, s.Run(context.Background()))
+
+	// the first job starts right away, the others every 20ms
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestNewScheduler_invalid(t *testing.T) {
+	for _, cfg := range []*SchedulerConfig{
+		{Concurrency: 0},
+		{Concurrency: 1, GatewayConcurrency: -1},
+		{Concurrency: 1, Rate: -1},
+	} {
+		_, err := NewScheduler(cfg)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,194 @@
+package gw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg/db"
+)
+
+// SizePrecheck is how the expected size of the response body is learned
+// before a probe if the response itself may not announce it.
+type SizePrecheck string
+
+const (
+	// SizePrecheckHead sends a HEAD request for the same URL and takes the
+	// Content-Length of its response.
+	SizePrecheckHead SizePrecheck = "head"
+	// SizePrecheckBlock requests the terminal block of the content path with
+	// dag-scope=block and takes the size of the UnixFS file it describes. It
+	// only applies to requests of the none format, whose body is the file.
+	SizePrecheckBlock SizePrecheck = "block"
+)
+
+// SizePrechecks are all supported size pre-checks.
+var SizePrechecks = []SizePrecheck{SizePrecheckHead, SizePrecheckBlock}
+
+// ParseSizePrecheck parses the size pre-check as it's given on the command
+// line. The empty string and "none" disable the pre-check.
+func ParseSizePrecheck(s string) (SizePrecheck, error) {
+	if s == "" || s == "none" {
+		return "", nil
+	}
+	for _, sp := range SizePrechecks {
+		if string(sp) == s {
+			return sp, nil
+		}
+	}
+	return "", fmt.Errorf("unknown size pre-check %q (supported: none, %v)", s, SizePrechecks)
+}
+
+// The sources of the expected size of a response body.
+const (
+	SizeSourceContentLength = "content-length"
+	SizeSourceHead          = "head"
+	SizeSourceUnixFS        = "unixfs"
+)
+
+// maxBlockSize bounds the response of the block pre-check. Gateways don't
+// serve blocks larger than 2 MiB.
+const maxBlockSize = 2 << 20
+
+// sizePrecheck learns the expected size of the response body of the request
+// with its size pre-check. It returns nil if the size couldn't be learned,
+// and the source of the size otherwise.
+func (p *Prober) sizePrecheck(ctx context.Context, req *Request, url string) (*int64, string) {
+	switch req.SizePrecheck {
+	case SizePrecheckHead:
+		resp, err := p.sizePrecheckRequest(ctx, req, http.MethodHead, url)
+		if err != nil {
+			return nil, ""
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+			return nil, ""
+		}
+		return &resp.ContentLength, SizeSourceHead
+
+	case SizePrecheckBlock:
+		if req.Format != db.GatewayProbeFormatNone {
+			return nil, ""
+		}
+
+		blockReq := *req
+		blockReq.Format = db.GatewayProbeFormatCAR
+		blockReq.CAR = CARParams{DAGScope: DAGScopeBlock}
+		blockURL, err := blockReq.URL()
+		if err != nil {
+			return nil, ""
+		}
+
+		resp, err := p.sizePrecheckRequest(ctx, &blockReq, http.MethodGet, blockURL)
+		if err != nil {
+			return nil, ""
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, ""
+		}
+
+		size := unixfsSize(io.LimitReader(resp.Body, maxBlockSize))
+		if size == nil {
+			return nil, ""
+		}
+		return size, SizeSourceUnixFS
+
+	default:
+		return nil, ""
+	}
+}
+
+// sizePrecheckRequest sends the request of a size pre-check on a fresh
+// connection. The caller must close the response body.
+func (p *Prober) sizePrecheckRequest(ctx context.Context, req *Request, method string, url string) (*http.Response, error) {
+	transport, err := p.newTransport(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := p.newHTTPRequest(ctx, req, url)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	httpReq.Method = method
+
+	client := &http.Client{Transport: transport, Timeout: p.cfg.Timeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	resp.Body = &closeBoth{ReadCloser: resp.Body, transport: transport}
+	return resp, nil
+}
+
+// closeBoth closes the transport of a response along with its body.
+type closeBoth struct {
+	io.ReadCloser
+	transport transport
+}
+
+func (c *closeBoth) Close() error {
+	return errors.Join(c.ReadCloser.Close(), c.transport.Close())
+}
+
+// unixfsSize reads the CAR of a dag-scope=block request and returns the size
+// of the terminal element: the size of a raw block or the file size of a
+// UnixFS file. It returns nil for anything else, e.g., directories. The
+// terminal block is the last one, after the blocks of the path.
+func unixfsSize(r io.Reader) *int64 {
+	br, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return nil
+	}
+
+	var c cid.Cid
+	var data []byte
+	for {
+		blk, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil
+		}
+		c, data = blk.Cid(), blk.RawData()
+	}
+
+	switch {
+	case !c.Defined():
+		return nil
+	case c.Type() == cid.Raw:
+		return ptr.From(int64(len(data)))
+	case c.Type() != cid.DagProtobuf:
+		return nil
+	}
+
+	nd, err := merkledag.DecodeProtobuf(data)
+	if err != nil {
+		return nil
+	}
+
+	fsNode, err := unixfs.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		return nil
+	}
+
+	switch fsNode.Type() {
+	case unixfs.TFile, unixfs.TRaw:
+		return ptr.From(int64(fsNode.FileSize()))
+	default:
+		return nil
+	}
+}
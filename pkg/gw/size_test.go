@@ -0,0 +1,127 @@
+package gw
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber_Probe_maxBytesTruncation(t *testing.T) {
+	tests := []struct {
+		name            string
+		noContentLength bool
+		maxBytes        int64
+		truncated       bool
+		expectedSize    *int64
+	}{
+		{name: "content-length", maxBytes: 100, truncated: true, expectedSize: ptr.From[int64](5000)},
+		{name: "content-length complete", maxBytes: 5000, expectedSize: ptr.From[int64](5000)},
+		{name: "chunked", noContentLength: true, maxBytes: 100, truncated: true},
+		{name: "chunked complete", noContentLength: true, maxBytes: 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prober, gateway, path := newTestProber(t)
+			prober.cfg.MaxBytes = tt.maxBytes
+			gateway.NoContentLength = tt.noContentLength
+
+			result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatRaw})
+			require.NoError(t, result.Err)
+			assert.Equal(t, tt.maxBytes, result.BytesReceived)
+			assert.Equal(t, tt.truncated, result.Truncated)
+			assert.Equal(t, tt.expectedSize, result.ExpectedSize)
+
+			// a cut-off body isn't invalid
+			if tt.truncated {
+				assert.Nil(t, result.FormatValid)
+			} else {
+				assert.Equal(t, ptr.From(true), result.FormatValid)
+			}
+
+			m := result.Model()
+			assert.Equal(t, tt.truncated, m.Truncated)
+			assert.Equal(t, tt.expectedSize, m.ExpectedSize)
+		})
+	}
+}
+
+func TestProber_Probe_truncatedCAR(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	prober.cfg.MaxBytes = 200
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatCAR})
+	require.NoError(t, result.Err)
+	assert.True(t, result.Truncated)
+	assert.Nil(t, result.FormatValid)
+	assert.Equal(t, ptr.From(true), result.CARValidated)
+	assert.False(t, result.CAR.Complete)
+
+	// even the header is cut off
+	prober.cfg.MaxBytes = 10
+
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatCAR})
+	require.NoError(t, result.Err)
+	assert.True(t, result.Truncated)
+	assert.Nil(t, result.CARValidated)
+}
+
+func TestProber_Probe_sizePrecheck(t *testing.T) {
+	tests := []struct {
+		precheck SizePrecheck
+		format   db.GatewayProbeFormat
+		method   string
+		source   string
+	}{
+		{precheck: SizePrecheckHead, format: db.GatewayProbeFormatCAR, method: http.MethodHead, source: SizeSourceHead},
+		{precheck: SizePrecheckBlock, format: db.GatewayProbeFormatNone, method: http.MethodGet, source: SizeSourceUnixFS},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.precheck), func(t *testing.T) {
+			prober, gateway, path := newTestProber(t)
+			gateway.NoContentLength = true
+
+			req := &Request{Gateway: gateway.URL, Path: path, Format: tt.format, SizePrecheck: tt.precheck}
+
+			// the cold attempt isn't pre-checked
+			result := prober.Probe(context.Background(), req)
+			require.NoError(t, result.Err)
+			assert.Nil(t, result.ExpectedSize)
+			require.Len(t, gateway.Requests(), 1)
+
+			warmReq := *req
+			warmReq.Attempt = 1
+			result = prober.Probe(context.Background(), &warmReq)
+			require.NoError(t, result.Err)
+
+			var expectedSize int64 = 5000
+			if tt.format == db.GatewayProbeFormatCAR {
+				expectedSize = result.BytesReceived
+			}
+			assert.Equal(t, &expectedSize, result.ExpectedSize)
+			assert.Equal(t, tt.source, result.ExpectedSizeSource)
+			assert.Equal(t, ptr.From(tt.source), result.Model().ExpectedSizeSource)
+
+			requests := gateway.Requests()
+			require.Len(t, requests, 3)
+			assert.Equal(t, tt.method, requests[1].Method)
+		})
+	}
+}
+
+func TestParseSizePrecheck(t *testing.T) {
+	for s, expected := range map[string]SizePrecheck{"": "", "none": "", "head": SizePrecheckHead, "block": SizePrecheckBlock} {
+		sp, err := ParseSizePrecheck(s)
+		require.NoError(t, err)
+		assert.Equal(t, expected, sp)
+	}
+
+	_, err := ParseSizePrecheck("options")
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package gw
+
+import (
+	"io"
+	"time"
+
+	"github.com/probe-lab/go-commons/ptr"
+)
+
+// Sample is the number of body bytes that arrived until a point in time.
+type Sample struct {
+	Time  time.Time
+	Bytes int64 // cumulative
+}
+
+// timelineReader records a sample for every read of the body that returned
+// data. The samples start with the time the reader was created, which is when
+// the response headers arrived, and end with the read that returned EOF or
+// an error.
+type timelineReader struct {
+	r       io.Reader
+	now     func() time.Time
+	bytes   int64
+	samples []Sample
+}
+
+func newTimelineReader(r io.Reader) *timelineReader {
+	t := &timelineReader{r: r, now: time.Now}
+	t.samples = append(t.samples, Sample{Time: t.now()})
+	return t
+}
+
+func (t *timelineReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.bytes += int64(n)
+	if n > 0 || err != nil {
+		t.samples = append(t.samples, Sample{Time: t.now(), Bytes: t.bytes})
+	}
+	return n, err
+}
+
+// Timeline is the arrival of the body bytes of a probe over time.
+type Timeline struct {
+	// Samples are in order, and the first sample is the arrival of the
+	// response headers with 0 bytes. The last sample is the end of the
+	// download, which may not have added any bytes.
+	Samples []Sample
+
+	// StallThreshold is the minimum gap between two samples that counts as
+	// a stall. Zero counts none.
+	StallThreshold time.Duration
+}
+
+// TimeTo returns the time from start until the share (0, 1] of the total
+// bytes had arrived, or nil if they never did. The total is the expected size
+// of the body, or the bytes received if it's unknown.
+func (t *Timeline) TimeTo(start time.Time, share float64, total int64) *time.Duration {
+	if total <= 0 {
+		return nil
+	}
+
+	target := int64(share * float64(total))
+	for _, s := range t.Samples {
+		if s.Bytes > 0 && s.Bytes >= target {
+			return ptr.From(s.Time.Sub(start))
+		}
+	}
+
+	return nil
+}
+
+// TransferSpeedMbps returns the throughput of the body transfer in megabits
+// per second, from the arrival of its first to its last bytes. Unlike
+// Result.DownloadSpeedMbps, it doesn't include the time to first byte. It
+// returns nil if the body arrived in a single read.
+func (t *Timeline) TransferSpeedMbps() *float64 {
+	var first, last *Sample
+	for i, s := range t.Samples {
+		if i == 0 || s.Bytes == t.Samples[i-1].Bytes {
+			continue
+		}
+		if first == nil {
+			first = &t.Samples[i]
+		}
+		last = &t.Samples[i]
+	}
+
+	if first == nil || first == last {
+		return nil
+	}
+
+	durationS := last.Time.Sub(first.Time).Seconds()
+	if durationS <= 0 {
+		return nil
+	}
+
+	// the bytes of the first read arrived at its start
+	speedBps := float64(last.Bytes-first.Bytes) / durationS
+	return ptr.From((speedBps * 8) / (1024 * 1024))
+}
+
+// Stalls returns the longest gap between two samples and the number of gaps
+// of at least the stall threshold. The gap between the response headers and
+// the first body bytes counts, too.
+func (t *Timeline) Stalls() (time.Duration, int) {
+	var longest time.Duration
+	var count int
+	for i := 1; i < len(t.Samples); i++ {
+		gap := t.Samples[i].Time.Sub(t.Samples[i-1].Time)
+		longest = max(longest, gap)
+		if t.StallThreshold > 0 && gap >= t.StallThreshold {
+			count += 1
+		}
+	}
+	return longest, count
+}
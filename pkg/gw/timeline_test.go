@@ -0,0 +1,76 @@
+package gw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/probe-lab/go-commons/ptr"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber_Probe_stall(t *testing.T) {
+	prober, gateway, path := newTestProber(t)
+	prober.cfg.StallThreshold = 100 * time.Millisecond
+	gateway.StallAfter = 1000
+	gateway.StallDuration = 200 * time.Millisecond
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatRaw})
+	require.NoError(t, result.Err)
+	require.NotNil(t, result.Timeline)
+
+	m := result.Model()
+	require.NotNil(t, m.LongestStallS)
+	assert.GreaterOrEqual(t, *m.LongestStallS, 0.2)
+	assert.Equal(t, int32(1), m.StallCount)
+
+	// a fifth of the content arrived before the stall, the rest after it
+	require.NotNil(t, m.TimeTo25PctS)
+	require.NotNil(t, m.TimeTo100PctS)
+	assert.GreaterOrEqual(t, *m.TimeTo25PctS, 0.2)
+	assert.GreaterOrEqual(t, *m.TimeTo100PctS, *m.TimeTo25PctS)
+	assert.NotNil(t, m.TransferSpeedMbps)
+
+	// the probe without a stall
+	gateway.StallAfter = 0
+	result = prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatRaw})
+	require.NoError(t, result.Err)
+	assert.Zero(t, result.Model().StallCount)
+}
+
+func TestTimeline(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	timeline := &Timeline{
+		Samples: []Sample{
+			{Time: at(100), Bytes: 0}, // headers
+			{Time: at(150), Bytes: 250_000},
+			{Time: at(200), Bytes: 500_000},
+			{Time: at(1200), Bytes: 750_000}, // after a stall
+			{Time: at(1250), Bytes: 1_000_000},
+			{Time: at(1260), Bytes: 1_000_000}, // EOF
+		},
+		StallThreshold: 500 * time.Millisecond,
+	}
+
+	assert.Equal(t, ptr.From(150*time.Millisecond), timeline.TimeTo(start, 0.25, 1_000_000))
+	assert.Equal(t, ptr.From(200*time.Millisecond), timeline.TimeTo(start, 0.5, 1_000_000))
+	assert.Equal(t, ptr.From(1250*time.Millisecond), timeline.TimeTo(start, 1, 1_000_000))
+	assert.Nil(t, timeline.TimeTo(start, 1, 2_000_000))
+	assert.Nil(t, timeline.TimeTo(start, 1, 0))
+
+	// 750 kB from the first to the last body bytes in 1.1s
+	require.NotNil(t, timeline.TransferSpeedMbps())
+	assert.InDelta(t, 750_000*8/1.1/(1024*1024), *timeline.TransferSpeedMbps(), 0.001)
+
+	longest, stalls := timeline.Stalls()
+	assert.Equal(t, time.Second, longest)
+	assert.Equal(t, 1, stalls)
+
+	// a body that arrived in a single read has no transfer speed
+	single := &Timeline{Samples: []Sample{{Time: at(100)}, {Time: at(110), Bytes: 10}, {Time: at(111), Bytes: 10}}}
+	assert.Nil(t, single.TransferSpeedMbps())
+}
@@ -0,0 +1,223 @@
+package gw
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/probe-lab/tiros/pkg/db"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// TLSInfo is the TLS connection and the leaf certificate of a gateway as seen
+// in the last TLS handshake of a probe. The certificate is also inspected if
+// its verification failed, e.g., because it expired or doesn't match the host.
+type TLSInfo struct {
+	Host        string
+	Version     string // e.g., "TLS 1.3", empty if the handshake failed
+	CipherSuite string
+
+	Issuer        string
+	Subject       string
+	SANs          []string // DNS names and IP addresses
+	NotBefore     time.Time
+	NotAfter      time.Time
+	OCSPStapled   bool
+	HostnameValid bool
+	VerifyErr     error
+}
+
+// newTLSInfo inspects the state and error of a handshake with the host. It
+// returns nil if the server didn't present a certificate.
+func newTLSInfo(host string, state tls.ConnectionState, err error) *TLSInfo {
+	certs := state.PeerCertificates
+
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		certs = verifyErr.UnverifiedCertificates
+	}
+
+	if len(certs) == 0 {
+		return nil
+	}
+	leaf := certs[0]
+
+	info := &TLSInfo{
+		Host:          host,
+		Issuer:        leaf.Issuer.String(),
+		Subject:       leaf.Subject.String(),
+		SANs:          leaf.DNSNames,
+		NotBefore:     leaf.NotBefore,
+		NotAfter:      leaf.NotAfter,
+		OCSPStapled:   len(state.OCSPResponse) > 0,
+		HostnameValid: leaf.VerifyHostname(host) == nil,
+	}
+	for _, ip := range leaf.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+
+	if state.Version != 0 {
+		info.Version = tls.VersionName(state.Version)
+		info.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	}
+
+	if verifyErr != nil {
+		info.VerifyErr = verifyErr.Err
+	}
+
+	return info
+}
+
+// ExpiresIn returns the time until the certificate expires. It's negative
+// for expired certificates.
+func (i *TLSInfo) ExpiresIn(now time.Time) time.Duration {
+	return i.NotAfter.Sub(now)
+}
+
+// Model converts the TLS info of the gateway into a database model. The
+// caller is expected to fill in the run metadata.
+func (i *TLSInfo) Model(gateway string) *db.GatewayCertificateModel {
+	m := &db.GatewayCertificateModel{
+		Gateway:       gateway,
+		Host:          i.Host,
+		TLSVersion:    i.Version,
+		CipherSuite:   i.CipherSuite,
+		Issuer:        i.Issuer,
+		Subject:       i.Subject,
+		SANs:          i.SANs,
+		NotBefore:     i.NotBefore,
+		NotAfter:      i.NotAfter,
+		OCSPStapled:   i.OCSPStapled,
+		HostnameValid: i.HostnameValid,
+		CreatedAt:     time.Now(),
+	}
+	if i.VerifyErr != nil {
+		m.VerifyError = toPtr(i.VerifyErr.Error())
+	}
+	return m
+}
+
+// TLSMonitorConfig configures a TLSMonitor.
+type TLSMonitorConfig struct {
+	// Interval is the minimum duration between two inspections of the same
+	// gateway.
+	Interval time.Duration
+
+	// ExpiryWarning is the remaining validity of a certificate below which
+	// it's reported as expiring.
+	ExpiryWarning time.Duration
+}
+
+// DefaultTLSMonitorConfig returns the default configuration of a TLSMonitor.
+func DefaultTLSMonitorConfig() *TLSMonitorConfig {
+	return &TLSMonitorConfig{
+		Interval:      5 * time.Minute,
+		ExpiryWarning: 14 * 24 * time.Hour,
+	}
+}
+
+// TLSMonitor watches the certificates of gateways. It inspects the TLS info of
+// a gateway at most once per interval, exports the time until its certificate
+// expires and whether it matches the host as metrics, and warns about
+// certificates that are about to expire or don't match. It's safe for
+// concurrent use.
+type TLSMonitor struct {
+	cfg *TLSMonitorConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	inspected map[string]time.Time
+
+	expiry   metric.Float64Gauge
+	expiring metric.Int64Gauge
+	mismatch metric.Int64Gauge
+}
+
+// NewTLSMonitor creates a TLSMonitor and its metrics.
+func NewTLSMonitor(cfg *TLSMonitorConfig) (*TLSMonitor, error) {
+	meter := otel.GetMeterProvider().Meter("tiros")
+
+	expiry, err := meter.Float64Gauge(
+		"gateway_tls_cert_expiry",
+		metric.WithDescription("Time until the TLS certificate of a gateway expires"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating gateway_tls_cert_expiry gauge: %w", err)
+	}
+
+	expiring, err := meter.Int64Gauge(
+		"gateway_tls_cert_expiring",
+		metric.WithDescription("Whether the TLS certificate of a gateway expires within the warning period"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating gateway_tls_cert_expiring gauge: %w", err)
+	}
+
+	mismatch, err := meter.Int64Gauge(
+		"gateway_tls_hostname_mismatch",
+		metric.WithDescription("Whether the TLS certificate of a gateway doesn't match its host name"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating gateway_tls_hostname_mismatch gauge: %w", err)
+	}
+
+	return &TLSMonitor{
+		cfg:       cfg,
+		now:       time.Now,
+		inspected: map[string]time.Time{},
+		expiry:    expiry,
+		expiring:  expiring,
+		mismatch:  mismatch,
+	}, nil
+}
+
+// Inspect records the metrics of the gateway's TLS info and reports whether
+// it should be stored. It returns false if the gateway was inspected within
+// the interval.
+func (m *TLSMonitor) Inspect(ctx context.Context, gateway string, info *TLSInfo) bool {
+	if info == nil {
+		return false
+	}
+
+	now := m.now()
+
+	m.mu.Lock()
+	if last, found := m.inspected[gateway]; found && now.Sub(last) < m.cfg.Interval {
+		m.mu.Unlock()
+		return false
+	}
+	m.inspected[gateway] = now
+	m.mu.Unlock()
+
+	expiresIn := info.ExpiresIn(now)
+	expiring := expiresIn < m.cfg.ExpiryWarning
+
+	attrs := metric.WithAttributes(attribute.String("gateway", gateway))
+	m.expiry.Record(ctx, expiresIn.Seconds(), attrs)
+	m.expiring.Record(ctx, boolToInt64(expiring), attrs)
+	m.mismatch.Record(ctx, boolToInt64(!info.HostnameValid), attrs)
+
+	logger := slog.With("gateway", gateway, "host", info.Host, "issuer", info.Issuer, "not_after", info.NotAfter)
+	if expiring {
+		logger.With("expires_in", expiresIn.Round(time.Minute)).Warn("Gateway TLS certificate expires soon")
+	}
+	if !info.HostnameValid {
+		logger.With("sans", info.SANs).Warn("Gateway TLS certificate doesn't match its host")
+	}
+
+	return true
+}
+
+func boolToInt64(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package gw
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/probe-lab/tiros/pkg"
+	"github.com/probe-lab/tiros/pkg/db"
+	"github.com/probe-lab/tiros/pkg/gw/gwtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProber_Probe_tls(t *testing.T) {
+	gateway := gwtest.NewTLS(t)
+	path := pkg.CIDPath(gateway.Add(bytes.Repeat([]byte("tiros"), 1000)))
+
+	resolver, err := ParseResolver("udp://"+gwtest.NewDNS(t).Addr(), false)
+	require.NoError(t, err)
+
+	prober := NewProber(&ProberConfig{
+		Timeout:         5 * time.Second,
+		MaxBytes:        1 << 20,
+		TLSClientConfig: gateway.TLSClientConfig(),
+		Resolver:        resolver,
+	})
+
+	result := prober.Probe(context.Background(), &Request{Gateway: gateway.URL, Path: path, Format: db.GatewayProbeFormatNone})
+	require.NoError(t, result.Err)
+	require.NotNil(t, result.TLS)
+
+	info := result.TLS
+	assert.Equal(t, "127.0.0.1", info.Host)
+	assert.Equal(t, "TLS 1.3", info.Version)
+	assert.NotEmpty(t, info.CipherSuite)
+	assert.Contains(t, info.SANs, "127.0.0.1")
+	assert.Equal(t, gateway.Certificate().NotAfter, info.NotAfter)
+	assert.False(t, info.OCSPStapled)
+	assert.True(t, info.HostnameValid)
+	assert.NoError(t, info.VerifyErr)
+
+	m := info.Model("gateway")
+	assert.Equal(t, "gateway", m.Gateway)
+	assert.Equal(t, "TLS 1.3", m.TLSVersion)
+	assert.True(t, m.HostnameValid)
+	assert.Nil(t, m.VerifyError)
+
+	// the fake DNS server resolves a host name that isn't in the certificate
+	gatewayURL := strings.Replace(gateway.URL, "127.0.0.1", "gateway.test", 1)
+
+	result = prober.Probe(context.Background(), &Request{Gateway: gatewayURL, Path: path, Format: db.GatewayProbeFormatNone})
+	require.Error(t, result.Err)
+	require.NotNil(t, result.TLS)
+
+	info = result.TLS
+	assert.Equal(t, "gateway.test", info.Host)
+	assert.False(t, info.HostnameValid)
+	assert.ErrorAs(t, info.VerifyErr, &x509.HostnameError{})
+	assert.NotNil(t, info.Model("gateway").VerifyError)
+
+	// plain HTTP has no TLS
+	plain, plainGateway, plainPath := newTestProber(t)
+	result = plain.Probe(context.Background(), &Request{Gateway: plainGateway.URL, Path: plainPath, Format: db.GatewayProbeFormatNone})
+	require.NoError(t, result.Err)
+	assert.Nil(t, result.TLS)
+}
+
+func TestTLSMonitor_Inspect(t *testing.T) {
+	m, err := NewTLSMonitor(&TLSMonitorConfig{Interval: time.Minute, ExpiryWarning: 24 * time.Hour})
+	require.NoError(t, err)
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	info := &TLSInfo{Host: "gateway", NotAfter: now.Add(time.Hour), HostnameValid: true}
+
+	assert.False(t, m.Inspect(context.Background(), "gateway", nil))
+	assert.True(t, m.Inspect(context.Background(), "gateway", info))
+	assert.False(t, m.Inspect(context.Background(), "gateway", info))
+	assert.True(t, m.Inspect(context.Background(), "other", info))
+
+	now = now.Add(time.Minute)
+	assert.True(t, m.Inspect(context.Background(), "gateway", info))
+	assert.Equal(t, 59*time.Minute, info.ExpiresIn(now))
+}
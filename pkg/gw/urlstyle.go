@@ -0,0 +1,84 @@
+package gw
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/go-cid"
+	mbase "github.com/multiformats/go-multibase"
+	"github.com/probe-lab/tiros/pkg"
+)
+
+// URLStyle is how the content path is encoded in the URL of a request.
+type URLStyle string
+
+const (
+	// URLStylePath requests https://<gateway>/ipfs/<cid>/<sub/path>.
+	URLStylePath URLStyle = "path"
+	// URLStyleSubdomain requests https://<cidv1>.ipfs.<gateway>/<sub/path>,
+	// which gives every content root its own origin.
+	URLStyleSubdomain URLStyle = "subdomain"
+)
+
+// URLStyles are all supported URL styles.
+var URLStyles = []URLStyle{URLStylePath, URLStyleSubdomain}
+
+// ParseURLStyle parses the name of a URL style as it's given on the command
+// line.
+func ParseURLStyle(s string) (URLStyle, error) {
+	for _, style := range URLStyles {
+		if string(style) == s {
+			return style, nil
+		}
+	}
+	return "", fmt.Errorf("unknown url style %q (supported: %v)", s, URLStyles)
+}
+
+func (s URLStyle) orDefault() URLStyle {
+	if s == "" {
+		return URLStylePath
+	}
+	return s
+}
+
+// subdomainLabel returns the DNS label of the root of a content path on a
+// subdomain gateway: CIDs are converted to case-insensitive CIDv1, IPNS keys
+// to base36, and the dots of DNSLink domains to dashes.
+func subdomainLabel(path pkg.ContentPath) (string, error) {
+	switch path.ResolutionType() {
+	case pkg.ResolutionTypeIPNS:
+		name, err := ipns.NameFromString(path.Root)
+		if err != nil {
+			return "", fmt.Errorf("parsing ipns name: %w", err)
+		}
+		return name.String(), nil
+
+	case pkg.ResolutionTypeDNSLink:
+		return strings.ReplaceAll(strings.ReplaceAll(path.Root, "-", "--"), ".", "-"), nil
+
+	default:
+		c := path.CID()
+		if !c.Defined() {
+			return "", fmt.Errorf("invalid cid %q", path.Root)
+		}
+
+		// DNS labels are limited to 63 characters, which base32 exceeds for
+		// hashes longer than 256 bits
+		label, err := cid.NewCidV1(c.Type(), c.Hash()).StringOfBase(mbase.Base32)
+		if err == nil && len(label) > 63 {
+			label, err = cid.NewCidV1(c.Type(), c.Hash()).StringOfBase(mbase.Base36)
+		}
+		if err != nil {
+			return "", fmt.Errorf("encoding cid: %w", err)
+		}
+		return label, nil
+	}
+}
+
+// isSubdomainHost reports whether the host is of the form
+// <root>.ipfs.<gateway> or <root>.ipns.<gateway>.
+func isSubdomainHost(host string) bool {
+	labels := strings.Split(host, ".")
+	return len(labels) >= 3 && (labels[1] == "ipfs" || labels[1] == "ipns")
+}
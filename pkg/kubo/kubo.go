@@ -517,6 +517,57 @@ func (k *Kubo) FindProviders(ctx context.Context, website string, results chan<-
 	return nil
 }
 
+// FindProvs looks up the provider records of the given CID in the routing
+// system and returns the peer IDs of at most numProviders providers.
+func (k *Kubo) FindProvs(ctx context.Context, c cid.Cid, numProviders int) ([]peer.ID, error) {
+	resp, err := k.
+		Request("routing/findprovs").
+		Option("arg", c.String()).
+		Option("num-providers", strconv.Itoa(numProviders)).
+		Send(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("routing/findprovs: %w", err)
+	} else if resp.Error != nil {
+		return nil, fmt.Errorf("routing/findprovs: %w", resp.Error)
+	}
+	defer pllog.Defer(resp.Close, "Failed closing routing/findprovs response")
+
+	var providers []peer.ID
+	dec := json.NewDecoder(resp.Output)
+	for dec.More() {
+		evt := routing.QueryEvent{}
+		if err = dec.Decode(&evt); err != nil {
+			return nil, fmt.Errorf("decode routing/findprovs response: %w", err)
+		}
+
+		if evt.Type != routing.Provider {
+			continue
+		}
+
+		for _, r := range evt.Responses {
+			providers = append(providers, r.ID)
+		}
+	}
+
+	return providers, nil
+}
+
+// DAGStat fetches every block of the DAG below the given CID (from the
+// network if they aren't available locally) and returns the number of blocks.
+func (k *Kubo) DAGStat(ctx context.Context, c cid.Cid) (int64, error) {
+	var out struct {
+		DagStats []struct {
+			NumBlocks int64
+		}
+	}
+	if err := k.Request("dag/stat", c.String()).Option("progress", false).Exec(ctx, &out); err != nil {
+		return 0, err
+	} else if len(out.DagStats) == 0 {
+		return 0, fmt.Errorf("dag/stat: no stats for %s", c)
+	}
+	return out.DagStats[0].NumBlocks, nil
+}
+
 func (k *Kubo) WebsiteURL(website string, protocol db.WebsiteProbeProtocol) string {
 	switch protocol {
 	case db.WebsiteProbeProtocolIPFS:
@@ -411,7 +411,9 @@ func (p *swProbe) handleAttachedToTarget(ctx context.Context, e *target.EventAtt
 
 				p.delegatedRouterRequests[e.RequestID] = &NetworkEventResponse{EventResponseReceived: e}
 			} else if _, ok := p.trustlessGateways[u.Host]; ok {
-				if strings.Contains(u.Path, p.cidv0.String()) || strings.Contains(u.Path, p.cidv1.String()) {
+				// for /ipns paths, we don't know the CID upfront and track
+				// all trustless gateway requests.
+				if !p.cidv1.Defined() || strings.Contains(u.Path, p.cidv0.String()) || strings.Contains(u.Path, p.cidv1.String()) {
 					p.trustlessGatewayRequests[e.RequestID] = e
 				}
 			}
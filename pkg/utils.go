@@ -42,3 +42,13 @@ func getHeaderValue[T any](header network.Headers, key string) (T, bool) {
 
 	return typed, true
 }
+
+// ParseResolvedRoot returns the first CID of an X-Ipfs-Roots header value,
+// which is the CID the root of the requested content path resolved to.
+func ParseResolvedRoot(ipfsRoots string) *string {
+	first, _, _ := strings.Cut(ipfsRoots, ",")
+	if first = strings.TrimSpace(first); first == "" {
+		return nil
+	}
+	return &first
+}